@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envInt reads an integer environment variable, returning def when it is unset or invalid
+func envInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning: invalid value for %s: %v", key, err)
+		return def
+	}
+	return n
+}
+
+// envBool reads a boolean environment variable, returning false when it is unset or invalid
+func envBool(key string) bool {
+	b, _ := strconv.ParseBool(os.Getenv(key))
+	return b
+}
+
+// envDuration reads a duration environment variable such as "24h", returning def when it is unset or invalid
+func envDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Warning: invalid value for %s: %v", key, err)
+		return def
+	}
+	return d
+}
+
+// envMap reads a comma-separated list of key=value pairs, lower-casing keys and values
+func envMap(key string) map[string]string {
+	values := make(map[string]string)
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok || k == "" || v == "" {
+			if item != "" {
+				log.Printf("Warning: ignoring invalid entry %q in %s, use key=value", item, key)
+			}
+			continue
+		}
+		values[strings.ToLower(k)] = strings.ToLower(v)
+	}
+	return values
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/importer"
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
+	"github.com/janyksteenbeek/gitcloner/pkg/source"
+)
+
+// runImport implements `gitcloner import`, which mirrors every repository of an
+// organization, group or user
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	includeSubgroups := fs.Bool("include-subgroups", false, "Also import the projects of GitLab subgroups")
+	skipForks := fs.Bool("skip-forks", false, "Leave out forks")
+	skipArchived := fs.Bool("skip-archived", false, "Leave out archived repositories")
+	include := fs.String("include", "", "Comma-separated glob patterns of owner/name to import, e.g. 'acme/api-*'")
+	exclude := fs.String("exclude", "", "Comma-separated glob patterns of owner/name to leave out")
+	from := fs.String("from", "", "Import the repositories listed in a YAML manifest")
+	dryRun := fs.Bool("dry-run", false, "Report what would be imported without creating mirrors")
+	workers := fs.Int("workers", envInt("IMPORT_WORKERS", 4), "Number of repositories imported at the same time")
+	checkpoint := fs.String("checkpoint", "", "File recording finished repositories, rerun with the same file to resume an interrupted import")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gitcloner import [flags] <github|gitlab|gitea>[@https://host] <org:name|group:path|user:name>...")
+		fmt.Fprintln(fs.Output(), "       gitcloner import [flags] --from <manifest.yaml>")
+		fs.PrintDefaults()
+	}
+	positional := parseInterspersed(fs, args)
+
+	// An interrupted import stops starting new repositories and still prints its summary
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	runner := importer.Runner{Workers: *workers, DryRun: *dryRun, Checkpoint: *checkpoint}
+
+	if *from != "" {
+		if len(positional) > 0 {
+			fs.Usage()
+			log.Fatal("import --from does not take a platform or targets")
+		}
+		entries, err := importer.LoadManifest(*from)
+		if err != nil {
+			log.Fatal(err)
+		}
+		jobs, err := importer.ManifestJobs(mirrorConfigFromEnv(), entries)
+		if err != nil {
+			log.Fatal(err)
+		}
+		runImportJobs(ctx, runner, jobs, nil)
+		return
+	}
+
+	if len(positional) < 2 {
+		fs.Usage()
+		log.Fatal("import needs a platform and at least one target")
+	}
+
+	var targets []importer.Target
+	for _, spec := range positional[1:] {
+		target, err := importer.ParseTarget(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		targets = append(targets, target)
+	}
+
+	config := mirrorConfigFromEnv()
+	src, config, err := source.ForPlatform(config, positional[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts := importer.Options{
+		ListOptions: source.ListOptions{
+			IncludeSubgroups: *includeSubgroups,
+			SkipForks:        *skipForks,
+			SkipArchived:     *skipArchived,
+		},
+		Include: splitList(*include),
+		Exclude: splitList(*exclude),
+	}
+
+	// A target that can't be listed is reported as failed, the other targets are still imported
+	var (
+		jobs     []importer.Job
+		unlisted []importer.Result
+	)
+	for _, target := range targets {
+		targetJobs, err := importer.TargetJobs(ctx, config, src, target, opts)
+		if err != nil {
+			log.Printf("Warning: Failed to list repositories of %s: %v", target, err)
+			unlisted = append(unlisted, importer.Result{Source: target.String(), Status: importer.StatusFailed, Reason: err.Error()})
+			continue
+		}
+		jobs = append(jobs, targetJobs...)
+	}
+	runImportJobs(ctx, runner, jobs, unlisted)
+}
+
+// runImportJobs runs jobs, prints a summary and exits with a non-zero code when
+// any repository failed. earlier holds results of failures before the jobs were built.
+func runImportJobs(ctx context.Context, runner importer.Runner, jobs []importer.Job, earlier []importer.Result) {
+	results, err := runner.Run(ctx, jobs)
+	if err != nil && !errors.Is(err, importer.ErrImportFailed) {
+		log.Fatal(err)
+	}
+	results = append(earlier, results...)
+	printImportSummary(results)
+
+	if failed := importer.Count(results)[importer.StatusFailed]; failed > 0 {
+		log.Fatalf("Failed to import %d of %d repositories", failed, len(results))
+	}
+}
+
+// printImportSummary prints the number of repositories per outcome and the reason of every failure
+func printImportSummary(results []importer.Result) {
+	counts := importer.Count(results)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tREPOSITORIES")
+	for _, status := range importer.Statuses {
+		fmt.Fprintf(w, "%s\t%d\n", status, counts[status])
+	}
+	w.Flush()
+
+	if counts[importer.StatusFailed] == 0 {
+		return
+	}
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FAILED\tREASON")
+	for _, result := range results {
+		if result.Status == importer.StatusFailed {
+			fmt.Fprintf(w, "%s\t%s\n", result.Source, secret.Redact(result.Reason))
+		}
+	}
+	w.Flush()
+}
+
+// parseInterspersed parses flags that may appear before, between or after the
+// positional arguments, which it returns
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		if args[0] == "--" {
+			return append(positional, args[1:]...)
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
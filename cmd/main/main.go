@@ -1,39 +1,69 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
+	"strconv"
+	"time"
 
+	"github.com/janyksteenbeek/gitcloner/pkg/githubapp"
+	"github.com/janyksteenbeek/gitcloner/pkg/importer"
 	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
 	"github.com/janyksteenbeek/gitcloner/pkg/webhook"
 
 	"github.com/joho/godotenv"
 )
 
 func main() {
+	// Every log line passes through the redaction of resolved secrets
+	log.SetOutput(secret.NewWriter(os.Stderr))
+
 	if err := godotenv.Load(); err != nil {
 		log.Printf("Warning: .env file not found: %v", err)
 	}
 
+	// Dispatch subcommands before parsing the server flags
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "restore":
+			runRestore(os.Args[2:])
+			return
+		case "import":
+			runImport(os.Args[2:])
+			return
+		case "rotate-credentials":
+			runRotateCredentials(os.Args[2:])
+			return
+		case "orphans":
+			runOrphans(os.Args[2:])
+			return
+		case "verify":
+			runVerify(os.Args[2:])
+			return
+		case "status":
+			runStatus(os.Args[2:])
+			return
+		}
+	}
+
 	// Parse command line flags
 	importRepos := flag.String("import", "", "Platform and repository to import (e.g., 'github username/repo')")
 	flag.Parse()
 
-	config := mirror.Config{
-		Type:        os.Getenv("DESTINATION_TYPE"),
-		URL:         os.Getenv("DESTINATION_URL"),
-		Token:       os.Getenv("DESTINATION_TOKEN"),
-		OrgID:       os.Getenv("DESTINATION_ORG"),
-		SourceToken: os.Getenv("SOURCE_TOKEN"),
-	}
+	config := mirrorConfigFromEnv()
 
 	// Handle one-time imports if specified
 	if *importRepos != "" {
-		if err := mirror.HandleImport(config, *importRepos); err != nil {
+		jobs, err := importer.HandleImport(config, *importRepos)
+		if err != nil {
 			log.Fatalf("Failed to import repository: %v", err)
 		}
+		runImportJobs(context.Background(), importer.Runner{Workers: envInt("IMPORT_WORKERS", 4)}, jobs, nil)
 		return
 	}
 
@@ -42,8 +72,27 @@ func main() {
 	log.Printf("url: %s", config.URL)
 	log.Printf("orgID: %s", config.OrgID)
 
-	handler := webhook.NewHandler(config)
+	ctx := context.Background()
+	checkCredentialsOnStart(config)
+	startSnapshots(ctx, config)
+	startReconciler(ctx, config)
+	startVerifier(ctx, config)
+	startHealthMonitor(ctx, config)
+
+	var opts []webhook.Option
+	if preserver := newPreserver(); preserver != nil {
+		opts = append(opts, webhook.WithPreserver(preserver))
+	}
+	if syncer := newSyncer(ctx, config); syncer != nil {
+		opts = append(opts, webhook.WithSyncer(syncer))
+	}
+	if envBool("CONFIRM_SOURCE") {
+		opts = append(opts, webhook.WithSourceConfirmation())
+	}
+
+	handler := webhook.NewHandler(config, opts...)
 	http.HandleFunc("/webhook", handler.HandleWebhook)
+	startPoller(ctx, config, handler)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -55,3 +104,82 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// mirrorConfigFromEnv builds the destination configuration from the environment
+func mirrorConfigFromEnv() mirror.Config {
+	config := mirror.Config{
+		Type:             os.Getenv("DESTINATION_TYPE"),
+		URL:              os.Getenv("DESTINATION_URL"),
+		UploadURL:        os.Getenv("DESTINATION_UPLOAD_URL"),
+		OrgID:            os.Getenv("DESTINATION_ORG"),
+		SourceUsername:   os.Getenv("SOURCE_USERNAME"),
+		SourceSSHKeyFile: os.Getenv("SOURCE_SSH_KEY_FILE"),
+		SourceProviders:  envMap("SOURCE_PROVIDERS"),
+		SourceGitHubURL:  os.Getenv("SOURCE_GITHUB_URL"),
+		SourceGitLabURL:  os.Getenv("SOURCE_GITLAB_URL"),
+		SourceGiteaURL:   os.Getenv("SOURCE_GITEA_URL"),
+		RemovalPolicy:    os.Getenv("REMOVAL_POLICY"),
+	}
+
+	config.SourcePolicy = sourcePolicyFromEnv()
+
+	// Tokens are resolved through secret providers so they can be rotated while the server runs
+	ctx := context.Background()
+	config.TokenSecret = secretFromEnv(ctx, "DESTINATION_TOKEN")
+	config.SourceTokenSecret = secretFromEnv(ctx, "SOURCE_TOKEN")
+
+	// Credential rules are reloaded like the secrets, so tokens can be rotated in the file
+	if path := os.Getenv("SOURCE_CREDENTIALS_FILE"); path != "" {
+		rules, err := mirror.WatchCredentials(ctx, path, envDuration("SECRET_REFRESH_INTERVAL", time.Minute))
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.SourceCredentials = rules
+	}
+
+	if os.Getenv("GITHUB_APP_ID") != "" {
+		config.GitHubApp = githubAppFromEnv()
+	}
+
+	return config
+}
+
+// sourcePolicyFromEnv reads the hosts, schemes and internal networks sources may use
+func sourcePolicyFromEnv() mirror.SourcePolicy {
+	policy := mirror.SourcePolicy{
+		AllowedHosts:   envList("SOURCE_ALLOWED_HOSTS"),
+		AllowedSchemes: envList("SOURCE_ALLOWED_SCHEMES"),
+	}
+	for _, item := range envList("SOURCE_ALLOWED_NETWORKS") {
+		network, err := netip.ParsePrefix(item)
+		if err != nil {
+			log.Fatalf("Invalid network in SOURCE_ALLOWED_NETWORKS: %v", err)
+		}
+		policy.AllowedNetworks = append(policy.AllowedNetworks, network)
+	}
+	return policy
+}
+
+// githubAppFromEnv sets up GitHub App authentication from the GITHUB_APP_* variables
+func githubAppFromEnv() *githubapp.App {
+	appID, err := strconv.ParseInt(os.Getenv("GITHUB_APP_ID"), 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid GITHUB_APP_ID: %v", err)
+	}
+
+	key, err := githubapp.ReadPrivateKey(os.Getenv("GITHUB_APP_PRIVATE_KEY_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	app, err := githubapp.New(githubapp.Config{
+		AppID:          appID,
+		PrivateKey:     key,
+		InstallationID: int64(envInt("GITHUB_APP_INSTALLATION_ID", 0)),
+		URL:            os.Getenv("GITHUB_APP_URL"),
+	})
+	if err != nil {
+		log.Fatalf("Failed to set up GitHub App: %v", err)
+	}
+	return app
+}
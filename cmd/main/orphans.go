@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/orphan"
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
+)
+
+// runOrphans implements `gitcloner orphans`, which reports mirrors whose source
+// was deleted, renamed or transferred
+func runOrphans(args []string) {
+	fs := flag.NewFlagSet("orphans", flag.ExitOnError)
+	archive := fs.Bool("archive", false, "Archive mirrors whose source no longer exists")
+	dryRun := fs.Bool("dry-run", false, "Report what would be archived without changing anything")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	all := fs.Bool("all", false, "Also list mirrors whose source is fine")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gitcloner orphans [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	config := mirrorConfigFromEnv()
+	service, err := mirror.NewMirrorService(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	results, err := orphan.Check(ctx, config, service, orphan.Options{Archive: *archive, DryRun: *dryRun})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var report []orphan.Result
+	failed := 0
+	for _, r := range results {
+		r.Reason = secret.Redact(r.Reason)
+		if r.Status == orphan.StatusFailed {
+			failed++
+		}
+		if *all || r.Status != orphan.StatusOK {
+			report = append(report, r)
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if report == nil {
+			report = []orphan.Result{}
+		}
+		if err := enc.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "REPOSITORY\tSTATUS\tSOURCE\tDETAILS")
+		for _, r := range report {
+			details := r.Reason
+			if r.Location != "" {
+				details = "now " + r.Location
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Name, r.Status, r.Source, details)
+		}
+		w.Flush()
+	}
+
+	if failed > 0 {
+		log.Fatalf("Failed to check %d of %d mirror(s)", failed, len(results))
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/poll"
+	"github.com/janyksteenbeek/gitcloner/pkg/webhook"
+)
+
+// startPoller polls the repositories in POLL_REPOSITORIES in the background,
+// handing their ref updates to handler as if a webhook had been received
+func startPoller(ctx context.Context, config mirror.Config, handler *webhook.Handler) {
+	repositories := envList("POLL_REPOSITORIES")
+	if len(repositories) == 0 {
+		return
+	}
+
+	pollConfig := poll.Config{
+		Repositories:   repositories,
+		Interval:       envDuration("POLL_INTERVAL", 5*time.Minute),
+		FullCheckEvery: envInt("POLL_FULL_CHECK_EVERY", 12),
+		StateFile:      os.Getenv("POLL_STATE_FILE"),
+	}
+	log.Printf("Polling %d repositories every %s", len(repositories), pollConfig.Interval)
+	go poll.New(config, pollConfig, handler.HandleRefUpdate).Run(ctx)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/preserve"
+	"github.com/janyksteenbeek/gitcloner/pkg/snapshot"
+)
+
+// newPreserver returns a preserver when PRESERVE_DIR is set, or nil when refs are not preserved
+func newPreserver() *preserve.Preserver {
+	config := preserve.Config{
+		Dir:      os.Getenv("PRESERVE_DIR"),
+		Timeout:  envDuration("PRESERVE_TIMEOUT", time.Minute),
+		MaxAge:   envDuration("PRESERVE_MAX_AGE", 0),
+		MaxCount: envInt("PRESERVE_MAX_COUNT", 0),
+	}
+	if config.Dir == "" {
+		return nil
+	}
+
+	// Preserved refs are kept as side bundles, by default next to the snapshots and with the same encryption
+	snapshotConfig := snapshotConfigFromEnv()
+	destination := os.Getenv("PRESERVE_DESTINATION")
+	if destination == "" {
+		destination = snapshotConfig.Destination
+	}
+	if destination == "" {
+		log.Fatal("PRESERVE_DESTINATION or SNAPSHOT_DESTINATION is required to preserve refs")
+	}
+	storage, err := snapshot.NewStorage(destination, snapshotConfig.S3)
+	if err != nil {
+		log.Fatalf("Failed to set up ref preservation: %v", err)
+	}
+	if err := snapshotConfig.Encryption.Validate(); err != nil {
+		log.Fatalf("Failed to set up ref preservation: %v", err)
+	}
+	config.Storage = storage
+	config.Encryption = snapshotConfig.Encryption
+
+	preserver, err := preserve.New(config)
+	if err != nil {
+		log.Fatalf("Failed to set up ref preservation: %v", err)
+	}
+
+	log.Printf("Preserving force-pushed and deleted refs in %s", destination)
+	return preserver
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/reconcile"
+	"github.com/janyksteenbeek/gitcloner/pkg/source"
+)
+
+// startReconciler runs the reconciliation loop in the background when RECONCILE_SOURCES is set
+func startReconciler(ctx context.Context, config mirror.Config) {
+	specs := envList("RECONCILE_SOURCES")
+	if len(specs) == 0 {
+		return
+	}
+
+	reconcileConfig := reconcile.Config{
+		Interval: envDuration("RECONCILE_INTERVAL", 6*time.Hour),
+		Rate:     envFloat("RECONCILE_RATE", 1),
+		Options: source.ListOptions{
+			IncludeSubgroups: envBool("RECONCILE_INCLUDE_SUBGROUPS"),
+			SkipForks:        envBool("RECONCILE_SKIP_FORKS"),
+			SkipArchived:     envBool("RECONCILE_SKIP_ARCHIVED"),
+		},
+	}
+	for _, spec := range specs {
+		s, err := reconcile.ParseSource(spec)
+		if err != nil {
+			log.Fatalf("Invalid RECONCILE_SOURCES: %v", err)
+		}
+		reconcileConfig.Sources = append(reconcileConfig.Sources, s)
+	}
+
+	log.Printf("Reconciling %d sources every %s", len(reconcileConfig.Sources), reconcileConfig.Interval)
+	go reconcile.New(config, reconcileConfig).Run(ctx)
+}
+
+// envFloat reads a floating point environment variable, returning def when it is unset or invalid
+func envFloat(key string, def float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Warning: invalid value for %s: %v", key, err)
+		return def
+	}
+	return f
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/git"
+	"github.com/janyksteenbeek/gitcloner/pkg/snapshot"
+)
+
+// runRestore implements `gitcloner restore`, which rebuilds a repository from its snapshots
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	snapshotID := fs.String("snapshot", "", "ID of the snapshot to restore (default: latest)")
+	identity := fs.String("identity", "", "age identity file or armored OpenPGP private key (default: SNAPSHOT_IDENTITY_FILE)")
+	pushURL := fs.String("push", "", "Push the restored repository to this remote")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gitcloner restore [flags] <repository> <directory>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		log.Fatal("restore needs a repository name and a target directory")
+	}
+	name, dir := fs.Arg(0), fs.Arg(1)
+
+	config := snapshotConfigFromEnv()
+	if *identity != "" {
+		config.Encryption.IdentityFile = *identity
+	}
+
+	storage, err := snapshot.NewStorage(config.Destination, config.S3)
+	if err != nil {
+		log.Fatalf("Failed to open snapshot storage: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := snapshot.Restore(ctx, storage, config.Encryption, name, *snapshotID, dir); err != nil {
+		log.Fatalf("Failed to restore %s: %v", name, err)
+	}
+	log.Printf("Restored %s into %s", name, dir)
+
+	if *pushURL != "" {
+		if _, err := git.Run(ctx, dir, "push", "--mirror", "--quiet", *pushURL); err != nil {
+			log.Fatalf("Failed to push restored repository: %v", err)
+		}
+		log.Printf("Pushed restored repository %s", name)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
+)
+
+// runRotateCredentials implements `gitcloner rotate-credentials`, which updates
+// the source credentials stored in existing pull mirrors
+func runRotateCredentials(args []string) {
+	fs := flag.NewFlagSet("rotate-credentials", flag.ExitOnError)
+	all := fs.Bool("all", false, "Update every private mirror, not only the ones that look stale")
+	dryRun := fs.Bool("dry-run", false, "Report what would be updated without changing anything")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gitcloner rotate-credentials [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	results, err := rotateCredentials(mirrorConfigFromEnv(), *all, *dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REPOSITORY\tSTATUS\tREASON")
+	failed := 0
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Name, r.Status, secret.Redact(r.Reason))
+		if r.Status == mirror.RotationFailed {
+			failed++
+		}
+	}
+	w.Flush()
+
+	if failed > 0 {
+		log.Fatalf("Failed to rotate credentials of %d mirror(s)", failed)
+	}
+}
+
+// checkCredentialsOnStart checks mirror credentials in the background when the
+// server starts. By default stale mirrors are only reported, they are updated
+// when ROTATE_CREDENTIALS_ON_START is true and left alone when it is false.
+func checkCredentialsOnStart(config mirror.Config) {
+	mode := os.Getenv("ROTATE_CREDENTIALS_ON_START")
+	if mode == "false" {
+		return
+	}
+	rotate := mode == "true"
+
+	go func() {
+		results, err := rotateCredentials(config, false, !rotate)
+		if errors.Is(err, mirror.ErrRotationUnsupported) {
+			return
+		}
+		if err != nil {
+			log.Printf("Warning: Failed to check mirror credentials: %v", err)
+			return
+		}
+		for _, r := range results {
+			switch r.Status {
+			case mirror.RotationUpdated:
+				log.Printf("Rotated source credentials of %s", r.Name)
+			case mirror.RotationWouldUpdate:
+				log.Printf("Warning: Source credentials of %s look stale, run rotate-credentials to update them", r.Name)
+			case mirror.RotationFailed:
+				log.Printf("Warning: Failed to check source credentials of %s: %s", r.Name, secret.Redact(r.Reason))
+			case mirror.RotationUnknown:
+				log.Printf("Warning: Mirror %s: %s", r.Name, r.Reason)
+			}
+		}
+	}()
+}
+
+func rotateCredentials(config mirror.Config, all, dryRun bool) ([]mirror.RotationResult, error) {
+	service, err := mirror.NewMirrorService(config)
+	if err != nil {
+		return nil, err
+	}
+	return mirror.RotateCredentials(config, service, all, dryRun)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
+)
+
+// vaultPrefix marks an environment value that names a secret in Vault, e.g. vault:gitcloner/gitea#token
+const vaultPrefix = "vault:"
+
+var (
+	vaultOnce     sync.Once
+	vaultProvider *secret.Vault
+)
+
+// vaultFromEnv returns the Vault provider configured with VAULT_ADDR, VAULT_TOKEN and VAULT_KV_MOUNT
+func vaultFromEnv() *secret.Vault {
+	vaultOnce.Do(func() {
+		vault, err := secret.NewVault(os.Getenv("VAULT_ADDR"), envSecret("VAULT_TOKEN"), os.Getenv("VAULT_KV_MOUNT"))
+		if err != nil {
+			log.Fatalf("Failed to set up Vault: %v", err)
+		}
+		vaultProvider = vault
+	})
+	return vaultProvider
+}
+
+// secretFromEnv resolves the secret key. With key_FILE set it is read from that
+// file, and a value starting with vault: is read from Vault. Secrets from files
+// and Vault are resolved again every SECRET_REFRESH_INTERVAL, so rotated
+// secrets are picked up without a restart. Returns nil when the secret is not set.
+func secretFromEnv(ctx context.Context, key string) *secret.Value {
+	var (
+		provider secret.Provider = secret.Env{}
+		ref                      = key
+		interval time.Duration
+	)
+
+	if path := os.Getenv(key + "_FILE"); path != "" {
+		provider, ref = secret.File{}, path
+		interval = envDuration("SECRET_REFRESH_INTERVAL", time.Minute)
+	} else if value := os.Getenv(key); strings.HasPrefix(value, vaultPrefix) {
+		provider, ref = vaultFromEnv(), strings.TrimPrefix(value, vaultPrefix)
+		interval = envDuration("SECRET_REFRESH_INTERVAL", time.Minute)
+	} else if value == "" {
+		return nil
+	}
+
+	value, err := secret.Watch(ctx, provider, ref, interval)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", key, err)
+	}
+	return value
+}
+
+// envSecret resolves a secret that is only read once, returning an empty string when it is not set
+func envSecret(key string) string {
+	if path := os.Getenv(key + "_FILE"); path != "" {
+		value, err := secret.File{}.Get(context.Background(), path)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", key, err)
+		}
+		secret.Register(value)
+		return value
+	}
+
+	value := os.Getenv(key)
+	secret.Register(value)
+	return value
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/snapshot"
+)
+
+func snapshotConfigFromEnv() snapshot.Config {
+	return snapshot.Config{
+		Destination: os.Getenv("SNAPSHOT_DESTINATION"),
+		Interval:    envDuration("SNAPSHOT_INTERVAL", 24*time.Hour),
+		Incremental: envBool("SNAPSHOT_INCREMENTAL"),
+		FullEvery:   envInt("SNAPSHOT_FULL_EVERY", 7),
+		Retention: snapshot.Retention{
+			Daily:   envInt("SNAPSHOT_KEEP_DAILY", 7),
+			Weekly:  envInt("SNAPSHOT_KEEP_WEEKLY", 4),
+			Monthly: envInt("SNAPSHOT_KEEP_MONTHLY", 12),
+		},
+		S3: snapshot.S3Config{
+			Endpoint:  os.Getenv("SNAPSHOT_S3_ENDPOINT"),
+			Region:    os.Getenv("SNAPSHOT_S3_REGION"),
+			AccessKey: envSecret("SNAPSHOT_S3_ACCESS_KEY"),
+			SecretKey: envSecret("SNAPSHOT_S3_SECRET_KEY"),
+			Insecure:  envBool("SNAPSHOT_S3_INSECURE"),
+		},
+		Encryption: snapshot.Encryption{
+			AgeRecipients: envList("SNAPSHOT_AGE_RECIPIENTS"),
+			PGPKeyring:    os.Getenv("SNAPSHOT_PGP_KEYRING"),
+			IdentityFile:  os.Getenv("SNAPSHOT_IDENTITY_FILE"),
+			Passphrase:    envSecret("SNAPSHOT_IDENTITY_PASSPHRASE"),
+		},
+	}
+}
+
+// envList reads a comma-separated environment variable, skipping empty items
+func envList(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// startSnapshots runs the snapshot loop in the background when a snapshot destination is configured
+func startSnapshots(ctx context.Context, config mirror.Config) {
+	snapshotConfig := snapshotConfigFromEnv()
+	if snapshotConfig.Destination == "" {
+		return
+	}
+
+	snapshotter, err := snapshot.New(config, snapshotConfig)
+	if err != nil {
+		log.Fatalf("Failed to set up snapshots: %v", err)
+	}
+
+	log.Printf("Writing snapshots to %s every %s", snapshotConfig.Destination, snapshotConfig.Interval)
+	go snapshotter.Run(ctx)
+}
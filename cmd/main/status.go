@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/health"
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+)
+
+// runStatus implements `gitcloner status`, which shows what the destination
+// reports about the updates of every pull mirror
+func runStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gitcloner status [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	service, err := mirror.NewMirrorService(mirrorConfigFromEnv())
+	if err != nil {
+		log.Fatal(err)
+	}
+	results, err := health.Check(service)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	unhealthy := 0
+	for _, r := range results {
+		if !r.Healthy() {
+			unhealthy++
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "REPOSITORY\tSTATUS\tLAST UPDATE\tLAST ATTEMPT\tLAST ERROR")
+		for _, r := range results {
+			lastUpdate, lastAttempt := "-", "-"
+			if r.LastUpdate != nil {
+				lastUpdate = r.LastUpdate.Format(time.RFC3339)
+			}
+			if r.LastAttempt != nil {
+				lastAttempt = r.LastAttempt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Name, r.Status, lastUpdate, lastAttempt, r.LastError)
+		}
+		w.Flush()
+	}
+
+	if unhealthy > 0 {
+		log.Fatalf("%d of %d mirror(s) are failing, stale or could not be checked", unhealthy, len(results))
+	}
+}
+
+// startHealthMonitor checks the health of every mirror in the background when
+// HEALTH_INTERVAL is set, and serves the results on /metrics
+func startHealthMonitor(ctx context.Context, config mirror.Config) {
+	interval := envDuration("HEALTH_INTERVAL", 0)
+	if interval <= 0 {
+		return
+	}
+
+	service, err := mirror.NewMirrorService(config)
+	if err != nil {
+		log.Printf("Warning: Failed to start health monitor: %v", err)
+		return
+	}
+	if _, ok := service.(mirror.HealthReporter); !ok {
+		log.Printf("Warning: %v, HEALTH_INTERVAL is ignored", mirror.ErrHealthUnsupported)
+		return
+	}
+
+	monitor := health.New(config, health.Config{
+		Interval: interval,
+		AlertURL: os.Getenv("HEALTH_ALERT_URL"),
+	})
+	http.Handle("/metrics", monitor)
+	log.Printf("Checking mirror health every %s", interval)
+	go monitor.Run(ctx)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/gitsync"
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+)
+
+var (
+	cacheOnce sync.Once
+	cache     *gitsync.Cache
+)
+
+// gitCache returns the cache of source repositories in GIT_CACHE_DIR, shared by
+// git destinations and verification. Its garbage collection runs in the
+// background until ctx is cancelled.
+func gitCache(ctx context.Context) *gitsync.Cache {
+	cacheOnce.Do(func() {
+		cacheDir := os.Getenv("GIT_CACHE_DIR")
+		if cacheDir == "" {
+			cacheDir = filepath.Join(os.TempDir(), "gitcloner-cache")
+		}
+		budget := int64(envInt("GIT_CACHE_MAX_MB", 10240)) << 20
+
+		var err error
+		cache, err = gitsync.NewCache(cacheDir, budget, envDuration("GIT_CACHE_GC_INTERVAL", 24*time.Hour))
+		if err != nil {
+			log.Fatalf("Failed to set up git cache: %v", err)
+		}
+		go cache.Run(ctx)
+	})
+	return cache
+}
+
+// newSyncer returns a syncer when GIT_DESTINATIONS is set, or nil when no git destinations are configured
+func newSyncer(ctx context.Context, config mirror.Config) *gitsync.Syncer {
+	destinations, err := gitsync.ParseDestinations(os.Getenv("GIT_DESTINATIONS"))
+	if err != nil {
+		log.Fatalf("Failed to parse GIT_DESTINATIONS: %v", err)
+	}
+	if len(destinations) == 0 {
+		return nil
+	}
+
+	syncer, err := gitsync.New(gitsync.Config{
+		Destinations: destinations,
+		Cache:        gitCache(ctx),
+		SourceURL:    config.SourceCloneURL,
+		SourceEnv:    config.SourceGitEnv,
+	})
+	if err != nil {
+		log.Fatalf("Failed to set up git destinations: %v", err)
+	}
+
+	for _, dest := range destinations {
+		log.Printf("Pushing mirrors to git destination %s", dest.Name)
+	}
+	return syncer
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
+	"github.com/janyksteenbeek/gitcloner/pkg/verify"
+)
+
+// runVerify implements `gitcloner verify`, which compares the refs of every
+// mirror with its source and reports how far behind it is
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	maxLag := fs.Duration("max-lag", envDuration("VERIFY_MAX_LAG", 24*time.Hour), "How long a mirror may miss source commits before it counts as stale")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gitcloner verify [flags] [mirror...]")
+		fs.PrintDefaults()
+	}
+	names := parseInterspersed(fs, args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	config := mirrorConfigFromEnv()
+	verifier := verify.New(config, verify.Config{MaxLag: *maxLag, Cache: gitCache(ctx)})
+	results, err := verifyMirrors(ctx, config, verifier, names)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	unhealthy := 0
+	for i := range results {
+		results[i].Reason = secret.Redact(results[i].Reason)
+		if results[i].Unhealthy() {
+			unhealthy++
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "REPOSITORY\tSTATUS\tBEHIND\tLAG\tDETAILS")
+		for _, r := range results {
+			lag := "-"
+			if r.Lag > 0 {
+				lag = r.Lag.String()
+			}
+			details := r.Reason
+			if len(r.Refs) > 0 {
+				details = strings.TrimSuffix(fmt.Sprintf("%d refs differ, %s", len(r.Refs), r.Reason), ", ")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", r.Name, r.Status, r.Behind, lag, details)
+		}
+		w.Flush()
+	}
+
+	if unhealthy > 0 {
+		log.Fatalf("%d of %d mirror(s) are stale, diverged or could not be verified", unhealthy, len(results))
+	}
+}
+
+// verifyMirrors verifies the mirrors with the given names, or every mirror when no names are given
+func verifyMirrors(ctx context.Context, config mirror.Config, verifier *verify.Verifier, names []string) ([]verify.Result, error) {
+	if len(names) == 0 {
+		return verifier.VerifyAll(ctx)
+	}
+
+	service, err := mirror.NewMirrorService(config)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]verify.Result, 0, len(names))
+	for _, name := range names {
+		m, err := service.GetMirror(mirror.Repository{Name: name})
+		if err != nil {
+			return nil, err
+		}
+		if m == nil {
+			return nil, fmt.Errorf("mirror %s not found", name)
+		}
+		results = append(results, verifier.Verify(ctx, *m))
+	}
+	return results, nil
+}
+
+// startVerifier verifies every mirror in the background when VERIFY_INTERVAL is set
+func startVerifier(ctx context.Context, config mirror.Config) {
+	interval := envDuration("VERIFY_INTERVAL", 0)
+	if interval <= 0 {
+		return
+	}
+
+	verifyConfig := verify.Config{
+		Interval: interval,
+		MaxLag:   envDuration("VERIFY_MAX_LAG", 24*time.Hour),
+		Cache:    gitCache(ctx),
+	}
+	log.Printf("Verifying mirrors every %s", interval)
+	go verify.New(config, verifyConfig).Run(ctx)
+}
@@ -0,0 +1,214 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ZeroSHA is the object ID forges send as the old or new value of a ref that was created or deleted
+const ZeroSHA = "0000000000000000000000000000000000000000"
+
+// ErrEmptyRepository is returned when an operation needs at least one ref but the repository has none
+var ErrEmptyRepository = errors.New("repository has no refs")
+
+type envKey struct{}
+
+// WithEnv returns a context whose git commands run with env added to their environment
+func WithEnv(ctx context.Context, env ...string) context.Context {
+	if len(env) == 0 {
+		return ctx
+	}
+	prev, _ := ctx.Value(envKey{}).([]string)
+	return context.WithValue(ctx, envKey{}, append(append([]string(nil), prev...), env...))
+}
+
+// Run executes a git command in dir and returns its standard output
+func Run(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	return RunInput(ctx, dir, nil, args...)
+}
+
+// RunInput executes a git command in dir with stdin as its standard input
+func RunInput(ctx context.Context, dir string, stdin io.Reader, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if env, ok := ctx.Value(envKey{}).([]string); ok {
+		cmd.Env = append(cmd.Env, env...)
+	}
+	cmd.Stdin = stdin
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// CloneMirror creates a bare mirror clone of url in dir
+func CloneMirror(ctx context.Context, url, dir string) error {
+	_, err := Run(ctx, "", "clone", "--mirror", "--quiet", url, dir)
+	return err
+}
+
+// Refs returns every ref in the repository at dir mapped to the object it points to
+func Refs(ctx context.Context, dir string) (map[string]string, error) {
+	out, err := Run(ctx, dir, "for-each-ref", "--format=%(objectname) %(refname)")
+	if err != nil {
+		return nil, err
+	}
+	return parseRefLines(out), nil
+}
+
+// HasObject reports whether the object exists in the repository at dir
+func HasObject(ctx context.Context, dir, sha string) bool {
+	_, err := Run(ctx, dir, "cat-file", "-e", sha+"^{object}")
+	return err == nil
+}
+
+// UpdateRef points ref at sha in the repository at dir
+func UpdateRef(ctx context.Context, dir, ref, sha string) error {
+	_, err := Run(ctx, dir, "update-ref", ref, sha)
+	return err
+}
+
+// DeleteRef removes ref from the repository at dir
+func DeleteRef(ctx context.Context, dir, ref string) error {
+	_, err := Run(ctx, dir, "update-ref", "-d", ref)
+	return err
+}
+
+// DeleteRefs removes every ref under prefix from the repository at dir
+func DeleteRefs(ctx context.Context, dir, prefix string) error {
+	out, err := Run(ctx, dir, "for-each-ref", "--format=delete %(refname)", prefix)
+	if err != nil || len(out) == 0 {
+		return err
+	}
+	_, err = RunInput(ctx, dir, bytes.NewReader(out), "update-ref", "--stdin")
+	return err
+}
+
+// IsAncestor reports whether commit ancestor is reachable from commit descendant in the repository at dir
+func IsAncestor(ctx context.Context, dir, ancestor, descendant string) (bool, error) {
+	_, err := Run(ctx, dir, "merge-base", "--is-ancestor", ancestor, descendant)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// FetchObject fetches sha and its history from remote without updating any ref.
+// Not every server allows fetching an object by ID, so callers should check
+// HasObject afterwards.
+func FetchObject(ctx context.Context, dir, remote, sha string) error {
+	_, err := Run(ctx, dir, "fetch", "--quiet", "--no-tags", "--no-write-fetch-head", remote, sha)
+	return err
+}
+
+// Fetch fetches refspecs from remote into the repository at dir
+func Fetch(ctx context.Context, dir, remote string, refspecs ...string) error {
+	args := append([]string{"fetch", "--quiet", "--no-tags", "--prune", remote}, refspecs...)
+	_, err := Run(ctx, dir, args...)
+	return err
+}
+
+// LsRemote returns the refs advertised by remote, limited to patterns when any are given
+func LsRemote(ctx context.Context, remote string, patterns ...string) (map[string]string, error) {
+	args := append([]string{"ls-remote", "--quiet", remote}, patterns...)
+	out, err := Run(ctx, "", args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseRefLines(out), nil
+}
+
+// LsRemoteHead returns the branches and tags advertised by remote and the branch its HEAD points at
+func LsRemoteHead(ctx context.Context, remote string) (map[string]string, string, error) {
+	out, err := Run(ctx, "", "ls-remote", "--quiet", "--symref", remote, "HEAD", "refs/heads/*", "refs/tags/*")
+	if err != nil {
+		return nil, "", err
+	}
+
+	var head string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		// The symbolic ref is advertised as "ref: refs/heads/main<TAB>HEAD"
+		if target, ok := strings.CutPrefix(scanner.Text(), "ref: "); ok {
+			head, _, _ = strings.Cut(target, "\t")
+		}
+	}
+
+	refs := parseRefLines(out)
+	delete(refs, "HEAD")
+	for ref := range refs {
+		if strings.HasSuffix(ref, "^{}") {
+			delete(refs, ref)
+		}
+	}
+	return refs, head, nil
+}
+
+// Push pushes refspecs from the repository at dir to remote
+func Push(ctx context.Context, dir, remote string, args ...string) error {
+	_, err := Run(ctx, dir, append([]string{"push", "--quiet", remote}, args...)...)
+	return err
+}
+
+// CreateBundle writes a bundle of all refs to file. Objects reachable from
+// any of the exclude SHAs are left out, which makes the bundle incremental.
+func CreateBundle(ctx context.Context, dir, file string, exclude []string) error {
+	args := []string{"bundle", "create", "--quiet", file, "--all"}
+	for _, sha := range exclude {
+		args = append(args, "^"+sha)
+	}
+	_, err := Run(ctx, dir, args...)
+	return err
+}
+
+// CreateRefBundle writes a bundle that contains only the given refs to file
+func CreateRefBundle(ctx context.Context, dir, file string, refs ...string) error {
+	args := append([]string{"bundle", "create", "--quiet", file}, refs...)
+	_, err := Run(ctx, dir, args...)
+	return err
+}
+
+// VerifyBundle checks that file is a valid bundle whose prerequisites exist in the repository at dir
+func VerifyBundle(ctx context.Context, dir, file string) error {
+	_, err := Run(ctx, dir, "bundle", "verify", "--quiet", file)
+	return err
+}
+
+// FetchBundle fetches every ref contained in the bundle file into the repository at dir
+func FetchBundle(ctx context.Context, dir, file string) error {
+	_, err := Run(ctx, dir, "fetch", "--quiet", "--update-head-ok", file, "+refs/*:refs/*")
+	return err
+}
+
+// InitBare creates an empty bare repository in dir
+func InitBare(ctx context.Context, dir string) error {
+	_, err := Run(ctx, "", "init", "--bare", "--quiet", dir)
+	return err
+}
+
+func parseRefLines(out []byte) map[string]string {
+	refs := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		refs[fields[1]] = fields[0]
+	}
+	return refs
+}
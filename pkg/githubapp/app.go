@@ -0,0 +1,213 @@
+package githubapp
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/go-github/v60/github"
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
+	"golang.org/x/oauth2"
+)
+
+// CloneUsername is the username GitHub expects next to an installation token in clone URLs
+const CloneUsername = "x-access-token"
+
+// ErrNoInstallation is returned when the app is not installed on an owner
+var ErrNoInstallation = errors.New("GitHub App is not installed for owner")
+
+// Config holds the settings of a GitHub App
+type Config struct {
+	AppID          int64
+	PrivateKey     []byte // PEM encoded RSA private key of the app
+	InstallationID int64  // Optional, skips the installation lookup per owner
+	URL            string // API base URL, empty for github.com
+}
+
+// App authenticates as a GitHub App and hands out installation tokens that are refreshed automatically
+type App struct {
+	config Config
+	key    *rsa.PrivateKey
+	client *github.Client
+	host   string
+
+	mu            sync.Mutex
+	installations map[string]int64
+	sources       map[int64]oauth2.TokenSource
+}
+
+// New creates a new GitHub App from its config
+func New(config Config) (*App, error) {
+	if config.AppID == 0 || len(config.PrivateKey) == 0 {
+		return nil, fmt.Errorf("GitHub App ID and private key are required")
+	}
+
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(config.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse GitHub App private key: %v", err)
+	}
+
+	app := &App{
+		config:        config,
+		key:           key,
+		host:          "github.com",
+		installations: make(map[string]int64),
+		sources:       make(map[int64]oauth2.TokenSource),
+	}
+
+	// App endpoints are authenticated with a short lived JWT instead of a token
+	client := github.NewClient(&http.Client{Transport: &jwtTransport{app: app}})
+	if config.URL != "" {
+		client, err = client.WithEnterpriseURLs(config.URL, config.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GitHub App URL: %v", err)
+		}
+		parsedURL, _ := url.Parse(config.URL)
+		app.host = strings.TrimPrefix(parsedURL.Hostname(), "api.")
+	}
+	app.client = client
+
+	return app, nil
+}
+
+// ReadPrivateKey reads a PEM encoded private key from path
+func ReadPrivateKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GitHub App private key: %v", err)
+	}
+	return key, nil
+}
+
+// Host returns the host name repositories of the app's GitHub instance are cloned from
+func (a *App) Host() string {
+	return a.host
+}
+
+// signJWT creates the JWT used to call the app endpoints. It is backdated a
+// minute to allow for clock drift and expires well within GitHub's ten minute limit.
+func (a *App) signJWT() (string, error) {
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		Issuer:    strconv.FormatInt(a.config.AppID, 10),
+		IssuedAt:  jwt.NewNumericDate(now.Add(-time.Minute)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(9 * time.Minute)),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(a.key)
+}
+
+// installationID returns the installation of the app on owner, which is either an organization or a user
+func (a *App) installationID(ctx context.Context, owner string) (int64, error) {
+	if a.config.InstallationID != 0 {
+		return a.config.InstallationID, nil
+	}
+
+	key := strings.ToLower(owner)
+	a.mu.Lock()
+	id, ok := a.installations[key]
+	a.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	installation, resp, err := a.client.Apps.FindOrganizationInstallation(ctx, owner)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		installation, resp, err = a.client.Apps.FindUserInstallation(ctx, owner)
+	}
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return 0, fmt.Errorf("%w %s", ErrNoInstallation, owner)
+		}
+		return 0, fmt.Errorf("failed to find GitHub App installation for %s: %v", owner, err)
+	}
+
+	a.mu.Lock()
+	a.installations[key] = installation.GetID()
+	a.mu.Unlock()
+	return installation.GetID(), nil
+}
+
+// TokenSource returns a token source for the installation of the app on owner.
+// Tokens are cached until shortly before they expire.
+func (a *App) TokenSource(owner string) oauth2.TokenSource {
+	return &ownerTokenSource{app: a, owner: owner}
+}
+
+// Token returns a valid installation token for owner
+func (a *App) Token(ctx context.Context, owner string) (string, error) {
+	id, err := a.installationID(ctx, owner)
+	if err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	source, ok := a.sources[id]
+	if !ok {
+		source = oauth2.ReuseTokenSourceWithExpiry(nil, &installationTokenSource{app: a, id: id}, time.Minute)
+		a.sources[id] = source
+	}
+	a.mu.Unlock()
+
+	token, err := source.Token()
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+type ownerTokenSource struct {
+	app   *App
+	owner string
+}
+
+func (s *ownerTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.app.Token(context.Background(), s.owner)
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{AccessToken: token, TokenType: "token"}, nil
+}
+
+// installationTokenSource exchanges the app JWT for a new installation token on every call
+type installationTokenSource struct {
+	app *App
+	id  int64
+}
+
+func (s *installationTokenSource) Token() (*oauth2.Token, error) {
+	token, _, err := s.app.client.Apps.CreateInstallationToken(context.Background(), s.id, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create installation token: %v", err)
+	}
+	secret.Register(token.GetToken())
+	return &oauth2.Token{
+		AccessToken: token.GetToken(),
+		TokenType:   "token",
+		Expiry:      token.GetExpiresAt().Time,
+	}, nil
+}
+
+// jwtTransport signs every request with a fresh app JWT
+type jwtTransport struct {
+	app *App
+}
+
+func (t *jwtTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.app.signJWT()
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign GitHub App JWT: %v", err)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return http.DefaultTransport.RoundTrip(req)
+}
@@ -0,0 +1,293 @@
+package gitsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/git"
+)
+
+const (
+	lastUsedFile = "gitcloner-last-used"
+	lastGCFile   = "gitcloner-last-gc"
+)
+
+// Cache keeps a bare repository per source URL on disk so an event is fetched
+// from the source once and then pushed to every destination. Repositories that
+// were used least recently are evicted when the cache grows beyond its budget.
+// The size of a repository is measured when it is released, so checking the
+// budget doesn't walk the whole cache.
+type Cache struct {
+	dir        string
+	budget     int64
+	gcInterval time.Duration
+
+	mu       sync.Mutex
+	locks    map[string]*sync.Mutex
+	busy     map[string]int
+	sizes    map[string]int64
+	lastUsed map[string]time.Time
+	total    int64
+}
+
+// NewCache creates a cache in dir. A budget of zero disables eviction and a gc
+// interval of zero disables periodic garbage collection.
+func NewCache(dir string, budget int64, gcInterval time.Duration) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		dir:        dir,
+		budget:     budget,
+		gcInterval: gcInterval,
+		locks:      make(map[string]*sync.Mutex),
+		busy:       make(map[string]int),
+		sizes:      make(map[string]int64),
+		lastUsed:   make(map[string]time.Time),
+	}
+
+	// Repositories left by a previous run are measured once
+	entries, err := c.entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		c.sizes[e.key], c.lastUsed[e.key] = e.size, e.lastUsed
+		c.total += e.size
+	}
+	return c, nil
+}
+
+// cacheKey returns the directory name for a source URL. Credentials are not part
+// of the key, so a rotated token keeps using the same repository.
+func cacheKey(sourceURL string) string {
+	sum := sha256.Sum256([]byte(stripUserinfo(sourceURL)))
+	return hex.EncodeToString(sum[:16]) + ".git"
+}
+
+func stripUserinfo(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.User == nil {
+		return rawURL
+	}
+	parsed.User = nil
+	return parsed.String()
+}
+
+// lock locks the repository for key and protects it from eviction until the returned function is called
+func (c *Cache) lock(key string) func() {
+	c.mu.Lock()
+	lock, ok := c.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.locks[key] = lock
+	}
+	c.busy[key]++
+	c.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.busy[key]--; c.busy[key] > 0 {
+			return
+		}
+		delete(c.busy, key)
+
+		// A repository that was evicted meanwhile doesn't keep its lock
+		if _, cached := c.sizes[key]; !cached {
+			delete(c.locks, key)
+		}
+	}
+}
+
+// Acquire locks the cached repository for sourceURL, creating it when needed,
+// and returns its path. The returned release function must be called when the
+// caller is done with the repository.
+func (c *Cache) Acquire(ctx context.Context, sourceURL string) (string, func(), error) {
+	key := cacheKey(sourceURL)
+	unlock := c.lock(key)
+	release := func() {
+		c.measure(key)
+		unlock()
+		c.evict()
+	}
+
+	dir := filepath.Join(c.dir, key)
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		if err := git.InitBare(ctx, dir); err != nil {
+			release()
+			return "", nil, err
+		}
+	}
+
+	touch(filepath.Join(dir, lastUsedFile))
+	c.mu.Lock()
+	c.lastUsed[key] = time.Now()
+	c.mu.Unlock()
+	return dir, release, nil
+}
+
+// measure records the size on disk of the repository for key
+func (c *Cache) measure(key string) {
+	size := dirSize(filepath.Join(c.dir, key))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total += size - c.sizes[key]
+	c.sizes[key] = size
+}
+
+// Run collects garbage in every cached repository once per gc interval until ctx is cancelled
+func (c *Cache) Run(ctx context.Context) {
+	if c.gcInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.gcInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.collectGarbage(ctx)
+			c.evict()
+		}
+	}
+}
+
+// collectGarbage runs git gc in every repository that was not collected within the gc interval
+func (c *Cache) collectGarbage(ctx context.Context) {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.sizes))
+	for key := range c.sizes {
+		keys = append(keys, key)
+	}
+	c.mu.Unlock()
+
+	for _, key := range keys {
+		stamp := filepath.Join(c.dir, key, lastGCFile)
+		if info, err := os.Stat(stamp); err == nil && time.Since(info.ModTime()) < c.gcInterval {
+			continue
+		}
+
+		unlock := c.lock(key)
+		if _, err := os.Stat(filepath.Join(c.dir, key)); err != nil {
+			// Evicted since the keys were listed
+			unlock()
+			continue
+		}
+		_, err := git.Run(ctx, filepath.Join(c.dir, key), "gc", "--quiet")
+		if err == nil {
+			c.measure(key)
+		}
+		unlock()
+		if err != nil {
+			log.Printf("Warning: git gc failed for cached repository %s: %v", key, err)
+			continue
+		}
+		touch(stamp)
+	}
+}
+
+type cacheEntry struct {
+	key      string
+	size     int64
+	lastUsed time.Time
+}
+
+// entries measures every repository on disk
+func (c *Cache) entries() ([]cacheEntry, error) {
+	dirs, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []cacheEntry
+	for _, d := range dirs {
+		if !d.IsDir() || !strings.HasSuffix(d.Name(), ".git") {
+			continue
+		}
+		e := cacheEntry{key: d.Name(), size: dirSize(filepath.Join(c.dir, d.Name()))}
+		if info, err := os.Stat(filepath.Join(c.dir, d.Name(), lastUsedFile)); err == nil {
+			e.lastUsed = info.ModTime()
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// evict removes the least recently used repositories until the cache fits its budget
+func (c *Cache) evict() {
+	if c.budget <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.total <= c.budget {
+		return
+	}
+
+	keys := make([]string, 0, len(c.sizes))
+	for key := range c.sizes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.lastUsed[keys[i]].Before(c.lastUsed[keys[j]])
+	})
+
+	for _, key := range keys {
+		if c.total <= c.budget {
+			return
+		}
+		if c.busy[key] > 0 {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(c.dir, key)); err != nil {
+			log.Printf("Warning: Failed to evict cached repository %s: %v", key, err)
+			continue
+		}
+		c.total -= c.sizes[key]
+		delete(c.sizes, key)
+		delete(c.lastUsed, key)
+		delete(c.locks, key)
+		delete(c.busy, key)
+	}
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+func touch(path string) {
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); errors.Is(err, os.ErrNotExist) {
+		os.WriteFile(path, nil, 0o600)
+	}
+}
@@ -0,0 +1,96 @@
+package gitsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fill acquires a cached repository for each source URL, oldest use first
+func fill(t *testing.T, c *Cache, sourceURLs ...string) {
+	t.Helper()
+	start := time.Now().Add(-time.Hour)
+	for i, sourceURL := range sourceURLs {
+		_, release, err := c.Acquire(context.Background(), sourceURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+
+		c.mu.Lock()
+		c.lastUsed[cacheKey(sourceURL)] = start.Add(time.Duration(i) * time.Minute)
+		c.mu.Unlock()
+	}
+}
+
+// cached reports whether the repository for sourceURL is still on disk
+func cached(c *Cache, sourceURL string) bool {
+	_, err := os.Stat(filepath.Join(c.dir, cacheKey(sourceURL)))
+	return err == nil
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c, err := NewCache(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldest, older, newest := "https://example.com/acme/a.git", "https://example.com/acme/b.git", "https://example.com/acme/c.git"
+	fill(t, c, oldest, older, newest)
+
+	// Leave room for two of the three repositories
+	c.budget = c.total - 1
+	c.evict()
+
+	if cached(c, oldest) {
+		t.Error("least recently used repository was kept")
+	}
+	if !cached(c, older) || !cached(c, newest) {
+		t.Error("evicted more than needed to fit the budget")
+	}
+	key := cacheKey(oldest)
+	if _, ok := c.locks[key]; ok {
+		t.Error("lock of the evicted repository was kept")
+	}
+	if _, ok := c.busy[key]; ok {
+		t.Error("use count of the evicted repository was kept")
+	}
+}
+
+func TestCacheKeepsAcquired(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewCache(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldest, older, newest := "https://example.com/acme/a.git", "https://example.com/acme/b.git", "https://example.com/acme/c.git"
+	fill(t, c, oldest, older, newest)
+
+	dir, release, err := c.Acquire(ctx, oldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	c.lastUsed[cacheKey(oldest)] = time.Now().Add(-24 * time.Hour)
+	c.mu.Unlock()
+
+	// Nothing fits, but the repository in use must stay
+	c.budget = 1
+	c.evict()
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatalf("acquired repository was evicted: %v", err)
+	}
+	if cached(c, older) || cached(c, newest) {
+		t.Error("repositories that are not in use were kept over the budget")
+	}
+
+	// Once released it is evicted like any other, and its lock goes with it
+	release()
+	if cached(c, oldest) {
+		t.Error("released repository was kept over the budget")
+	}
+	if len(c.locks) != 0 || len(c.busy) != 0 {
+		t.Errorf("locks = %v, busy = %v, want none left", c.locks, c.busy)
+	}
+}
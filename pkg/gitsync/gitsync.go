@@ -0,0 +1,224 @@
+package gitsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/git"
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+)
+
+// ErrInvalidDestination is returned when a git destination cannot be parsed
+var ErrInvalidDestination = errors.New("invalid git destination")
+
+// reconcileRefspecs are the refs kept in sync by a full reconciliation. Forge
+// specific refs such as refs/pull/* are left out because destinations reject them.
+var reconcileRefspecs = []string{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}
+
+// Destination is a git remote that mirrors are pushed to. The URL may contain
+// {name}, {owner} and {repo}, which are replaced with the mirror name, the
+// source owner and the source repository name.
+type Destination struct {
+	Name string
+	URL  string
+}
+
+// RemoteURL returns the URL of the destination for repo
+func (d Destination) RemoteURL(repo mirror.Repository) string {
+	return strings.NewReplacer(
+		"{name}", repo.Name,
+		"{owner}", repo.Owner,
+		"{repo}", strings.TrimPrefix(repo.Name, repo.Owner+"-"),
+	).Replace(d.URL)
+}
+
+// ParseDestinations parses a comma-separated list of name=url destinations
+func ParseDestinations(value string) ([]Destination, error) {
+	var destinations []Destination
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, url, ok := strings.Cut(item, "=")
+		if !ok || name == "" || url == "" {
+			return nil, fmt.Errorf("%w: %q, use name=url", ErrInvalidDestination, name)
+		}
+		destinations = append(destinations, Destination{Name: name, URL: url})
+	}
+	return destinations, nil
+}
+
+// Config holds the configuration for pushing mirrors with a local git engine
+type Config struct {
+	Destinations []Destination
+	Cache        *Cache
+
+	// SourceURL returns the clone URL of a source repository including any credentials
+	SourceURL func(repo mirror.Repository) (string, error)
+
+	// SourceEnv optionally returns the git environment for fetching from a source, such as its deploy key
+	SourceEnv func(repo mirror.Repository) []string
+}
+
+// Event describes a ref update on the source. Before and After are the old and
+// new values of Ref, ZeroSHA when the ref is created or deleted. An event
+// without Ref asks for a full reconciliation.
+type Event struct {
+	Repo   mirror.Repository
+	Ref    string
+	Before string
+	After  string
+}
+
+// Syncer pushes source refs to git destinations with a local git engine
+type Syncer struct {
+	config Config
+}
+
+// New creates a new Syncer
+func New(config Config) (*Syncer, error) {
+	if len(config.Destinations) == 0 {
+		return nil, fmt.Errorf("%w: no destinations configured", ErrInvalidDestination)
+	}
+	if config.Cache == nil || config.SourceURL == nil {
+		return nil, fmt.Errorf("%w: cache and source URL are required", ErrInvalidDestination)
+	}
+	return &Syncer{config: config}, nil
+}
+
+// Sync fetches ev from the source once and applies it to every destination,
+// continuing with the next destination when one fails
+func (s *Syncer) Sync(ctx context.Context, ev Event) error {
+	sourceURL, err := s.config.SourceURL(ev.Repo)
+	if err != nil {
+		return err
+	}
+
+	dir, release, err := s.config.Cache.Acquire(ctx, ev.Repo.CloneURL)
+	if err != nil {
+		return fmt.Errorf("failed to open cached repository: %v", err)
+	}
+	defer release()
+
+	// The source environment is only used for fetches, destinations keep their own SSH setup
+	fetchCtx := ctx
+	if s.config.SourceEnv != nil {
+		fetchCtx = git.WithEnv(ctx, s.config.SourceEnv(ev.Repo)...)
+	}
+
+	if ev.Ref != "" {
+		if err := FetchRef(fetchCtx, dir, sourceURL, ev.Ref, ev.After); err != nil {
+			return err
+		}
+	}
+
+	fetchedAll := false
+	var errs []error
+	for _, dest := range s.config.Destinations {
+		destURL := dest.RemoteURL(ev.Repo)
+
+		if ev.Ref != "" {
+			ok, err := PushRef(ctx, dir, destURL, ev.Ref, ev.Before, ev.After)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", dest.Name, err))
+				continue
+			}
+			if ok {
+				log.Printf("Synced %s of %s to %s", ev.Ref, ev.Repo.Name, dest.Name)
+				continue
+			}
+			log.Printf("%s of %s on %s does not match the push, reconciling all refs", ev.Ref, ev.Repo.Name, dest.Name)
+		}
+
+		// The full fetch is shared by every destination that needs reconciling
+		if !fetchedAll {
+			if err := FetchAll(fetchCtx, dir, sourceURL); err != nil {
+				return errors.Join(append(errs, err)...)
+			}
+			fetchedAll = true
+		}
+
+		if err := PushAll(ctx, dir, destURL); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", dest.Name, err))
+			continue
+		}
+		log.Printf("Reconciled %s to %s", ev.Repo.Name, dest.Name)
+	}
+
+	return errors.Join(errs...)
+}
+
+// FetchRef updates ref in the repository at dir from the source. A deleted ref is removed locally.
+func FetchRef(ctx context.Context, dir, sourceURL, ref, after string) error {
+	if after == git.ZeroSHA {
+		if err := git.DeleteRef(ctx, dir, ref); err != nil {
+			return fmt.Errorf("failed to delete %s: %v", ref, err)
+		}
+		return nil
+	}
+
+	if err := git.Fetch(ctx, dir, sourceURL, "+"+ref+":"+ref); err != nil {
+		return fmt.Errorf("failed to fetch %s: %v", ref, err)
+	}
+	return nil
+}
+
+// PushRef copies ref from the repository at dir to dest. The update only
+// happens when dest still has the value the source had before the push,
+// otherwise false is returned and the caller should reconcile.
+func PushRef(ctx context.Context, dir, destURL, ref, before, after string) (bool, error) {
+	if before == "" {
+		return false, nil
+	}
+
+	remote, err := git.LsRemote(ctx, destURL, ref)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s from destination: %v", ref, err)
+	}
+
+	current, exists := remote[ref]
+	if before == git.ZeroSHA {
+		if exists {
+			return false, nil
+		}
+	} else if current != before {
+		return false, nil
+	}
+
+	if after == git.ZeroSHA {
+		if !exists {
+			return true, nil
+		}
+		if err := git.Push(ctx, dir, destURL, "--force-with-lease="+ref+":"+current, ":"+ref); err != nil {
+			return false, fmt.Errorf("failed to delete %s: %v", ref, err)
+		}
+		return true, nil
+	}
+
+	// The lease makes the push fail instead of overwriting a concurrent update on the destination
+	if err := git.Push(ctx, dir, destURL, "--force-with-lease="+ref+":"+current, "+"+ref+":"+ref); err != nil {
+		return false, fmt.Errorf("failed to push %s: %v", ref, err)
+	}
+	return true, nil
+}
+
+// FetchAll updates every branch and tag in the repository at dir from the source
+func FetchAll(ctx context.Context, dir, sourceURL string) error {
+	if err := git.Fetch(ctx, dir, sourceURL, reconcileRefspecs...); err != nil {
+		return fmt.Errorf("failed to fetch source: %v", err)
+	}
+	return nil
+}
+
+// PushAll makes the branches and tags of dest match the repository at dir
+func PushAll(ctx context.Context, dir, destURL string) error {
+	args := append([]string{"--prune"}, reconcileRefspecs...)
+	if err := git.Push(ctx, dir, destURL, args...); err != nil {
+		return fmt.Errorf("failed to push to destination: %v", err)
+	}
+	return nil
+}
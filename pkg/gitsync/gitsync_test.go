@@ -0,0 +1,286 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/git"
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+)
+
+const (
+	benchCommits  = 2000
+	benchBranches = 500
+	benchTags     = 500
+)
+
+// run runs git in dir with a fixed identity and returns its trimmed output
+func run(tb testing.TB, dir string, args ...string) string {
+	tb.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Gitcloner", "-c", "user.email=gitcloner@example.com"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		tb.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newSource creates a repository with a long history and many branches and
+// tags, which is where pushing a single ref pays off
+func newSource(b *testing.B) string {
+	b.Helper()
+	dir := filepath.Join(b.TempDir(), "source")
+	run(b, "", "init", "--quiet", "--initial-branch=main", dir)
+
+	var stream strings.Builder
+	for i := 1; i <= benchCommits; i++ {
+		msg := fmt.Sprintf("commit %d", i)
+		content := fmt.Sprintf("line %d\n", i)
+		fmt.Fprintf(&stream, "commit refs/heads/main\nmark :%d\ncommitter Gitcloner <gitcloner@example.com> %d +0000\ndata %d\n%s\n", i, 1700000000+i, len(msg), msg)
+		if i > 1 {
+			fmt.Fprintf(&stream, "from :%d\n", i-1)
+		}
+		fmt.Fprintf(&stream, "M 644 inline file-%d.txt\ndata %d\n%s\n", i%100, len(content), content)
+	}
+	for i := 0; i < benchBranches; i++ {
+		fmt.Fprintf(&stream, "reset refs/heads/branch-%d\nfrom :%d\n\n", i, 1+i*benchCommits/benchBranches)
+	}
+	for i := 0; i < benchTags; i++ {
+		fmt.Fprintf(&stream, "reset refs/tags/v%d\nfrom :%d\n\n", i, 1+i*benchCommits/benchTags)
+	}
+
+	cmd := exec.Command("git", "fast-import", "--quiet")
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(stream.String())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		b.Fatalf("git fast-import: %v: %s", err, out)
+	}
+	run(b, dir, "checkout", "--quiet", "main")
+	return dir
+}
+
+// setup returns a source, a cache repository and a destination that are in sync
+func setup(b *testing.B) (source, cache, dest string) {
+	b.Helper()
+	ctx := context.Background()
+	source = newSource(b)
+	cache = filepath.Join(b.TempDir(), "cache.git")
+	dest = filepath.Join(b.TempDir(), "dest.git")
+	for _, dir := range []string{cache, dest} {
+		if err := git.InitBare(ctx, dir); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := FetchAll(ctx, cache, source); err != nil {
+		b.Fatal(err)
+	}
+	if err := PushAll(ctx, cache, dest); err != nil {
+		b.Fatal(err)
+	}
+	return source, cache, dest
+}
+
+// BenchmarkSync compares applying a push to a single branch with a ref-scoped
+// fetch and push against reconciling the whole repository
+func BenchmarkSync(b *testing.B) {
+	ctx := context.Background()
+	const ref = "refs/heads/main"
+
+	b.Run("ref", func(b *testing.B) {
+		source, cache, dest := setup(b)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			before := run(b, source, "rev-parse", "HEAD")
+			run(b, source, "commit", "--quiet", "--allow-empty", "-m", fmt.Sprintf("push %d", i))
+			after := run(b, source, "rev-parse", "HEAD")
+			b.StartTimer()
+
+			if err := FetchRef(ctx, cache, source, ref, after); err != nil {
+				b.Fatal(err)
+			}
+			ok, err := PushRef(ctx, cache, dest, ref, before, after)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if !ok {
+				b.Fatal("destination did not match the push")
+			}
+		}
+	})
+
+	b.Run("full", func(b *testing.B) {
+		source, cache, dest := setup(b)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			run(b, source, "commit", "--quiet", "--allow-empty", "-m", fmt.Sprintf("push %d", i))
+			b.StartTimer()
+
+			if err := FetchAll(ctx, cache, source); err != nil {
+				b.Fatal(err)
+			}
+			if err := PushAll(ctx, cache, dest); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// newSyncer returns a work tree whose main and topic branches are synced to
+// every destination, and a syncer pushing it to them. A destination named
+// broken can't be reached.
+func newSyncer(t *testing.T, names ...string) (string, map[string]string, *Syncer) {
+	t.Helper()
+	ctx := context.Background()
+	source := filepath.Join(t.TempDir(), "source")
+	run(t, "", "init", "--quiet", "--initial-branch=main", source)
+	run(t, source, "commit", "--quiet", "--allow-empty", "-m", "first")
+	run(t, source, "branch", "topic")
+
+	cache, err := NewCache(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dests := make(map[string]string)
+	var destinations []Destination
+	for _, name := range names {
+		dest := filepath.Join(t.TempDir(), name+".git")
+		if name != "broken" {
+			if err := git.InitBare(ctx, dest); err != nil {
+				t.Fatal(err)
+			}
+			dests[name] = dest
+		}
+		destinations = append(destinations, Destination{Name: name, URL: dest})
+	}
+
+	s, err := New(Config{
+		Destinations: destinations,
+		Cache:        cache,
+		SourceURL:    func(mirror.Repository) (string, error) { return source, nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, dest := range dests {
+		if err := PushAll(ctx, source, dest); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	return source, dests, s
+}
+
+// refsOf returns the branches and tags of the repository at dir
+func refsOf(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	refs, err := git.Refs(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return refs
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	const ref = "refs/heads/main"
+
+	// push commits to main and creates an unrelated branch the event does not tell about
+	push := func(t *testing.T, source string) Event {
+		before := run(t, source, "rev-parse", "HEAD")
+		run(t, source, "commit", "--quiet", "--allow-empty", "-m", "second")
+		run(t, source, "branch", "unannounced")
+		return Event{Repo: mirror.Repository{Name: "acme-app", CloneURL: source}, Ref: ref, Before: before, After: run(t, source, "rev-parse", "HEAD")}
+	}
+
+	t.Run("ref", func(t *testing.T) {
+		source, dests, s := newSyncer(t, "primary")
+		ev := push(t, source)
+		if err := s.Sync(ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+
+		refs := refsOf(t, dests["primary"])
+		if refs[ref] != ev.After {
+			t.Errorf("main = %s, want %s", refs[ref], ev.After)
+		}
+		if _, ok := refs["refs/heads/unannounced"]; ok {
+			t.Error("a push to main reconciled every ref")
+		}
+	})
+
+	t.Run("destination not at before", func(t *testing.T) {
+		source, dests, s := newSyncer(t, "primary", "moved")
+		ev := push(t, source)
+
+		// Someone pushed to one destination directly, so the lease can't hold there
+		other := filepath.Join(t.TempDir(), "other")
+		run(t, "", "clone", "--quiet", "--branch", "main", dests["moved"], other)
+		run(t, other, "commit", "--quiet", "--allow-empty", "-m", "concurrent")
+		run(t, other, "push", "--quiet", "origin", "main")
+
+		if err := s.Sync(ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+
+		moved := refsOf(t, dests["moved"])
+		if moved[ref] != ev.After {
+			t.Errorf("moved main = %s, want the source %s", moved[ref], ev.After)
+		}
+		if _, ok := moved["refs/heads/unannounced"]; !ok {
+			t.Error("moved destination was not reconciled")
+		}
+		if _, ok := refsOf(t, dests["primary"])["refs/heads/unannounced"]; ok {
+			t.Error("the destination at before was reconciled instead of updated")
+		}
+	})
+
+	t.Run("empty before", func(t *testing.T) {
+		source, dests, s := newSyncer(t, "primary")
+		ev := push(t, source)
+		ev.Before = ""
+		if err := s.Sync(ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+
+		refs := refsOf(t, dests["primary"])
+		if _, ok := refs["refs/heads/unannounced"]; !ok || refs[ref] != ev.After {
+			t.Errorf("refs = %v, want every ref reconciled", refs)
+		}
+	})
+
+	t.Run("deleted ref", func(t *testing.T) {
+		source, dests, s := newSyncer(t, "primary")
+		topic := run(t, source, "rev-parse", "topic")
+		run(t, source, "branch", "--quiet", "-D", "topic")
+
+		ev := Event{Repo: mirror.Repository{Name: "acme-app", CloneURL: source}, Ref: "refs/heads/topic", Before: topic, After: git.ZeroSHA}
+		if err := s.Sync(ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+		if refs := refsOf(t, dests["primary"]); refs["refs/heads/topic"] != "" || refs[ref] == "" {
+			t.Errorf("refs = %v, want topic deleted and main kept", refs)
+		}
+	})
+
+	t.Run("failing destination", func(t *testing.T) {
+		source, dests, s := newSyncer(t, "primary", "broken", "secondary")
+		ev := push(t, source)
+
+		err := s.Sync(ctx, ev)
+		if err == nil || !strings.HasPrefix(err.Error(), "broken: ") {
+			t.Fatalf("Sync() error = %v, want the broken destination to fail", err)
+		}
+		for name, dest := range dests {
+			if got := refsOf(t, dest)[ref]; got != ev.After {
+				t.Errorf("%s main = %s, want %s", name, got, ev.After)
+			}
+		}
+	})
+}
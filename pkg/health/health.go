@@ -0,0 +1,251 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
+)
+
+// Result is the health of one mirror as reported by the destination
+type Result struct {
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	LastUpdate  *time.Time `json:"last_update,omitempty"`
+	LastAttempt *time.Time `json:"last_attempt,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// Healthy reports whether the mirror is updating as expected. A mirror whose
+// health could not be checked is not, or a destination that rejects every
+// request would look fine.
+func (r Result) Healthy() bool {
+	return r.Status != mirror.HealthFailing && r.Status != mirror.HealthStale && r.Status != mirror.HealthError
+}
+
+// Check asks the destination for the health of every mirror. Errors are
+// redacted, as they may hold the clone URL of the source.
+func Check(service mirror.MirrorService) ([]Result, error) {
+	reporter, ok := service.(mirror.HealthReporter)
+	if !ok {
+		return nil, mirror.ErrHealthUnsupported
+	}
+
+	mirrors, err := service.ListMirrors()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, 0, len(mirrors))
+	for _, m := range mirrors {
+		result := Result{Name: m.Name}
+		health, err := reporter.MirrorHealth(m)
+		if err != nil {
+			result.Status, result.LastError = mirror.HealthError, secret.Redact(err.Error())
+		} else {
+			result.Status, result.LastError = health.Status, secret.Redact(health.LastError)
+			if !health.LastUpdate.IsZero() {
+				result.LastUpdate = &health.LastUpdate
+			}
+			if !health.LastAttempt.IsZero() {
+				result.LastAttempt = &health.LastAttempt
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Config holds the configuration of the monitor
+type Config struct {
+	Interval time.Duration // Defaults to 15 minutes
+	AlertURL string        // Receives a JSON POST when a mirror starts failing or recovers
+}
+
+// Alert is sent to the alert URL when the health of a mirror changes
+type Alert struct {
+	Mirror     string     `json:"mirror"`
+	Status     string     `json:"status"`
+	Previous   string     `json:"previous,omitempty"`
+	Resolved   bool       `json:"resolved"`
+	LastUpdate *time.Time `json:"last_update,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+}
+
+// Monitor periodically checks the health of every mirror, alerts when a mirror
+// starts failing and serves the latest results as Prometheus metrics
+type Monitor struct {
+	config       Config
+	mirrorConfig mirror.Config
+	client       *http.Client
+
+	mu      sync.Mutex
+	results []Result
+	checked time.Time
+}
+
+// New creates a monitor for the mirrors of the configured destination
+func New(mirrorConfig mirror.Config, config Config) *Monitor {
+	if config.Interval <= 0 {
+		config.Interval = 15 * time.Minute
+	}
+	return &Monitor{
+		config:       config,
+		mirrorConfig: mirrorConfig,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Run checks every mirror once per interval until ctx is cancelled
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.check(ctx); err != nil {
+			log.Printf("Warning: Failed to check mirror health: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Monitor) check(ctx context.Context) error {
+	service, err := mirror.NewMirrorService(m.mirrorConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create mirror service: %v", err)
+	}
+	results, err := Check(service)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	previous := make(map[string]string, len(m.results))
+	for _, r := range m.results {
+		previous[r.Name] = r.Status
+	}
+	m.results, m.checked = results, time.Now()
+	m.mu.Unlock()
+
+	for _, r := range results {
+		before, seen := previous[r.Name]
+		wasHealthy := !seen || (Result{Status: before}).Healthy()
+		switch {
+		case wasHealthy && !r.Healthy():
+			log.Printf("Warning: Mirror %s is %s: %s", r.Name, r.Status, r.LastError)
+		case !wasHealthy && r.Healthy():
+			log.Printf("Mirror %s recovered", r.Name)
+		default:
+			continue
+		}
+		m.alert(ctx, Alert{
+			Mirror:     r.Name,
+			Status:     r.Status,
+			Previous:   before,
+			Resolved:   r.Healthy(),
+			LastUpdate: r.LastUpdate,
+			LastError:  r.LastError,
+		})
+	}
+	return nil
+}
+
+// alert posts a health change to the alert URL
+func (m *Monitor) alert(ctx context.Context, alert Alert) {
+	if m.config.AlertURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.config.AlertURL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Warning: Failed to send alert for %s: %v", alert.Mirror, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		log.Printf("Warning: Failed to send alert for %s: %v", alert.Mirror, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Warning: Failed to send alert for %s: %s", alert.Mirror, resp.Status)
+	}
+}
+
+// ServeHTTP writes the results of the last check in the Prometheus text format
+func (m *Monitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	results, checked := m.results, m.checked
+	m.mu.Unlock()
+
+	counts := make(map[string]int)
+	var b strings.Builder
+	b.WriteString("# HELP gitcloner_mirror_healthy Whether the destination reports the mirror as updating\n")
+	b.WriteString("# TYPE gitcloner_mirror_healthy gauge\n")
+	for _, result := range results {
+		counts[result.Status]++
+		healthy := 0
+		if result.Healthy() {
+			healthy = 1
+		}
+		fmt.Fprintf(&b, "gitcloner_mirror_healthy{mirror=%s} %d\n", label(result.Name), healthy)
+	}
+	b.WriteString("# HELP gitcloner_mirror_last_update_timestamp_seconds Last successful update of the mirror\n")
+	b.WriteString("# TYPE gitcloner_mirror_last_update_timestamp_seconds gauge\n")
+	for _, result := range results {
+		if result.LastUpdate != nil {
+			fmt.Fprintf(&b, "gitcloner_mirror_last_update_timestamp_seconds{mirror=%s} %d\n", label(result.Name), result.LastUpdate.Unix())
+		}
+	}
+	b.WriteString("# HELP gitcloner_mirror_last_attempt_timestamp_seconds Last update attempt of the mirror, successful or not\n")
+	b.WriteString("# TYPE gitcloner_mirror_last_attempt_timestamp_seconds gauge\n")
+	for _, result := range results {
+		if result.LastAttempt != nil {
+			fmt.Fprintf(&b, "gitcloner_mirror_last_attempt_timestamp_seconds{mirror=%s} %d\n", label(result.Name), result.LastAttempt.Unix())
+		}
+	}
+	b.WriteString("# HELP gitcloner_mirrors Number of mirrors by health status\n")
+	b.WriteString("# TYPE gitcloner_mirrors gauge\n")
+	statuses := make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		fmt.Fprintf(&b, "gitcloner_mirrors{status=%s} %d\n", label(status), counts[status])
+	}
+	if !checked.IsZero() {
+		b.WriteString("# HELP gitcloner_mirror_health_checked_timestamp_seconds Time of the last health check\n")
+		b.WriteString("# TYPE gitcloner_mirror_health_checked_timestamp_seconds gauge\n")
+		fmt.Fprintf(&b, "gitcloner_mirror_health_checked_timestamp_seconds %d\n", checked.Unix())
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
+
+// label quotes a Prometheus label value
+func label(value string) string {
+	return strconv.Quote(value)
+}
@@ -0,0 +1,75 @@
+package health
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+)
+
+// fakeService reports the health of its mirrors, failing for the ones in errs
+type fakeService struct {
+	mirror.MirrorService
+	mirrors []mirror.Repository
+	health  map[string]mirror.Health
+	errs    map[string]error
+}
+
+func (f *fakeService) ListMirrors() ([]mirror.Repository, error) {
+	return f.mirrors, nil
+}
+
+func (f *fakeService) MirrorHealth(m mirror.Repository) (mirror.Health, error) {
+	return f.health[m.Name], f.errs[m.Name]
+}
+
+func TestCheck(t *testing.T) {
+	service := &fakeService{
+		mirrors: []mirror.Repository{{Name: "acme-app"}, {Name: "acme-api"}},
+		health:  map[string]mirror.Health{"acme-app": {Status: mirror.HealthUnknown, LastAttempt: time.Now()}},
+		errs:    map[string]error{"acme-api": errors.New("401 Unauthorized")},
+	}
+
+	results, err := Check(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	if app := results[0]; !app.Healthy() || app.LastAttempt == nil || app.LastUpdate != nil {
+		t.Errorf("acme-app = %+v, want healthy with only a last attempt", app)
+	}
+	if api := results[1]; api.Healthy() || api.Status != mirror.HealthError {
+		t.Errorf("acme-api = %+v, want an unhealthy error", api)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := New(mirror.Config{}, Config{})
+	m.results = []Result{
+		{Name: "acme-app", Status: mirror.HealthOK},
+		{Name: "acme-api", Status: mirror.HealthFailing},
+		{Name: "acme-web", Status: mirror.HealthError},
+	}
+	m.checked = time.Now()
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		`gitcloner_mirror_healthy{mirror="acme-app"} 1`,
+		`gitcloner_mirror_healthy{mirror="acme-api"} 0`,
+		`gitcloner_mirror_healthy{mirror="acme-web"} 0`,
+		`gitcloner_mirrors{status="failing"} 1`,
+		`gitcloner_mirrors{status="ok"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics lack %s:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// checkpoint records the keys of finished jobs in a file, one per line. A nil
+// checkpoint records nothing.
+type checkpoint struct {
+	mu   sync.Mutex
+	file *os.File
+	done map[string]bool
+}
+
+// openCheckpoint reads the jobs finished by earlier runs from path and opens it to record more
+func openCheckpoint(path string) (*checkpoint, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint: %v", err)
+	}
+
+	done := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if key := strings.TrimSpace(scanner.Text()); key != "" {
+			done[key] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	return &checkpoint{file: file, done: done}, nil
+}
+
+func (c *checkpoint) finished(key string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done[key]
+}
+
+// record marks key as finished. Every line is synced, so a killed import loses nothing it finished.
+func (c *checkpoint) record(key string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.file.WriteString(key + "\n"); err != nil {
+		return err
+	}
+	c.done[key] = true
+	return c.file.Sync()
+}
+
+func (c *checkpoint) close() error {
+	return c.file.Close()
+}
@@ -0,0 +1,218 @@
+package importer
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/source"
+)
+
+// ErrInvalidTarget is returned when an import target is not kind:owner
+var ErrInvalidTarget = errors.New("invalid import target")
+
+// Target names the owner whose repositories are imported, e.g. org:acme
+type Target struct {
+	Kind  string // source.OwnerOrg, source.OwnerGroup or source.OwnerUser
+	Owner string
+}
+
+func (t Target) String() string {
+	return t.Kind + ":" + t.Owner
+}
+
+// Options filters the repositories of a target before they are mirrored
+type Options struct {
+	source.ListOptions
+	Include []string // Glob patterns matched against owner/name, empty includes everything
+	Exclude []string // Glob patterns matched against owner/name
+}
+
+// ParseTarget parses org:name, group:path or user:name
+func ParseTarget(spec string) (Target, error) {
+	kind, owner, ok := strings.Cut(strings.TrimSpace(spec), ":")
+	owner = strings.Trim(owner, "/")
+	if !ok || owner == "" {
+		return Target{}, fmt.Errorf("%w %q, use org:name, group:path or user:name", ErrInvalidTarget, spec)
+	}
+	switch kind {
+	case source.OwnerOrg, source.OwnerGroup, source.OwnerUser:
+		return Target{Kind: kind, Owner: owner}, nil
+	}
+	return Target{}, fmt.Errorf("%w %q, use org:name, group:path or user:name", ErrInvalidTarget, spec)
+}
+
+// TargetJobs lists the repositories of target on src. Repositories that don't
+// pass opts or the source policy are skipped when the jobs run. Mirror names
+// only keep the first segment of the owner, so same-named projects in different
+// subgroups would share a mirror. Only the first of them is imported, the others
+// fail.
+func TargetJobs(ctx context.Context, config mirror.Config, src source.Source, target Target, opts Options) ([]Job, error) {
+	repos, err := src.ListRepositories(ctx, target.Kind, target.Owner, opts.ListOptions)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Found %d repositories for %s", len(repos), target)
+
+	service, err := mirror.NewMirrorService(config)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]Job, 0, len(repos))
+	sources := make(map[string]string) // Lowercase mirror name to the source path that takes it
+	for _, repo := range repos {
+		sourcePath := repo.Path()
+		repo.Name = mirror.MirrorName(repo.Owner, repo.Name)
+
+		if opts.match(sourcePath) {
+			key := strings.ToLower(repo.Name)
+			if other, ok := sources[key]; ok {
+				jobs = append(jobs, failedJob(sourcePath, fmt.Errorf("mirror name %s is taken by %s, import one of them from a manifest with another name", repo.Name, other)))
+				continue
+			}
+			sources[key] = sourcePath
+		}
+
+		jobs = append(jobs, Job{
+			Key:     jobKey(config.OrgID, repo.Name),
+			Source:  sourcePath,
+			service: service,
+			resolve: func(ctx context.Context) (mirror.Repository, error) {
+				if !opts.match(sourcePath) {
+					return repo, skip("filtered out")
+				}
+				if err := config.SourcePolicy.Check(ctx, repo.CloneURL); err != nil {
+					return repo, skip("%v", err)
+				}
+				return repo, nil
+			},
+			prepare: func(ctx context.Context, repo mirror.Repository) mirror.Repository {
+				return source.WithTopics(ctx, src, repo)
+			},
+		})
+	}
+	return jobs, nil
+}
+
+// HandleImport returns the jobs for --import. The input is a platform and
+// repository paths, e.g. "github octocat/Hello-World,golang/go", where the
+// platform may name another instance as in "gitlab@https://git.corp group/repo".
+// It can also be a list of repository URLs with their platform, e.g.
+// "gitlab@https://git.corp/group/repo,gitea@https://code.corp/bob/tool".
+// Each repository is looked up on its source when the job runs. An entry that
+// can't be imported becomes a job that fails, so the others are still imported.
+func HandleImport(config mirror.Config, input string) ([]Job, error) {
+	type item struct {
+		platform, path string
+		err            error // Set for an entry that can't be imported
+	}
+	var items []item
+
+	parts := strings.Fields(input)
+	switch len(parts) {
+	case 1:
+		for _, spec := range strings.Split(parts[0], ",") {
+			if spec = strings.TrimSpace(spec); spec == "" {
+				continue
+			}
+			platform, repoPath, err := source.SplitPlatformURL(spec)
+			if err != nil {
+				repoPath = spec
+			}
+			items = append(items, item{platform, repoPath, err})
+		}
+	case 2:
+		for _, repoPath := range strings.Split(parts[1], ",") {
+			items = append(items, item{parts[0], repoPath, nil})
+		}
+	default:
+		return nil, fmt.Errorf("invalid import format. Use: --import 'platform username/repo[,username2/repo2,...]' or --import 'platform@https://host/username/repo[,...]'")
+	}
+
+	// Inline instances are added to the config, so mirrors know which provider serves them
+	sources := make(map[string]source.Source)
+	unknown := make(map[string]error)
+	for _, it := range items {
+		if _, ok := sources[it.platform]; ok || it.err != nil || unknown[it.platform] != nil {
+			continue
+		}
+		src, platformConfig, err := source.ForPlatform(config, it.platform)
+		if err != nil {
+			if len(parts) == 2 {
+				// Every entry names the same platform
+				return nil, err
+			}
+			unknown[it.platform] = err
+			continue
+		}
+		sources[it.platform], config = src, platformConfig
+	}
+
+	service, err := mirror.NewMirrorService(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []Job
+	for _, it := range items {
+		repoPath := strings.Trim(strings.TrimSpace(it.path), "/")
+		if err := cmp.Or(it.err, unknown[it.platform]); err != nil {
+			jobs = append(jobs, failedJob(repoPath, err))
+			continue
+		}
+		if repoPath == "" {
+			continue
+		}
+		src := sources[it.platform]
+
+		i := strings.LastIndex(repoPath, "/")
+		if i <= 0 {
+			jobs = append(jobs, failedJob(repoPath, fmt.Errorf("invalid repository format %q. Use: username/repo", repoPath)))
+			continue
+		}
+		owner, name := repoPath[:i], repoPath[i+1:]
+		jobs = append(jobs, Job{
+			Key:     jobKey(config.OrgID, mirror.MirrorName(owner, name)),
+			Source:  repoPath,
+			service: service,
+			resolve: func(ctx context.Context) (mirror.Repository, error) {
+				repo, err := src.GetRepository(ctx, owner, name)
+				if err != nil {
+					return mirror.Repository{}, err
+				}
+				repo.Name = mirror.MirrorName(owner, repo.Name)
+				return *repo, nil
+			},
+		})
+	}
+	return jobs, nil
+}
+
+// jobKey identifies the mirror named name in destination in a checkpoint
+func jobKey(destination, name string) string {
+	return destination + "/" + name
+}
+
+// match reports whether a repository at sourcePath passes the include and exclude patterns
+func (opts Options) match(sourcePath string) bool {
+	for _, pattern := range opts.Exclude {
+		if ok, _ := path.Match(pattern, sourcePath); ok {
+			return false
+		}
+	}
+	if len(opts.Include) == 0 {
+		return true
+	}
+	for _, pattern := range opts.Include {
+		if ok, _ := path.Match(pattern, sourcePath); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
+	"github.com/janyksteenbeek/gitcloner/pkg/source"
+)
+
+// newEnterpriseServer serves a GitHub Enterprise Server that is both the source,
+// holding acme/app, and the destination, where the backups organization is empty
+func newEnterpriseServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v3/repos/acme/app", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"name":           "app",
+			"clone_url":      "https://" + r.Host + "/acme/app.git",
+			"default_branch": "main",
+			"owner":          map[string]any{"login": "acme"},
+		})
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHandleImportMalformedEntry(t *testing.T) {
+	server := newEnterpriseServer(t)
+	config := mirror.Config{
+		Type:            "github",
+		URL:             server.URL,
+		OrgID:           "backups",
+		TokenSecret:     secret.NewValue("destination-token"),
+		SourceGitHubURL: server.URL,
+	}
+
+	jobs, err := HandleImport(config, "github acme/app,malformed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := Runner{Workers: 2, DryRun: true}.Run(context.Background(), jobs)
+	if !errors.Is(err, ErrImportFailed) {
+		t.Fatalf("Run() error = %v, want ErrImportFailed", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("results = %+v, want 2", results)
+	}
+	if results[0].Status != StatusWouldCreate || results[0].Name != "acme-app" {
+		t.Errorf("results[0] = %+v, want acme-app %s", results[0], StatusWouldCreate)
+	}
+	if results[1].Status != StatusFailed || results[1].Source != "malformed" {
+		t.Errorf("results[1] = %+v, want malformed %s", results[1], StatusFailed)
+	}
+}
+
+// fakeSource lists the same repositories for every owner
+type fakeSource struct {
+	source.Source
+	repos []mirror.Repository
+}
+
+func (f fakeSource) ListRepositories(ctx context.Context, kind, owner string, opts source.ListOptions) ([]mirror.Repository, error) {
+	return f.repos, nil
+}
+
+func TestTargetJobsDuplicateNames(t *testing.T) {
+	server := newEnterpriseServer(t)
+	config := mirror.Config{Type: "github", URL: server.URL, OrgID: "backups", TokenSecret: secret.NewValue("destination-token")}
+	src := fakeSource{repos: []mirror.Repository{
+		{Name: "api", Owner: "platform/a", CloneURL: "https://203.0.113.10/platform/a/api.git"},
+		{Name: "api", Owner: "platform/b", CloneURL: "https://203.0.113.10/platform/b/api.git"},
+		{Name: "web", Owner: "platform/b", CloneURL: "https://203.0.113.10/platform/b/web.git"},
+	}}
+
+	jobs, err := TargetJobs(context.Background(), config, src, Target{Kind: source.OwnerGroup, Owner: "platform"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := Runner{DryRun: true}.Run(context.Background(), jobs)
+	if !errors.Is(err, ErrImportFailed) {
+		t.Fatalf("Run() error = %v, want ErrImportFailed", err)
+	}
+
+	want := []string{StatusWouldCreate, StatusFailed, StatusWouldCreate}
+	for i, result := range results {
+		if result.Status != want[i] {
+			t.Errorf("%s = %s, want %s", result.Source, result.Status, want[i])
+		}
+	}
+	if !strings.Contains(results[1].Reason, "platform/a/api") {
+		t.Errorf("reason %q does not name the project that took the mirror name", results[1].Reason)
+	}
+}
+
+// fakeService has a mirror platform-api that pulls from sourceURL
+type fakeService struct {
+	mirror.MirrorService
+	sourceURL string
+}
+
+func (f fakeService) CheckRepository(repo mirror.Repository) (bool, bool, bool, error) {
+	return repo.Name == "platform-api", true, false, nil
+}
+
+func (f fakeService) GetMirror(repo mirror.Repository) (*mirror.Repository, error) {
+	return &mirror.Repository{Name: repo.Name, SourceURL: f.sourceURL}, nil
+}
+
+func TestImportRepositoryOtherSource(t *testing.T) {
+	job := Job{service: fakeService{sourceURL: "https://203.0.113.10/platform/a/api.git"}}
+
+	tests := []struct {
+		cloneURL string
+		want     string
+	}{
+		{"https://203.0.113.10/platform/a/api.git", StatusExists},
+		{"https://203.0.113.10/platform/b/api.git", StatusFailed},
+	}
+	for _, tt := range tests {
+		repo := mirror.Repository{Name: "platform-api", CloneURL: tt.cloneURL}
+		if status, reason := importRepository(context.Background(), job, repo, true); status != tt.want {
+			t.Errorf("importRepository(%s) = %s (%s), want %s", tt.cloneURL, status, reason, tt.want)
+		}
+	}
+}
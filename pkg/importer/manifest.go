@@ -0,0 +1,326 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/source"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidManifest is returned when a manifest does not validate
+var ErrInvalidManifest = errors.New("invalid manifest")
+
+// Visibilities of a mirror created from a manifest entry
+const (
+	VisibilityPrivate = "private"
+	VisibilityPublic  = "public"
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Entry is a repository listed in a manifest
+type Entry struct {
+	Line        int // Line of the entry in the manifest
+	URL         string
+	Name        string // Mirror name, defaults to owner-name of the source
+	Visibility  string // private or public, defaults to private
+	Description string
+	Destination string // Organization or group on the destination, defaults to DESTINATION_ORG
+}
+
+// Repository returns the repository to mirror for the entry
+func (e Entry) Repository() mirror.Repository {
+	repo := mirror.Repository{
+		Name:        e.Name,
+		Description: e.Description,
+		Private:     e.Visibility != VisibilityPublic,
+		CloneURL:    e.URL,
+	}
+
+	path := repo.Path()
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		repo.Owner = path[:i]
+	}
+	if repo.Name == "" {
+		name := path[strings.LastIndex(path, "/")+1:]
+		if repo.Owner != "" {
+			name = mirror.MirrorName(repo.Owner, name)
+		}
+		repo.Name = name
+	}
+	return repo
+}
+
+// key identifies the entry by its destination and source, as the name of its
+// mirror may only be known once the source is looked up
+func (e Entry) key() string {
+	repo := mirror.Repository{CloneURL: e.URL}
+	return e.Destination + "/" + repo.Host() + "/" + repo.Path()
+}
+
+// LoadManifest reads the repositories listed in a YAML manifest of the form
+//
+//	destination: backups
+//	repositories:
+//	  - url: https://github.com/acme/api
+//	    name: api
+//	    visibility: public
+//
+// Every problem found is reported with its line number.
+func LoadManifest(path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidManifest, path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("%w: %s is empty", ErrInvalidManifest, path)
+	}
+
+	entries, errs := parseManifest(doc.Content[0])
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool {
+			return errs[i].(lineError).line < errs[j].(lineError).line
+		})
+		for i, err := range errs {
+			errs[i] = fmt.Errorf("%s:%v", path, err)
+		}
+		return nil, fmt.Errorf("%w:\n%v", ErrInvalidManifest, errors.Join(errs...))
+	}
+	return entries, nil
+}
+
+// lineError is a validation error at a line of the manifest
+type lineError struct {
+	line int
+	msg  string
+}
+
+func (e lineError) Error() string {
+	return fmt.Sprintf("%d: %s", e.line, e.msg)
+}
+
+func errorAt(node *yaml.Node, format string, args ...any) error {
+	return lineError{line: node.Line, msg: fmt.Sprintf(format, args...)}
+}
+
+func parseManifest(root *yaml.Node) ([]Entry, []error) {
+	if root.Kind != yaml.MappingNode {
+		return nil, []error{errorAt(root, "manifest must be a mapping with a repositories list")}
+	}
+
+	var (
+		errs        []error
+		destination string
+		list        *yaml.Node
+	)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "destination":
+			if value.Kind != yaml.ScalarNode {
+				errs = append(errs, errorAt(value, "destination must be a string"))
+			}
+			destination = value.Value
+		case "repositories":
+			list = value
+		default:
+			errs = append(errs, errorAt(key, "unknown field %q", key.Value))
+		}
+	}
+	if list == nil {
+		return nil, append(errs, errorAt(root, "repositories is missing"))
+	}
+	if list.Kind != yaml.SequenceNode {
+		return nil, append(errs, errorAt(list, "repositories must be a list"))
+	}
+
+	// Mirrors without a name are named from their source when they are imported,
+	// so only sources and explicit names can be compared here
+	var entries []Entry
+	sources, names := make(map[string]int), make(map[string]int)
+	for _, item := range list.Content {
+		entry, entryErrs := parseEntry(item)
+		errs = append(errs, entryErrs...)
+		if len(entryErrs) > 0 {
+			continue
+		}
+		if entry.Destination == "" {
+			entry.Destination = destination
+		}
+
+		key := strings.ToLower(entry.key())
+		if line, ok := sources[key]; ok {
+			errs = append(errs, errorAt(item, "repository %s is already listed on line %d", entry.URL, line))
+			continue
+		}
+		if entry.Name != "" {
+			name := strings.ToLower(entry.Destination + "/" + entry.Name)
+			if line, ok := names[name]; ok {
+				errs = append(errs, errorAt(item, "mirror %s is already listed on line %d", entry.Name, line))
+				continue
+			}
+			names[name] = entry.Line
+		}
+		sources[key] = entry.Line
+		entries = append(entries, entry)
+	}
+	return entries, errs
+}
+
+func parseEntry(item *yaml.Node) (Entry, []error) {
+	if item.Kind == yaml.ScalarNode {
+		// A plain string is a URL with every other field left at its default
+		item = &yaml.Node{Kind: yaml.MappingNode, Line: item.Line, Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Value: "url", Line: item.Line}, item,
+		}}
+	}
+	if item.Kind != yaml.MappingNode {
+		return Entry{}, []error{errorAt(item, "repository must be a URL or a mapping")}
+	}
+
+	entry := Entry{Line: item.Line}
+	var errs []error
+	nodes := make(map[string]*yaml.Node)
+	fields := map[string]*string{
+		"url":         &entry.URL,
+		"name":        &entry.Name,
+		"visibility":  &entry.Visibility,
+		"description": &entry.Description,
+		"destination": &entry.Destination,
+	}
+	for i := 0; i+1 < len(item.Content); i += 2 {
+		key, value := item.Content[i], item.Content[i+1]
+		field, ok := fields[key.Value]
+		if !ok {
+			errs = append(errs, errorAt(key, "unknown field %q", key.Value))
+			continue
+		}
+		if value.Kind != yaml.ScalarNode {
+			errs = append(errs, errorAt(value, "%s must be a string", key.Value))
+			continue
+		}
+		*field = value.Value
+		nodes[key.Value] = value
+	}
+	// Problems with a field are reported on the line of its value
+	at := func(key string) *yaml.Node {
+		if node, ok := nodes[key]; ok {
+			return node
+		}
+		return item
+	}
+
+	switch {
+	case entry.URL == "":
+		errs = append(errs, errorAt(item, "url is required"))
+	case !validCloneURL(entry.URL):
+		errs = append(errs, errorAt(at("url"), "url %q is not a git URL", entry.URL))
+	}
+	if entry.Name != "" && !validName.MatchString(entry.Name) {
+		errs = append(errs, errorAt(at("name"), "name %q may only contain letters, digits, '.', '_' and '-'", entry.Name))
+	}
+	entry.Visibility = strings.ToLower(entry.Visibility)
+	if entry.Visibility != "" && entry.Visibility != VisibilityPrivate && entry.Visibility != VisibilityPublic {
+		errs = append(errs, errorAt(at("visibility"), "visibility must be private or public, not %q", entry.Visibility))
+	}
+	return entry, errs
+}
+
+// validCloneURL reports whether cloneURL names a repository on a host, in URL or scp-like syntax
+func validCloneURL(cloneURL string) bool {
+	repo := mirror.Repository{CloneURL: cloneURL}
+	if repo.Host() == "" || repo.Path() == "" {
+		return false
+	}
+	scheme, _, found := strings.Cut(cloneURL, "://")
+	if !found {
+		// scp-like syntax, git@host:owner/repo.git
+		return strings.Contains(cloneURL, "@")
+	}
+	switch strings.ToLower(scheme) {
+	case "https", "http", "ssh", "git", "git+ssh":
+		return true
+	}
+	return false
+}
+
+// ManifestJobs returns a job for every entry of a manifest, each importing into its
+// destination. Entries on a known forge are looked up on it when the job runs.
+func ManifestJobs(config mirror.Config, entries []Entry) ([]Job, error) {
+	services := make(map[string]mirror.MirrorService)
+	jobs := make([]Job, 0, len(entries))
+	for _, entry := range entries {
+		destination := entry.Destination
+		if destination == "" {
+			destination = config.OrgID
+		}
+
+		service, ok := services[destination]
+		if !ok {
+			destConfig := config
+			destConfig.OrgID = destination
+			var err error
+			if service, err = mirror.NewMirrorService(destConfig); err != nil {
+				return nil, err
+			}
+			services[destination] = service
+		}
+
+		repo := entry.Repository()
+		entry.Destination = destination
+		jobs = append(jobs, Job{
+			Key:     strings.ToLower(entry.key()),
+			Source:  fmt.Sprintf("%s (line %d)", entry.URL, entry.Line),
+			service: service,
+			resolve: func(ctx context.Context) (mirror.Repository, error) {
+				if err := config.SourcePolicy.Check(ctx, repo.CloneURL); err != nil {
+					return repo, skip("%v", err)
+				}
+				return withSourceMetadata(ctx, config, entry, repo)
+			},
+		})
+	}
+	return jobs, nil
+}
+
+// withSourceMetadata fills in what the manifest leaves out of repo from its
+// source, when that is a forge gitcloner knows. Plain git hosts are left as they are.
+func withSourceMetadata(ctx context.Context, config mirror.Config, entry Entry, repo mirror.Repository) (mirror.Repository, error) {
+	src, owner, name, err := source.ForRepository(config, repo)
+	if err != nil {
+		return repo, nil
+	}
+
+	actual, err := src.GetRepository(ctx, owner, name)
+	if err != nil {
+		return repo, err
+	}
+
+	if entry.Name == "" && repo.Owner != "" {
+		// The path only approximates the name the forge shows
+		repo.Name = mirror.MirrorName(repo.Owner, actual.Name)
+	}
+	if entry.Description == "" {
+		repo.Description = actual.Description
+	}
+	if entry.Visibility == "" {
+		repo.Private = actual.Private
+	}
+	repo.DefaultBranch = actual.DefaultBranch
+	repo.Topics = actual.Topics
+	repo.Homepage = actual.Homepage
+	return repo, nil
+}
@@ -0,0 +1,117 @@
+package importer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
+)
+
+// writeManifest writes content to manifest.yaml in a temporary directory
+func writeManifest(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "manifest.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadManifest(t *testing.T) {
+	path := writeManifest(t, `destination: backups
+repositories:
+  - https://github.com/acme/api
+  - url: git@gitlab.com:platform/infra/web.git
+    name: web
+    visibility: PUBLIC
+    destination: archive
+`)
+
+	entries, err := LoadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("entries = %+v, want 2", entries)
+	}
+
+	api := entries[0].Repository()
+	if entries[0].Line != 3 || entries[0].Destination != "backups" || api.Name != "acme-api" || !api.Private {
+		t.Errorf("entries[0] = %+v as %+v, want acme-api in backups from line 3, private", entries[0], api)
+	}
+	web := entries[1].Repository()
+	if entries[1].Line != 4 || entries[1].Destination != "archive" || web.Name != "web" || web.Private {
+		t.Errorf("entries[1] = %+v as %+v, want public web in archive from line 4", entries[1], web)
+	}
+}
+
+func TestLoadManifestErrors(t *testing.T) {
+	path := writeManifest(t, `destination: backups
+owner: acme
+repositories:
+  - url: https://github.com/acme/api
+    visibility: secret
+  - name: no-url
+  - url: not a url
+  - url: https://github.com/acme/web
+    name: "web app"
+  - url: https://gitlab.com/acme/tool
+    name: shared
+  - url: https://gitlab.com/acme/other
+    name: Shared
+  - url: https://github.com/acme/docs
+    tags: [docs]
+  - [https://github.com/acme/list]
+  - https://GitLab.com/acme/tool.git
+`)
+
+	_, err := LoadManifest(path)
+	if !errors.Is(err, ErrInvalidManifest) {
+		t.Fatalf("LoadManifest() error = %v, want ErrInvalidManifest", err)
+	}
+
+	want := []string{
+		`manifest.yaml:2: unknown field "owner"`,
+		`manifest.yaml:5: visibility must be private or public, not "secret"`,
+		`manifest.yaml:6: url is required`,
+		`manifest.yaml:7: url "not a url" is not a git URL`,
+		`manifest.yaml:9: name "web app" may only contain letters, digits, '.', '_' and '-'`,
+		`manifest.yaml:12: mirror Shared is already listed on line 10`,
+		`manifest.yaml:15: unknown field "tags"`,
+		`manifest.yaml:16: repository must be a URL or a mapping`,
+		`manifest.yaml:17: repository https://GitLab.com/acme/tool.git is already listed on line 10`,
+	}
+	lines := strings.Split(err.Error(), "\n")[1:]
+	if len(lines) != len(want) {
+		t.Fatalf("got %d errors, want %d:\n%v", len(lines), len(want), err)
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, want[i]) {
+			t.Errorf("error %d = %q, want %q", i, line, want[i])
+		}
+	}
+}
+
+func TestManifestJobsKeyBySource(t *testing.T) {
+	config := mirror.Config{Type: "github", URL: "https://203.0.113.10", OrgID: "backups", TokenSecret: secret.NewValue("destination-token")}
+	entries := []Entry{
+		{URL: "https://github.com/acme/api", Line: 3},
+		{URL: "https://github.com/acme/api", Name: "api", Destination: "archive", Line: 4},
+	}
+
+	jobs, err := ManifestJobs(config, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The mirror may be renamed from the source, the source stays the same
+	want := []string{"backups/github.com/acme/api", "archive/github.com/acme/api"}
+	for i, job := range jobs {
+		if job.Key != want[i] {
+			t.Errorf("jobs[%d].Key = %q, want %q", i, job.Key, want[i])
+		}
+	}
+}
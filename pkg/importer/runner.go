@@ -0,0 +1,210 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+)
+
+// ErrImportFailed is returned when at least one repository failed to import
+var ErrImportFailed = errors.New("import failed")
+
+// Outcomes of importing a repository
+const (
+	StatusCreated     = "created"
+	StatusWouldCreate = "would be created" // Dry runs only
+	StatusExists      = "already mirrored"
+	StatusSkipped     = "skipped"
+	StatusFailed      = "failed"
+)
+
+// Statuses lists the outcomes in the order they are summarized
+var Statuses = []string{StatusCreated, StatusWouldCreate, StatusExists, StatusSkipped, StatusFailed}
+
+// Job is a repository queued for import
+type Job struct {
+	Key     string // Identifies the job in the checkpoint, e.g. backups/acme-api or backups/github.com/acme/api
+	Source  string // Where the repository comes from, for progress output
+	service mirror.MirrorService
+	resolve func(ctx context.Context) (mirror.Repository, error)
+	prepare func(ctx context.Context, repo mirror.Repository) mirror.Repository // Optional, completes repo right before its mirror is created
+}
+
+// Result is the outcome of one job
+type Result struct {
+	Source string
+	Name   string
+	Status string
+	Reason string
+}
+
+// skipError is returned by a job whose repository is left out on purpose
+type skipError struct{ reason string }
+
+func (e skipError) Error() string { return e.reason }
+
+func skip(format string, args ...any) error {
+	return skipError{reason: fmt.Sprintf(format, args...)}
+}
+
+// failedJob returns a job for an entry that can't be imported, so it is
+// reported as failed without holding up the other repositories
+func failedJob(source string, err error) Job {
+	return Job{
+		Source: source,
+		resolve: func(ctx context.Context) (mirror.Repository, error) {
+			return mirror.Repository{}, err
+		},
+	}
+}
+
+// Runner imports jobs with a number of workers
+type Runner struct {
+	Workers    int    // Defaults to 1
+	DryRun     bool   // Only report what would be imported
+	Checkpoint string // File recording finished jobs, so an interrupted import resumes where it stopped
+}
+
+// Run imports jobs and returns their results in the order of jobs. The error
+// wraps ErrImportFailed when any job failed.
+func (r Runner) Run(ctx context.Context, jobs []Job) ([]Result, error) {
+	var cp *checkpoint
+	if r.Checkpoint != "" {
+		var err error
+		if cp, err = openCheckpoint(r.Checkpoint); err != nil {
+			return nil, err
+		}
+		defer cp.close()
+	}
+
+	workers := r.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	log.Printf("Importing %d repositories with %d workers", len(jobs), workers)
+
+	results := make([]Result, len(jobs))
+	queue := make(chan int)
+	var (
+		wg   sync.WaitGroup
+		done atomic.Int64
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i] = r.run(ctx, cp, jobs[i])
+				result := results[i]
+				n := done.Add(1)
+				if result.Reason != "" {
+					log.Printf("[%d/%d] %s %s: %s", n, len(jobs), result.Source, result.Status, result.Reason)
+				} else {
+					log.Printf("[%d/%d] %s %s", n, len(jobs), result.Source, result.Status)
+				}
+			}
+		}()
+	}
+
+	next := 0
+dispatch:
+	for ; next < len(jobs); next++ {
+		select {
+		case queue <- next:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	// Jobs that were never started count as failed, a later run with the checkpoint picks them up
+	for i := next; i < len(jobs); i++ {
+		results[i] = Result{Source: jobs[i].Source, Status: StatusFailed, Reason: ctx.Err().Error()}
+	}
+
+	failed := Count(results)[StatusFailed]
+	if failed > 0 {
+		return results, fmt.Errorf("%w: %d of %d repositories", ErrImportFailed, failed, len(jobs))
+	}
+	return results, nil
+}
+
+func (r Runner) run(ctx context.Context, cp *checkpoint, job Job) Result {
+	result := Result{Source: job.Source}
+	if cp.finished(job.Key) {
+		result.Status, result.Reason = StatusExists, "finished in an earlier run"
+		return result
+	}
+	if err := ctx.Err(); err != nil {
+		result.Status, result.Reason = StatusFailed, err.Error()
+		return result
+	}
+
+	repo, err := job.resolve(ctx)
+	var skipped skipError
+	switch {
+	case errors.As(err, &skipped):
+		result.Status, result.Reason = StatusSkipped, skipped.reason
+		return result
+	case err != nil:
+		result.Status, result.Reason = StatusFailed, err.Error()
+		return result
+	}
+	result.Name = repo.Name
+
+	result.Status, result.Reason = importRepository(ctx, job, repo, r.DryRun)
+	if !r.DryRun && (result.Status == StatusCreated || result.Status == StatusExists) {
+		if err := cp.record(job.Key); err != nil {
+			log.Printf("Warning: Failed to update checkpoint: %v", err)
+		}
+	}
+	return result
+}
+
+// importRepository creates the mirror of job for repo unless it already exists.
+// A mirror with the name of repo that pulls from another source is a failure,
+// as the repository was never mirrored.
+func importRepository(ctx context.Context, job Job, repo mirror.Repository, dryRun bool) (string, string) {
+	exists, isMirror, _, err := job.service.CheckRepository(repo)
+	if err != nil {
+		return StatusFailed, fmt.Sprintf("failed to check repository: %v", err)
+	}
+	if exists && isMirror {
+		existing, err := job.service.GetMirror(repo)
+		if err != nil {
+			return StatusFailed, fmt.Sprintf("failed to look up mirror: %v", err)
+		}
+		if existing != nil && existing.SourceURL != "" && !mirror.SameSource(existing.SourceURL, repo.CloneURL) {
+			return StatusFailed, fmt.Sprintf("mirror %s pulls from %s", repo.Name, existing.SourceURL)
+		}
+	}
+	if exists {
+		return StatusExists, ""
+	}
+
+	if dryRun {
+		return StatusWouldCreate, ""
+	}
+	if job.prepare != nil {
+		repo = job.prepare(ctx, repo)
+	}
+	if err := job.service.CreateMirror(repo); err != nil {
+		return StatusFailed, err.Error()
+	}
+	return StatusCreated, ""
+}
+
+// Count returns the number of results with each status
+func Count(results []Result) map[string]int {
+	counts := make(map[string]int, len(Statuses))
+	for _, result := range results {
+		counts[result.Status]++
+	}
+	return counts
+}
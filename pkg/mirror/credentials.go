@@ -0,0 +1,339 @@
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/githubapp"
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
+)
+
+// Source providers, used to pick the username that goes with a token in clone URLs
+const (
+	ProviderGitHub      = "github"
+	ProviderGitLab      = "gitlab"
+	ProviderGitea       = "gitea"
+	ProviderBitbucket   = "bitbucket"
+	ProviderAzureDevOps = "azure-devops"
+)
+
+// knownProviders maps public hosts to their provider
+var knownProviders = map[string]string{
+	"github.com":        ProviderGitHub,
+	"gitlab.com":        ProviderGitLab,
+	"gitea.com":         ProviderGitea,
+	"codeberg.org":      ProviderGitea,
+	"bitbucket.org":     ProviderBitbucket,
+	"dev.azure.com":     ProviderAzureDevOps,
+	"ssh.dev.azure.com": ProviderAzureDevOps,
+}
+
+// tokenUsernames holds the username each provider expects next to an access token
+var tokenUsernames = map[string]string{
+	ProviderGitHub:      githubapp.CloneUsername,
+	ProviderGitLab:      "oauth2",
+	ProviderGitea:       "oauth2",
+	ProviderBitbucket:   "x-token-auth",
+	ProviderAzureDevOps: "pat", // Any non-empty username is accepted next to a PAT
+}
+
+// Credential is what gitcloner uses to read a source repository
+type Credential struct {
+	Username   string `json:"username,omitempty"`     // Set for a username and password pair, empty for an access token
+	Password   string `json:"token,omitempty"`        // Access token or password
+	SSHKeyFile string `json:"ssh_key_file,omitempty"` // Private deploy key used for SSH clone URLs
+}
+
+// CredentialRule assigns a credential to the source repositories on Host
+// whose path starts with Owner. An empty Host or Owner matches everything.
+type CredentialRule struct {
+	Host  string `json:"host,omitempty"`
+	Owner string `json:"owner,omitempty"` // Owner, group or subgroup path, e.g. platform/infra
+	Credential
+}
+
+// matches reports whether the rule applies to a repository on host at path
+func (r CredentialRule) matches(host, path string) bool {
+	if r.Host != "" && !strings.EqualFold(r.Host, host) {
+		return false
+	}
+	owner := strings.ToLower(strings.Trim(r.Owner, "/"))
+	return owner == "" || strings.HasPrefix(strings.ToLower(path)+"/", owner+"/")
+}
+
+// specificity ranks matching rules, a deeper owner path beats a host
+func (r CredentialRule) specificity() int {
+	score := 0
+	if owner := strings.Trim(r.Owner, "/"); owner != "" {
+		score += 2 * (strings.Count(owner, "/") + 1)
+	}
+	if r.Host != "" {
+		score++
+	}
+	return score
+}
+
+// LoadCredentials reads credential rules from a JSON file holding a list of
+// objects with host, owner, username, token and ssh_key_file
+func LoadCredentials(path string) ([]CredentialRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source credentials: %v", err)
+	}
+
+	var rules []CredentialRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse source credentials: %v", ErrInvalidConfig, err)
+	}
+	for i, rule := range rules {
+		if rule.Password == "" && rule.SSHKeyFile == "" {
+			return nil, fmt.Errorf("%w: source credential #%d has neither a token nor an SSH key", ErrInvalidConfig, i+1)
+		}
+		secret.Register(rule.Password)
+	}
+	return rules, nil
+}
+
+// CredentialRules holds the source credential rules, which can be replaced
+// while they are in use when their file is reloaded
+type CredentialRules struct {
+	mu    sync.RWMutex
+	rules []CredentialRule
+}
+
+// NewCredentialRules returns CredentialRules holding rules
+func NewCredentialRules(rules ...CredentialRule) *CredentialRules {
+	return &CredentialRules{rules: rules}
+}
+
+// Get returns the current rules, nil when r is nil
+func (r *CredentialRules) Get() []CredentialRule {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.rules
+}
+
+// Set replaces the rules
+func (r *CredentialRules) Set(rules []CredentialRule) {
+	r.mu.Lock()
+	r.rules = rules
+	r.mu.Unlock()
+}
+
+// WatchCredentials loads the credential rules in path and loads them again
+// every interval until ctx is cancelled. A file that fails to load keeps the
+// previous rules in place. A zero interval loads the rules once.
+func WatchCredentials(ctx context.Context, path string, interval time.Duration) (*CredentialRules, error) {
+	rules, err := LoadCredentials(path)
+	if err != nil {
+		return nil, err
+	}
+	r := NewCredentialRules(rules...)
+
+	if interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					rules, err := LoadCredentials(path)
+					if err != nil {
+						log.Printf("Warning: Failed to reload source credentials, keeping the previous ones: %v", err)
+						continue
+					}
+					if !slices.Equal(rules, r.Get()) {
+						r.Set(rules)
+						log.Printf("Reloaded source credentials from %s", path)
+					}
+				}
+			}
+		}()
+	}
+
+	return r, nil
+}
+
+// matchCredential returns the most specific rule for repo. When rules are
+// equally specific the first one wins. Rules with only a deploy key are
+// skipped for HTTPS clone URLs, which need a token.
+func (c Config) matchCredential(repo Repository) (CredentialRule, bool) {
+	host, path := repo.Host(), repo.Path()
+	https := !isSSHURL(repo.CloneURL)
+	best, found := CredentialRule{}, false
+	for _, rule := range c.SourceCredentials.Get() {
+		if !rule.matches(host, path) || (https && rule.Password == "") {
+			continue
+		}
+		if !found || rule.specificity() > best.specificity() {
+			best, found = rule, true
+		}
+	}
+	return best, found
+}
+
+// username returns the username to send with the credential to a provider
+func (c Credential) username(provider string) string {
+	if c.Username != "" {
+		return c.Username
+	}
+	if username, ok := tokenUsernames[provider]; ok {
+		return username
+	}
+	return "oauth2"
+}
+
+// Provider returns the provider hosting host. Hosts configured in
+// SourceProviders take precedence over the well known public hosts.
+func (c Config) Provider(host string) string {
+	host = strings.ToLower(host)
+	if provider, ok := c.SourceProviders[host]; ok {
+		return provider
+	}
+	if provider, ok := knownProviders[host]; ok {
+		return provider
+	}
+	if strings.HasSuffix(host, ".visualstudio.com") {
+		return ProviderAzureDevOps
+	}
+	if c.SourceGitHubURL != "" && host == (&Repository{CloneURL: c.SourceGitHubURL}).Host() {
+		return ProviderGitHub
+	}
+	if c.SourceGitLabURL != "" && host == (&Repository{CloneURL: c.SourceGitLabURL}).Host() {
+		return ProviderGitLab
+	}
+	if c.SourceGiteaURL != "" && host == (&Repository{CloneURL: c.SourceGiteaURL}).Host() {
+		return ProviderGitea
+	}
+	if c.GitHubApp != nil && host == c.GitHubApp.Host() {
+		return ProviderGitHub
+	}
+	return ""
+}
+
+// WithSourceProvider returns a copy of c in which host is served by provider
+func (c Config) WithSourceProvider(host, provider string) Config {
+	providers := make(map[string]string, len(c.SourceProviders)+1)
+	maps.Copy(providers, c.SourceProviders)
+	providers[strings.ToLower(host)] = provider
+	c.SourceProviders = providers
+	return c
+}
+
+// SourceCredential returns the credential used to read repo. A rule naming
+// the owner of repo comes first, then an installation token of the GitHub App
+// for repositories on its instance, then a rule for the host and finally
+// SourceTokenSecret.
+func (c Config) SourceCredential(repo Repository) (Credential, error) {
+	rule, matched := c.matchCredential(repo)
+	if matched && rule.Owner != "" {
+		return rule.Credential, nil
+	}
+
+	token, ok, err := c.sourceAppToken(repo)
+	if err != nil {
+		return Credential{}, err
+	}
+	if ok {
+		return Credential{Username: githubapp.CloneUsername, Password: token}, nil
+	}
+
+	if matched {
+		return rule.Credential, nil
+	}
+	return Credential{
+		Username:   c.SourceUsername,
+		Password:   c.DefaultSourceToken(),
+		SSHKeyFile: c.SourceSSHKeyFile,
+	}, nil
+}
+
+// SourceAuth returns the username and password a destination stores to pull
+// from repo, or empty strings when repo is public. The clone URL must pass the source policy.
+func (c Config) SourceAuth(repo Repository) (string, string, error) {
+	if err := c.checkSource(repo); err != nil {
+		return "", "", err
+	}
+	return c.sourceAuth(repo)
+}
+
+func (c Config) sourceAuth(repo Repository) (string, string, error) {
+	if !repo.Private {
+		return "", "", nil
+	}
+
+	cred, err := c.SourceCredential(repo)
+	if err != nil || cred.Password == "" {
+		return "", "", err
+	}
+	return cred.username(c.Provider(repo.Host())), cred.Password, nil
+}
+
+// SourceGitEnv returns the environment git needs to clone repo, which sets the
+// deploy key for SSH clone URLs
+func (c Config) SourceGitEnv(repo Repository) []string {
+	if !isSSHURL(repo.CloneURL) {
+		return nil
+	}
+	cred, err := c.SourceCredential(repo)
+	if err != nil || cred.SSHKeyFile == "" {
+		return nil
+	}
+	return []string{"GIT_SSH_COMMAND=ssh -i " + shellQuote(cred.SSHKeyFile) + " -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new"}
+}
+
+// shellQuote quotes s as a single word for the shell git runs GIT_SSH_COMMAND with
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// isSSHURL reports whether a clone URL uses SSH, either as a URL or in scp-like syntax
+func isSSHURL(cloneURL string) bool {
+	if strings.HasPrefix(cloneURL, "ssh://") || strings.HasPrefix(cloneURL, "git+ssh://") {
+		return true
+	}
+	if strings.Contains(cloneURL, "://") {
+		return false
+	}
+	at := strings.Index(cloneURL, "@")
+	return at > 0 && strings.Index(cloneURL[at:], ":") > 0
+}
+
+// Path returns the path of the repository on its host without a .git suffix, e.g. platform/infra/api
+func (r *Repository) Path() string {
+	var path string
+	if parsedURL, err := url.Parse(r.CloneURL); err == nil && parsedURL.Host != "" {
+		path = parsedURL.Path
+	} else if _, rest, ok := strings.Cut(r.CloneURL, ":"); ok {
+		// scp-like syntax, git@host:owner/repo.git
+		path = rest
+	} else {
+		return r.Owner
+	}
+	return strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+}
+
+// stripCredentials removes the userinfo from a clone URL
+func stripCredentials(cloneURL string) string {
+	parsedURL, err := url.Parse(cloneURL)
+	if err != nil || parsedURL.User == nil {
+		return cloneURL
+	}
+	parsedURL.User = nil
+	return parsedURL.String()
+}
@@ -3,6 +3,7 @@ package mirror
 import (
 	"fmt"
 	"log"
+	"time"
 
 	"code.gitea.io/sdk/gitea"
 )
@@ -14,11 +15,11 @@ type giteaMirrorService struct {
 
 // NewGiteaMirrorService creates a new Gitea mirror service
 func NewGiteaMirrorService(config Config) (MirrorService, error) {
-	if config.URL == "" || config.Token == "" {
+	if config.URL == "" || config.DestinationToken() == "" {
 		return nil, ErrInvalidConfig
 	}
 
-	client, err := gitea.NewClient(config.URL, gitea.SetToken(config.Token))
+	client, err := gitea.NewClient(config.URL, gitea.SetToken(config.DestinationToken()))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Gitea client: %w", err)
 	}
@@ -83,10 +84,17 @@ func (s *giteaMirrorService) UpdateRepository(repo Repository) error {
 		return err
 	}
 
-	log.Printf("Updating repository %s description", repo.Name)
+	log.Printf("Updating repository %s", repo.Name)
 
 	updateOpts := gitea.EditRepoOption{
 		Description: &repo.Description,
+		Private:     &repo.Private,
+	}
+	if repo.DefaultBranch != "" {
+		updateOpts.DefaultBranch = &repo.DefaultBranch
+	}
+	if repo.Homepage != "" {
+		updateOpts.Website = &repo.Homepage
 	}
 
 	_, _, err = s.client.EditRepo(owner, repo.Name, updateOpts)
@@ -127,7 +135,14 @@ func (s *giteaMirrorService) CreateMirror(repo Repository) error {
 
 	log.Printf("Creating mirror for %s, %s [ %s ]", repo.Name, repo.CloneURL, owner)
 
-	// Set up mirroring using the migration API
+	// Credentials go in the separate auth fields so they never end up in the stored clone address
+	authUsername, authPassword, err := s.config.SourceAuth(repo)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMirrorCreationFailed, err)
+	}
+
+	// Set up mirroring using the migration API. The plain git service accepts a
+	// username and password for any source, the provider services insist on a token.
 	migrationOpts := gitea.MigrateRepoOption{
 		RepoName:       repo.Name,
 		RepoOwner:      owner,
@@ -135,8 +150,9 @@ func (s *giteaMirrorService) CreateMirror(repo Repository) error {
 		Mirror:         true,
 		Private:        repo.Private,
 		Description:    repo.Description,
-		Service:        gitea.GitServiceGitea,
-		AuthToken:      s.config.SourceToken,
+		Service:        gitea.GitServicePlain,
+		AuthUsername:   authUsername,
+		AuthPassword:   authPassword,
 		MirrorInterval: "1h0m0s",
 	}
 
@@ -145,6 +161,25 @@ func (s *giteaMirrorService) CreateMirror(repo Repository) error {
 		return fmt.Errorf("%w: %v", ErrMirrorCreationFailed, err)
 	}
 
+	// The migration has no fields for these, the mirror is complete without them
+	if repo.Homepage != "" || repo.DefaultBranch != "" {
+		editOpts := gitea.EditRepoOption{}
+		if repo.Homepage != "" {
+			editOpts.Website = &repo.Homepage
+		}
+		if repo.DefaultBranch != "" {
+			editOpts.DefaultBranch = &repo.DefaultBranch
+		}
+		if _, _, err := s.client.EditRepo(owner, repo.Name, editOpts); err != nil {
+			log.Printf("Warning: Failed to set homepage and default branch of %s: %v", repo.Name, err)
+		}
+	}
+	if len(repo.Topics) > 0 {
+		if _, err := s.client.SetRepoTopics(owner, repo.Name, repo.Topics); err != nil {
+			log.Printf("Warning: Failed to set topics of %s: %v", repo.Name, err)
+		}
+	}
+
 	return nil
 }
 
@@ -179,3 +214,231 @@ func (s *giteaMirrorService) SyncRepository(repo Repository) error {
 func (s *giteaMirrorService) NeedsManualSync() bool {
 	return false
 }
+
+// ArchiveRepository marks the destination repository as archived
+func (s *giteaMirrorService) ArchiveRepository(repo Repository) error {
+	owner, err := s.getOwner()
+	if err != nil {
+		return err
+	}
+
+	archived := true
+	_, _, err = s.client.EditRepo(owner, repo.Name, gitea.EditRepoOption{Archived: &archived})
+	if err != nil {
+		return fmt.Errorf("failed to archive repository: %v", err)
+	}
+	return nil
+}
+
+// DeleteRepository deletes the destination repository
+func (s *giteaMirrorService) DeleteRepository(repo Repository) error {
+	owner, err := s.getOwner()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.DeleteRepo(owner, repo.Name)
+	if err != nil {
+		return fmt.Errorf("failed to delete repository: %v", err)
+	}
+	return nil
+}
+
+// GetMirror returns the destination repository for repo, or nil when it does not exist
+func (s *giteaMirrorService) GetMirror(repo Repository) (*Repository, error) {
+	existingRepo, err := s.getRepo(repo.Name)
+	if err != nil || existingRepo == nil {
+		return nil, err
+	}
+	return &Repository{
+		Name:          existingRepo.Name,
+		Description:   existingRepo.Description,
+		Private:       existingRepo.Private,
+		CloneURL:      existingRepo.CloneURL,
+		Owner:         existingRepo.Owner.UserName,
+		SourceURL:     existingRepo.OriginalURL,
+		DefaultBranch: existingRepo.DefaultBranch,
+		Homepage:      existingRepo.Website,
+	}, nil
+}
+
+// ListMirrors returns every mirror repository owned by the configured owner
+func (s *giteaMirrorService) ListMirrors() ([]Repository, error) {
+	var repos []*gitea.Repository
+	opts := gitea.ListOptions{Page: 1, PageSize: 50}
+	for {
+		var (
+			page []*gitea.Repository
+			resp *gitea.Response
+			err  error
+		)
+		if s.config.OrgID != "" {
+			page, resp, err = s.client.ListOrgRepos(s.config.OrgID, gitea.ListOrgReposOptions{ListOptions: opts})
+		} else {
+			page, resp, err = s.client.ListMyRepos(gitea.ListReposOptions{ListOptions: opts})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list repositories: %v", err)
+		}
+		repos = append(repos, page...)
+		if resp == nil || resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	var mirrors []Repository
+	for _, r := range repos {
+		if !r.Mirror {
+			continue
+		}
+		mirrors = append(mirrors, Repository{
+			Name:          r.Name,
+			Description:   r.Description,
+			Private:       r.Private,
+			CloneURL:      r.CloneURL,
+			Owner:         r.Owner.UserName,
+			SourceURL:     r.OriginalURL,
+			DefaultBranch: r.DefaultBranch,
+			Homepage:      r.Website,
+		})
+	}
+	return mirrors, nil
+}
+
+// MirrorHealth reports when the mirror last tried to update. Gitea bumps that
+// time after failed pulls too and does not expose their errors, so a mirror
+// that keeps trying is unknown rather than ok. One that missed two intervals in
+// a row is stale.
+func (s *giteaMirrorService) MirrorHealth(mirror Repository) (Health, error) {
+	existingRepo, err := s.getRepo(mirror.Name)
+	if err != nil {
+		return Health{}, err
+	}
+	if existingRepo == nil {
+		return Health{}, fmt.Errorf("repository not found")
+	}
+
+	health := Health{Status: HealthUnknown, LastAttempt: existingRepo.MirrorUpdated}
+	interval, err := time.ParseDuration(existingRepo.MirrorInterval)
+	switch {
+	case existingRepo.MirrorUpdated.IsZero():
+		health.Status = HealthPending
+	case err != nil || interval <= 0:
+		// Periodic updates are disabled, so there is no schedule to fall behind on
+	case time.Since(existingRepo.MirrorUpdated) > 2*interval:
+		health.Status = HealthStale
+	}
+	return health, nil
+}
+
+// StaleCredentials never finds stale credentials. Gitea does not expose why a
+// mirror update failed and moves the update time after failed pulls too, so
+// rejected credentials look like a mirror that is current. Only a mirror that
+// stopped trying altogether, missing two intervals in a row, is reported with
+// ErrStaleUnknown. Rotating a Gitea source token therefore needs --all.
+func (s *giteaMirrorService) StaleCredentials(mirror Repository) (bool, error) {
+	existingRepo, err := s.getRepo(mirror.Name)
+	if err != nil {
+		return false, err
+	}
+	if existingRepo == nil {
+		return false, fmt.Errorf("repository not found")
+	}
+
+	interval, err := time.ParseDuration(existingRepo.MirrorInterval)
+	if err != nil || interval <= 0 || existingRepo.MirrorUpdated.IsZero() {
+		return false, nil
+	}
+	if since := time.Since(existingRepo.MirrorUpdated); since > 2*interval {
+		return false, fmt.Errorf("%w: not updated for %s", ErrStaleUnknown, since.Round(time.Minute))
+	}
+	return false, nil
+}
+
+// RotateCredentials replaces the mirror with one that has the current
+// credentials, as Gitea has no API to change them. The new mirror is migrated
+// under a temporary name and must hold the history before it takes over the
+// name. The old mirror is never deleted: it is renamed and archived, so it can
+// be removed by hand once the new one is confirmed.
+func (s *giteaMirrorService) RotateCredentials(mirror, source Repository) error {
+	owner, err := s.getOwner()
+	if err != nil {
+		return err
+	}
+
+	existingRepo, err := s.getRepo(mirror.Name)
+	if err != nil {
+		return err
+	}
+	if existingRepo == nil {
+		return fmt.Errorf("repository not found")
+	}
+
+	authUsername, authPassword, err := s.config.SourceAuth(source)
+	if err != nil {
+		return err
+	}
+
+	tempName := mirror.Name + "-rotating"
+	_, _, err = s.client.MigrateRepo(gitea.MigrateRepoOption{
+		RepoName:       tempName,
+		RepoOwner:      owner,
+		CloneAddr:      source.CloneURL,
+		Mirror:         true,
+		Private:        existingRepo.Private,
+		Description:    existingRepo.Description,
+		Service:        gitea.GitServicePlain,
+		AuthUsername:   authUsername,
+		AuthPassword:   authPassword,
+		MirrorInterval: existingRepo.MirrorInterval,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to migrate with new credentials: %v", err)
+	}
+
+	// The temporary mirror was created here and holds nothing else, so it is the one repository removed on failure
+	if err := s.verifyReplacement(existingRepo, tempName); err != nil {
+		s.client.DeleteRepo(owner, tempName)
+		return err
+	}
+
+	replacedName := fmt.Sprintf("%s-replaced-%s", mirror.Name, time.Now().UTC().Format("20060102T150405"))
+	if _, _, err := s.client.EditRepo(owner, mirror.Name, gitea.EditRepoOption{Name: &replacedName}); err != nil {
+		s.client.DeleteRepo(owner, tempName)
+		return fmt.Errorf("failed to rename old mirror: %v", err)
+	}
+
+	name := mirror.Name
+	if _, _, err := s.client.EditRepo(owner, tempName, gitea.EditRepoOption{Name: &name}); err != nil {
+		if _, _, rollbackErr := s.client.EditRepo(owner, replacedName, gitea.EditRepoOption{Name: &name}); rollbackErr != nil {
+			return fmt.Errorf("old mirror was renamed to %s and the new one is %s, neither could be renamed back: %v", replacedName, tempName, err)
+		}
+		return fmt.Errorf("new mirror %s could not be renamed, the old mirror is back in place: %v", tempName, err)
+	}
+
+	archived := true
+	if _, _, err := s.client.EditRepo(owner, replacedName, gitea.EditRepoOption{Archived: &archived}); err != nil {
+		log.Printf("Warning: Failed to archive %s: %v", replacedName, err)
+	}
+	log.Printf("Kept the previous mirror of %s as %s, delete it once the new mirror is confirmed", mirror.Name, replacedName)
+	return nil
+}
+
+// verifyReplacement checks that the mirror migrated as name can take over from existing
+func (s *giteaMirrorService) verifyReplacement(existing *gitea.Repository, name string) error {
+	replacement, err := s.getRepo(name)
+	switch {
+	case err != nil:
+		return fmt.Errorf("failed to verify new mirror: %v", err)
+	case replacement == nil:
+		return fmt.Errorf("new mirror %s was not created", name)
+	case !replacement.Mirror:
+		return fmt.Errorf("new mirror %s is not a mirror", name)
+	case replacement.Empty && !existing.Empty:
+		return fmt.Errorf("new mirror %s is empty, the source did not accept the credentials", name)
+	case replacement.DefaultBranch != existing.DefaultBranch && !existing.Empty:
+		return fmt.Errorf("new mirror %s has default branch %s instead of %s", name, replacement.DefaultBranch, existing.DefaultBranch)
+	}
+	return nil
+}
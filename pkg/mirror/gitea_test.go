@@ -0,0 +1,107 @@
+package mirror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
+)
+
+// newGiteaServer serves the Gitea API with a mirror acme/acme-app that last
+// tried to update at mirrorUpdated
+func newGiteaServer(t *testing.T, mirrorUpdated time.Time) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/version", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"version": "1.22.0"})
+	})
+	mux.HandleFunc("GET /api/v1/repos/acme/acme-app", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"name":            "acme-app",
+			"mirror":          true,
+			"mirror_interval": "8h0m0s",
+			"mirror_updated":  mirrorUpdated,
+		})
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGiteaMirrorHealth(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		mirrorUpdated time.Time
+		want          string
+	}{
+		// Gitea also bumps the time after a failed pull, so a recent one proves nothing
+		{"recent attempt", time.Now().Add(-time.Hour), HealthUnknown},
+		{"missed two intervals", time.Now().Add(-17 * time.Hour), HealthStale},
+		{"never updated", time.Time{}, HealthPending},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newGiteaServer(t, tt.mirrorUpdated)
+			service, err := NewGiteaMirrorService(Config{
+				URL:         server.URL,
+				Type:        "gitea",
+				OrgID:       "acme",
+				TokenSecret: secret.NewValue("destination-token"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			health, err := service.(HealthReporter).MirrorHealth(Repository{Name: "acme-app"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if health.Status != tt.want {
+				t.Errorf("Status = %q, want %q", health.Status, tt.want)
+			}
+			if !health.LastUpdate.IsZero() {
+				t.Errorf("LastUpdate = %s, want none as Gitea does not report successful updates", health.LastUpdate)
+			}
+			if !health.LastAttempt.Equal(tt.mirrorUpdated) {
+				t.Errorf("LastAttempt = %s, want %s", health.LastAttempt, tt.mirrorUpdated)
+			}
+		})
+	}
+}
+
+func TestGiteaStaleCredentials(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		mirrorUpdated time.Time
+		wantErr       error
+	}{
+		// A failed pull moves the update time as well, so rejected credentials go unnoticed
+		{"recent attempt", time.Now().Add(-time.Hour), nil},
+		{"stopped trying", time.Now().Add(-17 * time.Hour), ErrStaleUnknown},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newGiteaServer(t, tt.mirrorUpdated)
+			service, err := NewGiteaMirrorService(Config{
+				URL:         server.URL,
+				Type:        "gitea",
+				OrgID:       "acme",
+				TokenSecret: secret.NewValue("destination-token"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stale, err := service.(CredentialRotator).StaleCredentials(Repository{Name: "acme-app"})
+			if stale || !errors.Is(err, tt.wantErr) {
+				t.Errorf("StaleCredentials() = %v, %v, want false, %v", stale, err, tt.wantErr)
+			}
+		})
+	}
+}
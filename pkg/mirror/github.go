@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/url"
+	"strings"
 
 	"github.com/google/go-github/v60/github"
 	"golang.org/x/oauth2"
@@ -17,17 +19,40 @@ type githubMirrorService struct {
 
 // NewGithubMirrorService creates a new GitHub mirror service
 func NewGithubMirrorService(config Config) (MirrorService, error) {
-	if config.URL == "" || config.Token == "" {
+	if config.URL == "" || (config.DestinationToken() == "" && !config.usesGitHubApp()) {
 		return nil, ErrInvalidConfig
 	}
 
 	ctx := context.Background()
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: config.Token},
-	)
+	var ts oauth2.TokenSource
+	if config.usesGitHubApp() {
+		// An installation token belongs to an organization, there is no user to fall back on
+		if config.OrgID == "" {
+			return nil, fmt.Errorf("%w: DESTINATION_ORG is required with a GitHub App", ErrInvalidConfig)
+		}
+		ts = config.GitHubApp.TokenSource(config.OrgID)
+	} else {
+		ts = oauth2.StaticTokenSource(
+			&oauth2.Token{AccessToken: config.DestinationToken()},
+		)
+	}
 	tc := oauth2.NewClient(ctx, ts)
 	client := github.NewClient(tc)
 
+	// Anything other than github.com is a GitHub Enterprise Server
+	if IsGitHubEnterpriseURL(config.URL) {
+		uploadURL := config.UploadURL
+		if uploadURL == "" {
+			uploadURL = config.URL
+		}
+
+		var err error
+		client, err = client.WithEnterpriseURLs(config.URL, uploadURL)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid GitHub Enterprise URL: %v", ErrInvalidConfig, err)
+		}
+	}
+
 	return &githubMirrorService{
 		client: client,
 		config: config,
@@ -35,11 +60,21 @@ func NewGithubMirrorService(config Config) (MirrorService, error) {
 	}, nil
 }
 
+// IsGitHubEnterpriseURL reports whether rawURL points at a GitHub Enterprise Server instead of github.com
+func IsGitHubEnterpriseURL(rawURL string) bool {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil || parsedURL.Host == "" {
+		return false
+	}
+	host := strings.ToLower(parsedURL.Hostname())
+	return host != "github.com" && host != "api.github.com"
+}
+
 // getCurrentUser gets the current authenticated user
 func (s *githubMirrorService) getCurrentUser() (string, error) {
 	user, _, err := s.client.Users.Get(s.ctx, "")
 	if err != nil {
-		return "", fmt.Errorf("failed to get current user: %v", err)
+		return "", fmt.Errorf("failed to get current user: %w", err)
 	}
 	return *user.Login, nil
 }
@@ -64,7 +99,7 @@ func (s *githubMirrorService) getRepo(name string) (*github.Repository, error) {
 		if resp != nil && resp.StatusCode == 404 {
 			return nil, nil
 		}
-		return nil, fmt.Errorf("failed to get repository: %v", err)
+		return nil, fmt.Errorf("failed to get repository: %w", err)
 	}
 	return repo, nil
 }
@@ -97,15 +132,22 @@ func (s *githubMirrorService) UpdateRepository(repo Repository) error {
 		return err
 	}
 
-	log.Printf("Updating repository %s description", repo.Name)
+	log.Printf("Updating repository %s", repo.Name)
 
 	updateRepo := &github.Repository{
 		Description: &repo.Description,
+		Private:     &repo.Private,
+	}
+	if repo.DefaultBranch != "" {
+		updateRepo.DefaultBranch = &repo.DefaultBranch
+	}
+	if repo.Homepage != "" {
+		updateRepo.Homepage = &repo.Homepage
 	}
 
 	_, _, err = s.client.Repositories.Edit(s.ctx, owner, repo.Name, updateRepo)
 	if err != nil {
-		return fmt.Errorf("failed to update repository: %v", err)
+		return fmt.Errorf("failed to update repository: %w", err)
 	}
 
 	return nil
@@ -147,6 +189,7 @@ func (s *githubMirrorService) CreateMirror(repo Repository) error {
 		Name:        &repo.Name,
 		Description: &repo.Description,
 		Private:     &repo.Private,
+		Homepage:    &repo.Homepage,
 	}
 
 	if s.config.OrgID != "" {
@@ -155,11 +198,11 @@ func (s *githubMirrorService) CreateMirror(repo Repository) error {
 		_, _, err = s.client.Repositories.Create(s.ctx, "", newRepo)
 	}
 	if err != nil {
-		return fmt.Errorf("%w: %v", ErrMirrorCreationFailed, err)
+		return fmt.Errorf("%w: %w", ErrMirrorCreationFailed, err)
 	}
 
 	// Get authenticated clone URL if needed
-	cloneURL, err := repo.GetAuthenticatedCloneURL(s.config.SourceToken)
+	cloneURL, err := s.config.SourceCloneURL(repo)
 	if err != nil {
 		// Clean up the created repository
 		_, _ = s.client.Repositories.Delete(s.ctx, owner, repo.Name)
@@ -175,7 +218,13 @@ func (s *githubMirrorService) CreateMirror(repo Repository) error {
 	if err != nil {
 		// Clean up the created repository
 		_, _ = s.client.Repositories.Delete(s.ctx, owner, repo.Name)
-		return fmt.Errorf("%w: %v", ErrMirrorCreationFailed, err)
+		return fmt.Errorf("%w: %w", ErrMirrorCreationFailed, err)
+	}
+
+	if len(repo.Topics) > 0 {
+		if _, _, err := s.client.Repositories.ReplaceAllTopics(s.ctx, owner, repo.Name, repo.Topics); err != nil {
+			log.Printf("Warning: Failed to set topics of %s: %v", repo.Name, err)
+		}
 	}
 
 	return nil
@@ -204,3 +253,140 @@ func (s *githubMirrorService) SyncRepository(repo Repository) error {
 func (s *githubMirrorService) NeedsManualSync() bool {
 	return true
 }
+
+// ArchiveRepository marks the destination repository as archived
+func (s *githubMirrorService) ArchiveRepository(repo Repository) error {
+	owner, err := s.getOwner()
+	if err != nil {
+		return err
+	}
+
+	_, _, err = s.client.Repositories.Edit(s.ctx, owner, repo.Name, &github.Repository{
+		Archived: github.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive repository: %v", err)
+	}
+	return nil
+}
+
+// DeleteRepository deletes the destination repository
+func (s *githubMirrorService) DeleteRepository(repo Repository) error {
+	owner, err := s.getOwner()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.Repositories.Delete(s.ctx, owner, repo.Name)
+	if err != nil {
+		return fmt.Errorf("failed to delete repository: %v", err)
+	}
+	return nil
+}
+
+// GetMirror returns the destination repository for repo, or nil when it does not exist
+func (s *githubMirrorService) GetMirror(repo Repository) (*Repository, error) {
+	existingRepo, err := s.getRepo(repo.Name)
+	if err != nil || existingRepo == nil {
+		return nil, err
+	}
+	return &Repository{
+		Name:          existingRepo.GetName(),
+		Description:   getDescription(existingRepo),
+		Private:       existingRepo.GetPrivate(),
+		CloneURL:      existingRepo.GetCloneURL(),
+		Owner:         existingRepo.GetOwner().GetLogin(),
+		SourceURL:     stripCredentials(existingRepo.GetMirrorURL()),
+		DefaultBranch: existingRepo.GetDefaultBranch(),
+		Homepage:      existingRepo.GetHomepage(),
+	}, nil
+}
+
+// ListMirrors returns every mirror repository owned by the configured owner
+func (s *githubMirrorService) ListMirrors() ([]Repository, error) {
+	var repos []*github.Repository
+	opts := github.ListOptions{PerPage: 100}
+	for {
+		var (
+			page []*github.Repository
+			resp *github.Response
+			err  error
+		)
+		if s.config.OrgID != "" {
+			page, resp, err = s.client.Repositories.ListByOrg(s.ctx, s.config.OrgID, &github.RepositoryListByOrgOptions{ListOptions: opts})
+		} else {
+			page, resp, err = s.client.Repositories.ListByAuthenticatedUser(s.ctx, &github.RepositoryListByAuthenticatedUserOptions{
+				Affiliation: "owner",
+				ListOptions: opts,
+			})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list repositories: %w", err)
+		}
+		repos = append(repos, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	var mirrors []Repository
+	for _, r := range repos {
+		if r.MirrorURL == nil {
+			continue
+		}
+		mirrors = append(mirrors, Repository{
+			Name:          r.GetName(),
+			Description:   getDescription(r),
+			Private:       r.GetPrivate(),
+			CloneURL:      r.GetCloneURL(),
+			Owner:         r.GetOwner().GetLogin(),
+			SourceURL:     stripCredentials(r.GetMirrorURL()),
+			DefaultBranch: r.GetDefaultBranch(),
+			Homepage:      r.GetHomepage(),
+		})
+	}
+	return mirrors, nil
+}
+
+// StaleCredentials reports whether the mirror URL holds other credentials than the ones configured for its source
+func (s *githubMirrorService) StaleCredentials(mirror Repository) (bool, error) {
+	existingRepo, err := s.getRepo(mirror.Name)
+	if err != nil {
+		return false, err
+	}
+	if existingRepo == nil {
+		return false, fmt.Errorf("repository not found")
+	}
+
+	current, err := url.Parse(existingRepo.GetMirrorURL())
+	if err != nil || current.User == nil {
+		// GitHub does not return the credentials, so they cannot be compared
+		return false, nil
+	}
+	_, password, err := s.config.SourceAuth(SourceRepository(mirror))
+	if err != nil {
+		return false, err
+	}
+	currentPassword, _ := current.User.Password()
+	return currentPassword != password, nil
+}
+
+// RotateCredentials points the mirror URL at its source with the current credentials
+func (s *githubMirrorService) RotateCredentials(mirror, source Repository) error {
+	owner, err := s.getOwner()
+	if err != nil {
+		return err
+	}
+
+	cloneURL, err := s.config.SourceCloneURL(source)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = s.client.Repositories.Edit(s.ctx, owner, mirror.Name, &github.Repository{MirrorURL: &cloneURL})
+	if err != nil {
+		return fmt.Errorf("failed to update mirror URL: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package mirror
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
+)
+
+// newEnterpriseServer serves the GitHub Enterprise Server REST API under /api/v3
+// with a single mirror repository acme/acme-app
+func newEnterpriseServer(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+	var requests []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v3/repos/acme/acme-app", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer destination-token" {
+			http.Error(w, `{"message":"Bad credentials"}`, http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"name":        "acme-app",
+			"description": "The app",
+			"mirror_url":  "https://github.example.com/acme/app.git",
+		})
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestGitHubEnterpriseURLs(t *testing.T) {
+	server, _ := newEnterpriseServer(t)
+
+	tests := []struct {
+		name      string
+		uploadURL string
+		want      string
+	}{
+		{"default upload URL", "", server.URL + "/api/uploads/"},
+		{"separate upload URL", "https://uploads.github.example.com", "https://uploads.github.example.com/api/uploads/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, err := NewGithubMirrorService(Config{
+				URL:         server.URL,
+				UploadURL:   tt.uploadURL,
+				OrgID:       "acme",
+				TokenSecret: secret.NewValue("destination-token"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			client := service.(*githubMirrorService).client
+			if got := client.BaseURL.String(); got != server.URL+"/api/v3/" {
+				t.Errorf("BaseURL = %s, want %s/api/v3/", got, server.URL)
+			}
+			if got := client.UploadURL.String(); got != tt.want {
+				t.Errorf("UploadURL = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitHubEnterpriseCheckRepository(t *testing.T) {
+	server, requests := newEnterpriseServer(t)
+	service, err := NewGithubMirrorService(Config{
+		URL:         server.URL,
+		OrgID:       "acme",
+		TokenSecret: secret.NewValue("destination-token"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists, isMirror, needsUpdate, err := service.CheckRepository(Repository{Name: "acme-app", Description: "The app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists || !isMirror || needsUpdate {
+		t.Errorf("CheckRepository = %v, %v, %v, want true, true, false", exists, isMirror, needsUpdate)
+	}
+
+	exists, _, _, err = service.CheckRepository(Repository{Name: "acme-missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("CheckRepository found a repository the server does not have")
+	}
+
+	want := []string{"GET /api/v3/repos/acme/acme-app", "GET /api/v3/repos/acme/acme-missing"}
+	if len(*requests) != len(want) {
+		t.Fatalf("requests = %v, want %v", *requests, want)
+	}
+	for i := range want {
+		if (*requests)[i] != want[i] {
+			t.Errorf("request %d = %s, want %s", i, (*requests)[i], want[i])
+		}
+	}
+}
+
+func TestIsGitHubEnterpriseURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://github.com", false},
+		{"https://GitHub.com/", false},
+		{"https://api.github.com", false},
+		{"https://github.example.com", true},
+		{"https://github.example.com:8443/", true},
+		{"http://127.0.0.1:3000", true},
+		{"github.example.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsGitHubEnterpriseURL(tt.url); got != tt.want {
+			t.Errorf("IsGitHubEnterpriseURL(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
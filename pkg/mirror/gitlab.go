@@ -1,8 +1,11 @@
 package mirror
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"strings"
 
 	gitlab "gitlab.com/gitlab-org/api/client-go"
 )
@@ -14,11 +17,11 @@ type gitlabMirrorService struct {
 
 // NewGitlabMirrorService creates a new GitLab mirror service
 func NewGitlabMirrorService(config Config) (MirrorService, error) {
-	if config.URL == "" || config.Token == "" {
+	if config.URL == "" || config.DestinationToken() == "" {
 		return nil, ErrInvalidConfig
 	}
 
-	client, err := gitlab.NewClient(config.Token, gitlab.WithBaseURL(config.URL))
+	client, err := gitlab.NewClient(config.DestinationToken(), gitlab.WithBaseURL(config.URL))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create GitLab client: %w", err)
 	}
@@ -33,7 +36,7 @@ func NewGitlabMirrorService(config Config) (MirrorService, error) {
 func (s *gitlabMirrorService) getCurrentUser() (string, error) {
 	user, _, err := s.client.Users.CurrentUser()
 	if err != nil {
-		return "", fmt.Errorf("failed to get current user: %v", err)
+		return "", fmt.Errorf("failed to get current user: %w", err)
 	}
 	return user.Username, nil
 }
@@ -54,7 +57,7 @@ func (s *gitlabMirrorService) verifyGroup() error {
 
 	_, _, err := s.client.Groups.GetGroup(s.config.OrgID, nil)
 	if err != nil {
-		return fmt.Errorf("failed to get group: %v", err)
+		return fmt.Errorf("failed to get group: %w", err)
 	}
 	return nil
 }
@@ -68,7 +71,7 @@ func (s *gitlabMirrorService) findProject(name string) (*gitlab.Project, error)
 		}
 		projects, _, err := s.client.Groups.ListGroupProjects(s.config.OrgID, listOpts)
 		if err != nil {
-			return nil, fmt.Errorf("failed to find project in group: %v", err)
+			return nil, fmt.Errorf("failed to find project in group: %w", err)
 		}
 
 		for _, p := range projects {
@@ -83,7 +86,7 @@ func (s *gitlabMirrorService) findProject(name string) (*gitlab.Project, error)
 		}
 		projects, _, err := s.client.Projects.ListProjects(listOpts)
 		if err != nil {
-			return nil, fmt.Errorf("failed to find project: %v", err)
+			return nil, fmt.Errorf("failed to find project: %w", err)
 		}
 
 		for _, p := range projects {
@@ -124,15 +127,19 @@ func (s *gitlabMirrorService) UpdateRepository(repo Repository) error {
 		return fmt.Errorf("project not found")
 	}
 
-	log.Printf("Updating repository %s description", repo.Name)
+	log.Printf("Updating repository %s", repo.Name)
 
 	updateOpts := &gitlab.EditProjectOptions{
 		Description: gitlab.Ptr(repo.Description),
+		Visibility:  visibilityLevel(repo.Private),
+	}
+	if repo.DefaultBranch != "" {
+		updateOpts.DefaultBranch = gitlab.Ptr(repo.DefaultBranch)
 	}
 
 	_, _, err = s.client.Projects.EditProject(project.ID, updateOpts)
 	if err != nil {
-		return fmt.Errorf("failed to update repository: %v", err)
+		return fmt.Errorf("failed to update repository: %w", err)
 	}
 
 	return nil
@@ -170,7 +177,7 @@ func (s *gitlabMirrorService) CreateMirror(repo Repository) error {
 	log.Printf("Creating mirror for %s, %s [ %s ]", repo.Name, repo.CloneURL, owner)
 
 	// Get authenticated clone URL if needed
-	cloneURL, err := repo.GetAuthenticatedCloneURL(s.config.SourceToken)
+	cloneURL, err := s.config.SourceCloneURL(repo)
 	if err != nil {
 		return err
 	}
@@ -184,12 +191,15 @@ func (s *gitlabMirrorService) CreateMirror(repo Repository) error {
 		MirrorTriggerBuilds: gitlab.Ptr(true),
 		Visibility:          visibilityLevel(repo.Private),
 	}
+	if len(repo.Topics) > 0 {
+		opts.Topics = &repo.Topics
+	}
 
 	// Set namespace if using organization
 	if s.config.OrgID != "" {
 		group, _, err := s.client.Groups.GetGroup(s.config.OrgID, nil)
 		if err != nil {
-			return fmt.Errorf("%w: failed to get group: %v", ErrMirrorCreationFailed, err)
+			return fmt.Errorf("%w: failed to get group: %w", ErrMirrorCreationFailed, err)
 		}
 		opts.NamespaceID = &group.ID
 	}
@@ -197,7 +207,7 @@ func (s *gitlabMirrorService) CreateMirror(repo Repository) error {
 	// Create the project
 	_, _, err = s.client.Projects.CreateProject(opts)
 	if err != nil {
-		return fmt.Errorf("%w: %v", ErrMirrorCreationFailed, err)
+		return fmt.Errorf("%w: %w", ErrMirrorCreationFailed, err)
 	}
 
 	return nil
@@ -205,7 +215,7 @@ func (s *gitlabMirrorService) CreateMirror(repo Repository) error {
 
 func (s *gitlabMirrorService) SyncRepository(repo Repository) error {
 	// Get authenticated clone URL if needed
-	cloneURL, err := repo.GetAuthenticatedCloneURL(s.config.SourceToken)
+	cloneURL, err := s.config.SourceCloneURL(repo)
 	if err != nil {
 		return fmt.Errorf("%w: %v", ErrMirrorSyncFailed, err)
 	}
@@ -238,8 +248,213 @@ func (s *gitlabMirrorService) NeedsManualSync() bool {
 	return true
 }
 
+// ArchiveRepository marks the destination project as archived
+func (s *gitlabMirrorService) ArchiveRepository(repo Repository) error {
+	project, err := s.findProject(repo.Name)
+	if err != nil {
+		return err
+	}
+	if project == nil {
+		return fmt.Errorf("project not found")
+	}
+
+	_, _, err = s.client.Projects.ArchiveProject(project.ID)
+	if err != nil {
+		return fmt.Errorf("failed to archive repository: %v", err)
+	}
+	return nil
+}
+
+// DeleteRepository deletes the destination project
+func (s *gitlabMirrorService) DeleteRepository(repo Repository) error {
+	project, err := s.findProject(repo.Name)
+	if err != nil {
+		return err
+	}
+	if project == nil {
+		return fmt.Errorf("project not found")
+	}
+
+	_, err = s.client.Projects.DeleteProject(project.ID, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete repository: %v", err)
+	}
+	return nil
+}
+
+// GetMirror returns the destination project for repo, or nil when it does not exist
+func (s *gitlabMirrorService) GetMirror(repo Repository) (*Repository, error) {
+	project, err := s.findProject(repo.Name)
+	if err != nil || project == nil {
+		return nil, err
+	}
+	return &Repository{
+		Name:          project.Name,
+		Description:   project.Description,
+		Private:       project.Visibility != gitlab.PublicVisibility,
+		CloneURL:      project.HTTPURLToRepo,
+		Owner:         getOwnerFromNamespace(project.PathWithNamespace),
+		SourceURL:     stripCredentials(project.ImportURL),
+		DefaultBranch: project.DefaultBranch,
+	}, nil
+}
+
+// ListMirrors returns every mirror project in the configured group or user namespace
+func (s *gitlabMirrorService) ListMirrors() ([]Repository, error) {
+	var projects []*gitlab.Project
+	opts := gitlab.ListOptions{Page: 1, PerPage: 100}
+	for {
+		var (
+			page []*gitlab.Project
+			resp *gitlab.Response
+			err  error
+		)
+		if s.config.OrgID != "" {
+			page, resp, err = s.client.Groups.ListGroupProjects(s.config.OrgID, &gitlab.ListGroupProjectsOptions{ListOptions: opts})
+		} else {
+			page, resp, err = s.client.Projects.ListProjects(&gitlab.ListProjectsOptions{
+				Owned:       gitlab.Ptr(true),
+				ListOptions: opts,
+			})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list projects: %w", err)
+		}
+		projects = append(projects, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	var mirrors []Repository
+	for _, p := range projects {
+		if !p.Mirror {
+			continue
+		}
+		mirrors = append(mirrors, Repository{
+			Name:          p.Name,
+			Description:   p.Description,
+			Private:       p.Visibility != gitlab.PublicVisibility,
+			CloneURL:      p.HTTPURLToRepo,
+			Owner:         getOwnerFromNamespace(p.PathWithNamespace),
+			SourceURL:     stripCredentials(p.ImportURL),
+			DefaultBranch: p.DefaultBranch,
+		})
+	}
+	return mirrors, nil
+}
+
+// MirrorHealth reports the state of the last pull of the mirror
+func (s *gitlabMirrorService) MirrorHealth(mirror Repository) (Health, error) {
+	project, err := s.findProject(mirror.Name)
+	if err != nil {
+		return Health{}, err
+	}
+	if project == nil {
+		return Health{}, fmt.Errorf("project not found")
+	}
+
+	details, _, err := s.client.Projects.GetProjectPullMirrorDetails(project.ID)
+	if pullMirrorDetailsUnavailable(err) {
+		// Pull mirror details need GitLab Premium, the import status is the next best thing
+		return Health{Status: gitlabHealthStatus(project.ImportStatus), LastError: project.ImportError}, nil
+	}
+	if err != nil {
+		return Health{}, fmt.Errorf("failed to get pull mirror details: %w", err)
+	}
+	health := Health{Status: gitlabHealthStatus(details.UpdateStatus), LastError: details.LastError}
+	if details.LastSuccessfulUpdateAt != nil {
+		health.LastUpdate = *details.LastSuccessfulUpdateAt
+	}
+	return health, nil
+}
+
+// pullMirrorDetailsUnavailable reports whether GitLab refused the pull mirror
+// details, as it does without GitLab Premium or for a project it never mirrored
+func pullMirrorDetailsUnavailable(err error) bool {
+	if errors.Is(err, gitlab.ErrNotFound) {
+		return true
+	}
+	var errResp *gitlab.ErrorResponse
+	if !errors.As(err, &errResp) || errResp.Response == nil {
+		return false
+	}
+	return errResp.Response.StatusCode == http.StatusForbidden
+}
+
+// gitlabHealthStatus maps the import and update states of GitLab to a mirror health status
+func gitlabHealthStatus(status string) string {
+	switch status {
+	case "finished":
+		return HealthOK
+	case "scheduled", "started":
+		return HealthPending
+	case "failed":
+		return HealthFailing
+	}
+	return HealthUnknown
+}
+
+// StaleCredentials reports whether the last pull of the mirror was rejected by its source
+func (s *gitlabMirrorService) StaleCredentials(mirror Repository) (bool, error) {
+	project, err := s.findProject(mirror.Name)
+	if err != nil {
+		return false, err
+	}
+	if project == nil {
+		return false, fmt.Errorf("project not found")
+	}
+
+	details, _, err := s.client.Projects.GetProjectPullMirrorDetails(project.ID)
+	if pullMirrorDetailsUnavailable(err) {
+		// Pull mirror details need GitLab Premium, the import error is the next best thing
+		return project.ImportStatus == "failed" && authFailure(project.ImportError), nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get pull mirror details: %w", err)
+	}
+	return details.UpdateStatus == "failed" && authFailure(details.LastError), nil
+}
+
+// RotateCredentials replaces the import URL of the mirror with one holding the current credentials and retries the pull
+func (s *gitlabMirrorService) RotateCredentials(mirror, source Repository) error {
+	cloneURL, err := s.config.SourceCloneURL(source)
+	if err != nil {
+		return err
+	}
+
+	project, err := s.findProject(mirror.Name)
+	if err != nil {
+		return err
+	}
+	if project == nil {
+		return fmt.Errorf("project not found")
+	}
+
+	_, _, err = s.client.Projects.EditProject(project.ID, &gitlab.EditProjectOptions{
+		ImportURL: gitlab.Ptr(cloneURL),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update import URL: %v", err)
+	}
+
+	if _, err := s.client.Projects.StartMirroringProject(project.ID); err != nil {
+		log.Printf("Warning: Failed to start mirror pull for %s: %v", mirror.Name, err)
+	}
+	return nil
+}
+
 // Helper functions
 
+// getOwnerFromNamespace returns everything before the last path segment of a project path
+func getOwnerFromNamespace(path string) string {
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		return path[:i]
+	}
+	return path
+}
+
 func visibilityLevel(private bool) *gitlab.VisibilityValue {
 	if private {
 		return gitlab.Ptr(gitlab.PrivateVisibility)
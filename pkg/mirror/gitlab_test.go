@@ -0,0 +1,87 @@
+package mirror
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
+)
+
+// newGitLabServer serves the GitLab REST API with a single mirror project
+// acme-app, whose pull mirror details are answered with the given status
+func newGitLabServer(t *testing.T, detailsStatus int) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/projects", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]any{{
+			"id":            1,
+			"name":          "acme-app",
+			"mirror":        true,
+			"import_status": "failed",
+			"import_error":  "could not read from remote",
+		}})
+	})
+	mux.HandleFunc("GET /api/v4/projects/1/mirror/pull", func(w http.ResponseWriter, r *http.Request) {
+		if detailsStatus != http.StatusOK {
+			http.Error(w, `{"message":"refused"}`, detailsStatus)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"update_status":             "finished",
+			"last_successful_update_at": "2024-05-01T12:00:00Z",
+		})
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGitLabMirrorHealth(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		detailsStatus int
+		wantStatus    string
+		wantErr       bool
+	}{
+		{"pull mirror details", http.StatusOK, HealthOK, false},
+		{"without Premium", http.StatusForbidden, HealthFailing, false},
+		{"details not found", http.StatusNotFound, HealthFailing, false},
+		{"rejected token", http.StatusUnauthorized, "", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newGitLabServer(t, tt.detailsStatus)
+			service, err := NewGitlabMirrorService(Config{
+				URL:         server.URL,
+				Type:        "gitlab",
+				TokenSecret: secret.NewValue("destination-token"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			health, err := service.(HealthReporter).MirrorHealth(Repository{Name: "acme-app"})
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "401") {
+					t.Fatalf("MirrorHealth error = %v, want the rejected request", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if health.Status != tt.wantStatus {
+				t.Errorf("Status = %q, want %q", health.Status, tt.wantStatus)
+			}
+			if tt.detailsStatus == http.StatusOK && health.LastUpdate.IsZero() {
+				t.Error("LastUpdate not taken from the pull mirror details")
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package mirror
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrHealthUnsupported is returned when a destination does not report the state of its pull mirrors
+var ErrHealthUnsupported = errors.New("destination does not report mirror health")
+
+// States of a pull mirror on its destination
+const (
+	HealthOK      = "ok"
+	HealthPending = "pending" // An update is scheduled or running
+	HealthFailing = "failing" // The last update failed
+	HealthStale   = "stale"   // No update in longer than expected, without a reported error
+	HealthUnknown = "unknown"
+	HealthError   = "error" // The destination could not be asked
+)
+
+// Health is what a destination reports about the updates of a pull mirror
+type Health struct {
+	Status      string
+	LastUpdate  time.Time // Last successful update, zero when the destination does not say
+	LastAttempt time.Time // Last update, successful or not, from destinations that don't tell them apart
+	LastError   string
+}
+
+// HealthReporter is implemented by destinations that report the state of their pull mirrors
+type HealthReporter interface {
+	MirrorHealth(mirror Repository) (Health, error)
+}
@@ -0,0 +1,152 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrSourceNotAllowed is returned when a clone URL is rejected by the source policy
+var ErrSourceNotAllowed = errors.New("source not allowed")
+
+// DefaultAllowedSchemes are the clone URL schemes accepted when none are configured
+var DefaultAllowedSchemes = []string{"https", "ssh"}
+
+// sharedAddressSpace is the carrier-grade NAT range, which is as internal as the private ranges
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// SourcePolicy restricts where source repositories may be cloned from. Clone
+// URLs come from webhook payloads and are fetched by the destination forge,
+// so a forged payload must not make it reach internal addresses.
+type SourcePolicy struct {
+	AllowedHosts    []string       // Host names or *.example.com patterns, empty allows every public host
+	AllowedSchemes  []string       // Defaults to DefaultAllowedSchemes
+	AllowedNetworks []netip.Prefix // Internal ranges that sources may resolve to
+	Resolver        *net.Resolver  // Defaults to net.DefaultResolver
+}
+
+// Check returns an error wrapping ErrSourceNotAllowed when cloneURL may not be used as a source
+func (p SourcePolicy) Check(ctx context.Context, cloneURL string) error {
+	scheme, host, err := splitCloneURL(cloneURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSourceNotAllowed, err)
+	}
+
+	schemes := p.AllowedSchemes
+	if len(schemes) == 0 {
+		schemes = DefaultAllowedSchemes
+	}
+	if !containsFold(schemes, scheme) {
+		return fmt.Errorf("%w: scheme %s is not allowed", ErrSourceNotAllowed, scheme)
+	}
+
+	if len(p.AllowedHosts) > 0 && !matchHost(p.AllowedHosts, host) {
+		return fmt.Errorf("%w: host %s is not in the allowlist", ErrSourceNotAllowed, host)
+	}
+
+	addrs, err := p.resolve(ctx, host)
+	if err != nil {
+		return fmt.Errorf("%w: failed to resolve %s: %v", ErrSourceNotAllowed, host, err)
+	}
+	// Every address is checked, a host that also resolves to an internal address is rejected
+	for _, addr := range addrs {
+		if internalAddr(addr) && !p.allowedNetwork(addr) {
+			return fmt.Errorf("%w: %s resolves to internal address %s", ErrSourceNotAllowed, host, addr)
+		}
+	}
+	return nil
+}
+
+func (p SourcePolicy) resolve(ctx context.Context, host string) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr.Unmap()}, nil
+	}
+
+	resolver := p.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	ips, err := resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]netip.Addr, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, ip.Unmap())
+	}
+	return addrs, nil
+}
+
+func (p SourcePolicy) allowedNetwork(addr netip.Addr) bool {
+	for _, network := range p.AllowedNetworks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// internalAddr reports whether addr is loopback, link-local, private or otherwise not publicly routable
+func internalAddr(addr netip.Addr) bool {
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		addr.IsUnspecified() || sharedAddressSpace.Contains(addr)
+}
+
+// splitCloneURL returns the scheme and host of a clone URL. The scp-like syntax counts as ssh.
+func splitCloneURL(cloneURL string) (string, string, error) {
+	if isSSHURL(cloneURL) && !strings.Contains(cloneURL, "://") {
+		return "ssh", (&Repository{CloneURL: cloneURL}).Host(), nil
+	}
+
+	parsedURL, err := url.Parse(cloneURL)
+	if err != nil {
+		return "", "", err
+	}
+	if parsedURL.Hostname() == "" {
+		return "", "", fmt.Errorf("clone URL has no host")
+	}
+	return strings.ToLower(parsedURL.Scheme), strings.ToLower(parsedURL.Hostname()), nil
+}
+
+// matchHost reports whether host equals one of patterns or is a subdomain of a *.example.com pattern
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSource applies the source policy to repo and logs a rejection as a security event
+func (c Config) checkSource(repo Repository) error {
+	err := c.SourcePolicy.Check(context.Background(), repo.CloneURL)
+	if err != nil {
+		log.Printf("Security: rejected source %q for %s: %v", repo.CloneURL, repo.Name, err)
+	}
+	return err
+}
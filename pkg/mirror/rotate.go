@@ -0,0 +1,135 @@
+package mirror
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrRotationUnsupported is returned when a destination has no stored source credentials to rotate
+	ErrRotationUnsupported = errors.New("destination does not support rotating source credentials")
+
+	// ErrStaleUnknown is returned when a mirror is not updating but the destination does not say why
+	ErrStaleUnknown = errors.New("mirror is not updating, the destination does not say whether its credentials are the cause")
+)
+
+// CredentialRotator is implemented by destinations whose pull mirrors store source credentials
+type CredentialRotator interface {
+	// StaleCredentials reports whether the mirror looks like it can no longer authenticate with its source
+	StaleCredentials(mirror Repository) (bool, error)
+	// RotateCredentials stores the current credentials for source in the mirror
+	RotateCredentials(mirror, source Repository) error
+}
+
+// Outcomes of checking the credentials of a mirror
+const (
+	RotationUpdated     = "updated"
+	RotationWouldUpdate = "would-update" // The mirror looks stale and would be updated without a dry run
+	RotationCurrent     = "current"
+	RotationSkipped     = "skipped"
+	RotationUnknown     = "unknown" // The mirror is not updating for an unknown reason
+	RotationFailed      = "failed"
+)
+
+// RotationResult is the outcome of checking the credentials of one mirror
+type RotationResult struct {
+	Name   string
+	Status string
+	Reason string
+}
+
+// RotateCredentials checks every mirror of service and stores the current source
+// credentials in the ones that are stale. With force every private mirror is
+// updated, and with dryRun nothing is changed.
+func RotateCredentials(config Config, service MirrorService, force, dryRun bool) ([]RotationResult, error) {
+	rotator, ok := service.(CredentialRotator)
+	if !ok {
+		return nil, ErrRotationUnsupported
+	}
+
+	mirrors, err := service.ListMirrors()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]RotationResult, 0, len(mirrors))
+	for _, m := range mirrors {
+		results = append(results, rotateMirror(config, rotator, m, force, dryRun))
+	}
+	return results, nil
+}
+
+func rotateMirror(config Config, rotator CredentialRotator, m Repository, force, dryRun bool) RotationResult {
+	result := RotationResult{Name: m.Name}
+
+	switch {
+	case !m.Private:
+		result.Status, result.Reason = RotationSkipped, "public"
+		return result
+	case m.SourceURL == "":
+		result.Status, result.Reason = RotationSkipped, "source URL unknown"
+		return result
+	case isSSHURL(m.SourceURL):
+		result.Status, result.Reason = RotationSkipped, "SSH source"
+		return result
+	}
+
+	if !force {
+		stale, err := rotator.StaleCredentials(m)
+		if errors.Is(err, ErrStaleUnknown) {
+			result.Status, result.Reason = RotationUnknown, err.Error()
+			return result
+		}
+		if err != nil {
+			result.Status, result.Reason = RotationFailed, err.Error()
+			return result
+		}
+		if !stale {
+			result.Status = RotationCurrent
+			return result
+		}
+	}
+
+	source := SourceRepository(m)
+	if _, password, err := config.SourceAuth(source); err != nil {
+		result.Status, result.Reason = RotationFailed, err.Error()
+		return result
+	} else if password == "" {
+		result.Status, result.Reason = RotationFailed, "no credential configured for source"
+		return result
+	}
+
+	if dryRun {
+		result.Status = RotationWouldUpdate
+		return result
+	}
+	if err := rotator.RotateCredentials(m, source); err != nil {
+		result.Status, result.Reason = RotationFailed, err.Error()
+		return result
+	}
+	result.Status = RotationUpdated
+	return result
+}
+
+// SourceRepository describes the source a mirror pulls from, so the credential for it can be looked up
+func SourceRepository(m Repository) Repository {
+	source := Repository{
+		Name:        m.Name,
+		Description: m.Description,
+		Private:     m.Private,
+		CloneURL:    m.SourceURL,
+	}
+	source.Owner, _, _ = strings.Cut(source.Path(), "/")
+	return source
+}
+
+// authFailure reports whether a mirror error message points at rejected credentials
+func authFailure(message string) bool {
+	message = strings.ToLower(message)
+	for _, hint := range []string{"authentication", "unauthorized", "forbidden", "401", "403", "could not read username", "access denied", "invalid credentials"} {
+		if strings.Contains(message, hint) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package mirror
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
+)
+
+// fakeRotator reports every mirror with the same staleness
+type fakeRotator struct {
+	stale   bool
+	err     error
+	rotated []string
+}
+
+func (f *fakeRotator) StaleCredentials(mirror Repository) (bool, error) {
+	return f.stale, f.err
+}
+
+func (f *fakeRotator) RotateCredentials(mirror, source Repository) error {
+	f.rotated = append(f.rotated, mirror.Name)
+	return nil
+}
+
+func TestRotateMirror(t *testing.T) {
+	config := Config{SourceTokenSecret: secret.NewValue("token")}
+	m := Repository{Name: "acme-app", Private: true, SourceURL: "https://203.0.113.10/acme/app.git"}
+
+	tests := []struct {
+		name        string
+		rotator     *fakeRotator
+		force       bool
+		dryRun      bool
+		wantStatus  string
+		wantRotated bool
+	}{
+		{"stale", &fakeRotator{stale: true}, false, false, RotationUpdated, true},
+		{"current", &fakeRotator{}, false, false, RotationCurrent, false},
+		{"not updating for an unknown reason", &fakeRotator{err: fmt.Errorf("%w: not updated for 3h", ErrStaleUnknown)}, false, false, RotationUnknown, false},
+		{"forced despite an unknown reason", &fakeRotator{err: ErrStaleUnknown}, true, false, RotationUpdated, true},
+		{"dry run", &fakeRotator{stale: true}, false, true, RotationWouldUpdate, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := rotateMirror(config, tt.rotator, m, tt.force, tt.dryRun)
+			if result.Status != tt.wantStatus {
+				t.Errorf("status = %s (%s), want %s", result.Status, result.Reason, tt.wantStatus)
+			}
+			if rotated := len(tt.rotator.rotated) > 0; rotated != tt.wantRotated {
+				t.Errorf("rotated = %v, want %v", rotated, tt.wantRotated)
+			}
+		})
+	}
+}
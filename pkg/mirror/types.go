@@ -1,9 +1,14 @@
 package mirror
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"net/url"
 	"strings"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/githubapp"
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
 )
 
 // MirrorService defines the interface for repository mirroring
@@ -13,48 +18,269 @@ type MirrorService interface {
 	NeedsManualSync() bool
 	CheckRepository(repo Repository) (exists bool, isMirror bool, needsUpdate bool, err error)
 	UpdateRepository(repo Repository) error
+	ListMirrors() ([]Repository, error)
+	GetMirror(repo Repository) (*Repository, error)
+	ArchiveRepository(repo Repository) error
+	DeleteRepository(repo Repository) error
+}
+
+// Removal policies for mirrors whose source is no longer available
+const (
+	RemovalPolicyKeep    = "keep"
+	RemovalPolicyArchive = "archive"
+	RemovalPolicyDelete  = "delete"
+)
+
+// RemoveMirror applies the removal policy to the mirror of a source repository
+// that went away. repo.CloneURL is the clone URL of the removed source. The
+// destination repository is only archived or deleted when it is a mirror that
+// pulls from that source, so a same-named repository is never touched.
+func RemoveMirror(service MirrorService, policy string, repo Repository) error {
+	switch policy {
+	case "", RemovalPolicyKeep:
+		log.Printf("Keeping mirror %s after its source was removed", repo.Name)
+		return nil
+	case RemovalPolicyArchive, RemovalPolicyDelete:
+	default:
+		return fmt.Errorf("%w: unknown removal policy %q", ErrInvalidConfig, policy)
+	}
+
+	exists, isMirror, _, err := service.CheckRepository(repo)
+	if err != nil {
+		return fmt.Errorf("failed to check repository: %v", err)
+	}
+	if !exists {
+		log.Printf("Not removing %s: there is no such repository", repo.Name)
+		return nil
+	}
+	if !isMirror {
+		log.Printf("Not removing %s: the repository is not a mirror", repo.Name)
+		return nil
+	}
+
+	existing, err := service.GetMirror(repo)
+	if err != nil {
+		return fmt.Errorf("failed to look up mirror: %v", err)
+	}
+	if existing == nil || !SameSource(existing.SourceURL, repo.CloneURL) {
+		log.Printf("Not removing %s: the mirror does not pull from the removed source", repo.Name)
+		return nil
+	}
+
+	if policy == RemovalPolicyArchive {
+		log.Printf("Archiving mirror %s after its source was removed", repo.Name)
+		return service.ArchiveRepository(*existing)
+	}
+	log.Printf("Deleting mirror %s after its source was removed", repo.Name)
+	return service.DeleteRepository(*existing)
+}
+
+// SameSource reports whether a mirror pulling from pullURL mirrors the repository at cloneURL.
+// Hosts and paths are compared case-insensitively, ignoring credentials and a .git suffix.
+func SameSource(pullURL, cloneURL string) bool {
+	if pullURL == "" || cloneURL == "" {
+		return false
+	}
+	pull, clone := Repository{CloneURL: pullURL}, Repository{CloneURL: cloneURL}
+	return strings.EqualFold(pull.Host(), clone.Host()) && strings.EqualFold(pull.Path(), clone.Path())
 }
 
 // Repository represents a generic repository structure
 type Repository struct {
-	Name        string
-	Description string
-	Private     bool
-	CloneURL    string
-	Owner       string
+	Name          string
+	Description   string
+	Private       bool
+	CloneURL      string
+	Owner         string
+	SourceURL     string // Where a destination mirror pulls from, without credentials. Only set on mirrors.
+	DefaultBranch string
+	Topics        []string
+	Homepage      string
+}
+
+// MirrorName returns the destination name of a source repository: the first
+// segment of its owner and the name the forge shows for it, joined by a dash.
+// The GitLab project platform/infra/api named API becomes platform-API.
+// Webhooks, imports, reconciling and polling all name mirrors this way, or a
+// repository would be mirrored twice.
+func MirrorName(owner, name string) string {
+	owner, _, _ = strings.Cut(owner, "/")
+	return fmt.Sprintf("%s-%s", owner, name)
 }
 
-// GetAuthenticatedCloneURL returns the clone URL with authentication if needed
+// GetAuthenticatedCloneURL returns the clone URL with sourceToken when the
+// repository is private, next to the username its host expects for tokens.
+// Config.SourceCloneURL picks the token from the source credentials instead.
 func (r *Repository) GetAuthenticatedCloneURL(sourceToken string) (string, error) {
-	if !r.Private {
+	return r.authenticatedCloneURL(Config{}.Provider(r.Host()), Credential{Password: sourceToken})
+}
+
+// authenticatedCloneURL returns the clone URL with cred when the repository is
+// private. SSH clone URLs are left as they are.
+func (r *Repository) authenticatedCloneURL(provider string, cred Credential) (string, error) {
+	if !r.Private || isSSHURL(r.CloneURL) {
 		return r.CloneURL, nil
 	}
-
-	if sourceToken == "" {
+	if cred.Password == "" {
 		return "", ErrSourceTokenRequired
 	}
+	return r.cloneURLWithCredentials(cred.username(provider), cred.Password)
+}
 
+// cloneURLWithCredentials returns the clone URL with username and password as its userinfo
+func (r *Repository) cloneURLWithCredentials(username, password string) (string, error) {
 	parsedURL, err := url.Parse(r.CloneURL)
 	if err != nil {
 		return "", fmt.Errorf("%w: %v", ErrInvalidCloneURL, err)
 	}
 
-	parsedURL.User = url.UserPassword("oauth2", sourceToken)
+	parsedURL.User = url.UserPassword(username, password)
 	return parsedURL.String(), nil
 }
 
+// Host returns the host name of the clone URL
+func (r *Repository) Host() string {
+	if isSSHURL(r.CloneURL) && !strings.Contains(r.CloneURL, "://") {
+		// scp-like syntax, git@host:owner/repo.git
+		_, rest, _ := strings.Cut(r.CloneURL, "@")
+		host, _, _ := strings.Cut(rest, ":")
+		return strings.ToLower(host)
+	}
+
+	parsedURL, err := url.Parse(r.CloneURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsedURL.Hostname())
+}
+
 // Config holds the configuration for a mirror service
 type Config struct {
-	URL         string
-	Token       string
-	OrgID       string // Can be empty for personal accounts
-	Type        string
-	SourceToken string // Token used for authenticating with source repositories
+	URL              string
+	UploadURL        string // Upload URL of a GitHub Enterprise Server, defaults to URL
+	OrgID            string // Can be empty for personal accounts
+	Type             string
+	SourceUsername   string // Username sent with the source token, defaults to the one the source provider expects for tokens
+	SourceSSHKeyFile string // Deploy key for sources cloned over SSH
+	SourceGitHubURL  string // Base URL of the GitHub source, defaults to https://github.com
+	SourceGitLabURL  string // Base URL of the GitLab source, defaults to https://gitlab.com
+	SourceGiteaURL   string // Base URL of the Gitea source, defaults to URL
+	RemovalPolicy    string // What happens to a mirror when its source is removed: keep, archive or delete
+
+	// SourcePolicy restricts the hosts and schemes sources are cloned from
+	SourcePolicy SourcePolicy
+
+	// SourceCredentials are matched by host and owner before falling back to SourceTokenSecret
+	SourceCredentials *CredentialRules
+
+	// SourceProviders maps self-hosted source hosts to their provider, e.g. git.corp.example to gitlab
+	SourceProviders map[string]string
+
+	// TokenSecret and SourceTokenSecret hold the destination token and the
+	// token for authenticating with source repositories. They are reloaded
+	// while the server runs.
+	TokenSecret       *secret.Value
+	SourceTokenSecret *secret.Value
+
+	// GitHubApp authenticates as a GitHub App. It replaces TokenSecret for a GitHub
+	// destination without a token, and SourceTokenSecret for sources on the app's instance.
+	GitHubApp *githubapp.App
+}
+
+// DestinationToken returns the current destination token
+func (c Config) DestinationToken() string {
+	return c.TokenSecret.Get()
+}
+
+// DefaultSourceToken returns the current token for sources without a more specific credential
+func (c Config) DefaultSourceToken() string {
+	return c.SourceTokenSecret.Get()
+}
+
+// usesGitHubApp reports whether the destination authenticates as a GitHub App
+func (c Config) usesGitHubApp() bool {
+	return c.Type == "github" && c.DestinationToken() == "" && c.GitHubApp != nil
+}
+
+// sourceAppToken returns an installation token for repo when it lives on the GitHub App's instance
+func (c Config) sourceAppToken(repo Repository) (string, bool, error) {
+	if c.GitHubApp == nil || repo.Host() != c.GitHubApp.Host() {
+		return "", false, nil
+	}
+	token, err := c.GitHubApp.Token(context.Background(), repo.Owner)
+	return token, true, err
+}
+
+// SourceCloneURL returns the clone URL of a source repository, with credentials when it is private.
+// The clone URL must pass the source policy.
+// The username depends on the source provider, and SSH clone URLs are left as they are.
+func (c Config) SourceCloneURL(repo Repository) (string, error) {
+	if err := c.checkSource(repo); err != nil {
+		return "", err
+	}
+	if !repo.Private || isSSHURL(repo.CloneURL) {
+		return repo.CloneURL, nil
+	}
+
+	cred, err := c.SourceCredential(repo)
+	if err != nil {
+		return "", err
+	}
+	return repo.authenticatedCloneURL(c.Provider(repo.Host()), cred)
+}
+
+// DestinationCloneURL returns the clone URL of a destination repository with the destination credentials
+func (c Config) DestinationCloneURL(repo Repository) (string, error) {
+	if c.usesGitHubApp() {
+		token, err := c.GitHubApp.Token(context.Background(), c.OrgID)
+		if err != nil {
+			return "", err
+		}
+		return repo.cloneURLWithCredentials(githubapp.CloneUsername, token)
+	}
+
+	token := c.DestinationToken()
+	if token == "" {
+		return "", ErrInvalidConfig
+	}
+	return repo.cloneURLWithCredentials(Credential{}.username(c.Type), token)
+}
+
+// DefaultGitHubURL is the GitHub source used when no GitHub Enterprise Server is configured
+const DefaultGitHubURL = "https://github.com"
+
+// GitHubSourceURL returns the base URL of the GitHub source without a trailing slash
+func (c Config) GitHubSourceURL() string {
+	if c.SourceGitHubURL == "" {
+		return DefaultGitHubURL
+	}
+	return strings.TrimSuffix(c.SourceGitHubURL, "/")
+}
+
+// DefaultGitLabURL is the GitLab source used when no self-hosted instance is configured
+const DefaultGitLabURL = "https://gitlab.com"
+
+// GitLabSourceURL returns the base URL of the GitLab source without a trailing slash
+func (c Config) GitLabSourceURL() string {
+	if c.SourceGitLabURL == "" {
+		return DefaultGitLabURL
+	}
+	return strings.TrimSuffix(c.SourceGitLabURL, "/")
+}
+
+// GiteaSourceURL returns the base URL of the Gitea source without a trailing
+// slash. Without SourceGiteaURL the destination is also the source, as imports
+// have always assumed.
+func (c Config) GiteaSourceURL() string {
+	if c.SourceGiteaURL == "" {
+		return strings.TrimSuffix(c.URL, "/")
+	}
+	return strings.TrimSuffix(c.SourceGiteaURL, "/")
 }
 
 // NewMirrorService creates a new mirror service based on the configuration
 func NewMirrorService(config Config) (MirrorService, error) {
-	if config.URL == "" || config.Token == "" {
+	if config.URL == "" || (config.DestinationToken() == "" && !config.usesGitHubApp()) {
 		return nil, ErrInvalidConfig
 	}
 
@@ -0,0 +1,80 @@
+package mirror
+
+import "testing"
+
+// fakeService is a destination holding a single repository
+type fakeService struct {
+	MirrorService
+	repo     *Repository
+	isMirror bool
+	removed  string
+}
+
+func (f *fakeService) CheckRepository(repo Repository) (bool, bool, bool, error) {
+	if f.repo == nil || f.repo.Name != repo.Name {
+		return false, false, false, nil
+	}
+	return true, f.isMirror, false, nil
+}
+
+func (f *fakeService) GetMirror(repo Repository) (*Repository, error) {
+	if f.repo == nil || f.repo.Name != repo.Name {
+		return nil, nil
+	}
+	return f.repo, nil
+}
+
+func (f *fakeService) ArchiveRepository(repo Repository) error {
+	f.removed = "archived " + repo.Name
+	return nil
+}
+
+func (f *fakeService) DeleteRepository(repo Repository) error {
+	f.removed = "deleted " + repo.Name
+	return nil
+}
+
+func TestRemoveMirror(t *testing.T) {
+	removedSource := Repository{Name: "acme-app", Owner: "acme", CloneURL: "https://github.com/acme/app.git"}
+
+	tests := []struct {
+		name     string
+		policy   string
+		existing *Repository
+		isMirror bool
+		want     string
+	}{
+		{"archives the mirror", RemovalPolicyArchive, &Repository{Name: "acme-app", SourceURL: "https://github.com/acme/app.git"}, true, "archived acme-app"},
+		{"deletes the mirror", RemovalPolicyDelete, &Repository{Name: "acme-app", SourceURL: "https://GitHub.com/Acme/app"}, true, "deleted acme-app"},
+		{"keeps the mirror", RemovalPolicyKeep, &Repository{Name: "acme-app", SourceURL: "https://github.com/acme/app.git"}, true, ""},
+		{"missing repository", RemovalPolicyDelete, nil, false, ""},
+		{"not a mirror", RemovalPolicyDelete, &Repository{Name: "acme-app"}, false, ""},
+		{"mirror of another source", RemovalPolicyDelete, &Repository{Name: "acme-app", SourceURL: "https://gitlab.com/acme/app.git"}, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &fakeService{repo: tt.existing, isMirror: tt.isMirror}
+			if err := RemoveMirror(service, tt.policy, removedSource); err != nil {
+				t.Fatal(err)
+			}
+			if service.removed != tt.want {
+				t.Errorf("removed = %q, want %q", service.removed, tt.want)
+			}
+		})
+	}
+}
+
+func TestMirrorName(t *testing.T) {
+	tests := []struct {
+		owner, name, want string
+	}{
+		{"janyksteenbeek", "myrepo", "janyksteenbeek-myrepo"},
+		{"platform/infra", "API", "platform-API"},
+		{"platform", "api", "platform-api"},
+	}
+	for _, tt := range tests {
+		if got := MirrorName(tt.owner, tt.name); got != tt.want {
+			t.Errorf("MirrorName(%q, %q) = %q, want %q", tt.owner, tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package orphan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/git"
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/source"
+)
+
+// Statuses of the source of a mirror
+const (
+	StatusOK       = "ok"
+	StatusMissing  = "missing"  // The source was deleted or is no longer visible to the source credentials
+	StatusMoved    = "moved"    // The source was renamed or transferred, Location holds where it lives now
+	StatusUnknown  = "unknown"  // The mirror does not tell where it pulls from
+	StatusFailed   = "failed"   // The source could not be checked
+	StatusArchived = "archived" // The mirror was missing its source and has been archived
+)
+
+// Result is the outcome of checking the source of one mirror
+type Result struct {
+	Name     string `json:"name"`
+	Source   string `json:"source,omitempty"`
+	Status   string `json:"status"`
+	Location string `json:"location,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Orphan reports whether the mirror lost its source
+func (r Result) Orphan() bool {
+	return r.Status == StatusMissing || r.Status == StatusMoved || r.Status == StatusArchived
+}
+
+// Options changes what Check does with orphaned mirrors
+type Options struct {
+	Archive bool // Archive mirrors whose source is missing
+	DryRun  bool // Report what would be archived without changing anything
+}
+
+// Check lists every mirror of service and checks that the source it pulls from
+// still exists. Sources on GitHub, GitLab and Gitea are looked up through their
+// API, which also follows renames and transfers. Other sources are checked with
+// git ls-remote. A missing source is only archived when another source on the
+// same host was found, as a host where none are found more likely rejects the
+// source credentials than lost every repository.
+func Check(ctx context.Context, config mirror.Config, service mirror.MirrorService, opts Options) ([]Result, error) {
+	mirrors, err := service.ListMirrors()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, 0, len(mirrors))
+	for _, m := range mirrors {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		results = append(results, check(ctx, config, m))
+	}
+	if !opts.Archive {
+		return results, nil
+	}
+
+	found := foundHosts(mirrors, results)
+	for i, m := range mirrors {
+		if results[i].Status != StatusMissing {
+			continue
+		}
+		if host := sourceHost(m); !found[host] {
+			results[i].Reason = fmt.Sprintf("not archived, no source on %s was found, check that the source credentials can still see them", host)
+			continue
+		}
+		results[i] = archive(service, m, results[i], opts.DryRun)
+	}
+	return results, nil
+}
+
+// foundHosts returns the source hosts on which at least one looked up source exists
+func foundHosts(mirrors []mirror.Repository, results []Result) map[string]bool {
+	found := make(map[string]bool)
+	for i, m := range mirrors {
+		if results[i].Status == StatusOK || results[i].Status == StatusMoved {
+			found[sourceHost(m)] = true
+		}
+	}
+	return found
+}
+
+// sourceHost returns the host the mirror m pulls from
+func sourceHost(m mirror.Repository) string {
+	repo := mirror.SourceRepository(m)
+	return repo.Host()
+}
+
+func check(ctx context.Context, config mirror.Config, m mirror.Repository) Result {
+	result := Result{Name: m.Name, Source: m.SourceURL}
+	if m.SourceURL == "" {
+		result.Status, result.Reason = StatusUnknown, "source URL unknown"
+		return result
+	}
+	repo := mirror.SourceRepository(m)
+
+	if provider, _ := source.Instance(config, repo); provider == "" {
+		return checkGit(ctx, config, repo, result)
+	}
+
+	src, owner, name, err := source.ForRepository(config, repo)
+	if err != nil {
+		result.Status, result.Reason = StatusFailed, err.Error()
+		return result
+	}
+	actual, err := src.GetRepository(ctx, owner, name)
+	switch {
+	case errors.Is(err, source.ErrRepositoryNotFound):
+		result.Status = StatusMissing
+		return result
+	case err != nil:
+		result.Status, result.Reason = StatusFailed, err.Error()
+		return result
+	}
+
+	// Forges redirect the old path of a renamed or transferred repository to the new one
+	if actual.CloneURL != "" && !strings.EqualFold(actual.Path(), repo.Path()) {
+		result.Status, result.Location = StatusMoved, actual.Path()
+		return result
+	}
+	result.Status = StatusOK
+	return result
+}
+
+// checkGit checks a source that is not on a known forge by listing its refs
+func checkGit(ctx context.Context, config mirror.Config, repo mirror.Repository, result Result) Result {
+	remote, err := config.SourceCloneURL(repo)
+	if err != nil {
+		result.Status, result.Reason = StatusFailed, err.Error()
+		return result
+	}
+
+	ctx = git.WithEnv(ctx, config.SourceGitEnv(repo)...)
+	if _, err := git.LsRemote(ctx, remote, "HEAD"); err != nil {
+		if notFound(err) {
+			result.Status = StatusMissing
+			return result
+		}
+		result.Status, result.Reason = StatusFailed, err.Error()
+		return result
+	}
+	result.Status = StatusOK
+	return result
+}
+
+func archive(service mirror.MirrorService, m mirror.Repository, result Result, dryRun bool) Result {
+	if dryRun {
+		result.Reason = "would be archived, dry run"
+		return result
+	}
+	if err := service.ArchiveRepository(m); err != nil {
+		result.Status, result.Reason = StatusFailed, "source missing, failed to archive: "+err.Error()
+		return result
+	}
+	log.Printf("Archived orphaned mirror %s", m.Name)
+	result.Status = StatusArchived
+	return result
+}
+
+// notFound reports whether a git error means the remote repository does not exist
+func notFound(err error) bool {
+	message := strings.ToLower(err.Error())
+	for _, hint := range []string{"not found", "does not exist", "does not appear to be a git repository", "404"} {
+		if strings.Contains(message, hint) {
+			return true
+		}
+	}
+	return false
+}
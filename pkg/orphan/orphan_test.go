@@ -0,0 +1,127 @@
+package orphan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
+)
+
+// fakeService lists mirrors and records the ones it archives
+type fakeService struct {
+	mirror.MirrorService
+	mirrors  []mirror.Repository
+	archived []string
+}
+
+func (f *fakeService) ListMirrors() ([]mirror.Repository, error) {
+	return f.mirrors, nil
+}
+
+func (f *fakeService) ArchiveRepository(m mirror.Repository) error {
+	f.archived = append(f.archived, m.Name)
+	return nil
+}
+
+// newSourceServer serves the GitHub Enterprise Server REST API with acme/app,
+// and acme/renamed redirected to acme/new. Every other repository is missing.
+func newSourceServer(t *testing.T) (*httptest.Server, mirror.Config) {
+	t.Helper()
+	mux := http.NewServeMux()
+	for path, name := range map[string]string{"acme/app": "app", "acme/renamed": "new"} {
+		mux.HandleFunc("GET /api/v3/repos/"+path, func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]any{
+				"name":      name,
+				"clone_url": "http://" + r.Host + "/acme/" + name + ".git",
+				"owner":     map[string]any{"login": "acme"},
+			})
+		})
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, mirror.Config{SourceGitHubURL: server.URL, SourceTokenSecret: secret.NewValue("source-token")}
+}
+
+func TestCheck(t *testing.T) {
+	server, config := newSourceServer(t)
+	service := &fakeService{mirrors: []mirror.Repository{
+		{Name: "acme-app", SourceURL: server.URL + "/acme/app.git"},
+		{Name: "acme-gone", SourceURL: server.URL + "/acme/gone.git"},
+		{Name: "acme-renamed", SourceURL: server.URL + "/acme/renamed.git"},
+		{Name: "acme-unknown"},
+	}}
+
+	results, err := Check(context.Background(), config, service, Options{Archive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct{ status, location string }{
+		{StatusOK, ""},
+		{StatusArchived, ""},
+		{StatusMoved, "acme/new"},
+		{StatusUnknown, ""},
+	}
+	for i, r := range results {
+		if r.Status != want[i].status || r.Location != want[i].location {
+			t.Errorf("%s = %s %q, want %s %q (%s)", r.Name, r.Status, r.Location, want[i].status, want[i].location, r.Reason)
+		}
+	}
+	if len(service.archived) != 1 || service.archived[0] != "acme-gone" {
+		t.Errorf("archived %v, want only the mirror whose source is missing", service.archived)
+	}
+}
+
+func TestCheckKeepsMirrorsWhenNoSourceIsFound(t *testing.T) {
+	server, config := newSourceServer(t)
+	service := &fakeService{mirrors: []mirror.Repository{
+		{Name: "acme-gone", SourceURL: server.URL + "/acme/gone.git"},
+		{Name: "acme-lost", SourceURL: server.URL + "/acme/lost.git"},
+	}}
+
+	// A revoked source token hides every repository, which must not archive them all
+	results, err := Check(context.Background(), config, service, Options{Archive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Status != StatusMissing || !strings.HasPrefix(r.Reason, "not archived") {
+			t.Errorf("%s = %s (%s), want missing and not archived", r.Name, r.Status, r.Reason)
+		}
+	}
+	if len(service.archived) != 0 {
+		t.Errorf("archived %v, want none", service.archived)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	for _, tt := range []struct {
+		message string
+		want    bool
+	}{
+		{"remote: Repository not found.\nfatal: repository 'https://git.example.com/acme/app.git/' not found", true},
+		{"fatal: '/srv/git/acme/app.git' does not appear to be a git repository", true},
+		{"fatal: unable to access 'https://git.example.com/acme/app.git/': The requested URL returned error: 404", true},
+		{"fatal: project acme/app does not exist", true},
+		{"fatal: unable to access 'https://git.example.com/acme/app.git/': The requested URL returned error: 403", false},
+		{"fatal: unable to access 'https://git.example.com/acme/app.git/': Could not resolve host: git.example.com", false},
+		{"ssh: connect to host git.example.com port 22: Connection refused", false},
+	} {
+		if got := notFound(errors.New(tt.message)); got != tt.want {
+			t.Errorf("notFound(%q) = %v, want %v", tt.message, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package poll
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/git"
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/source"
+)
+
+// Config holds the configuration of the poller
+type Config struct {
+	Repositories   []string // Clone URLs of the repositories to poll
+	Interval       time.Duration
+	FullCheckEvery int    // Refs of GitHub repositories are listed every this many polls even when GitHub reports no change, defaults to 12
+	StateFile      string // Keeps the refs seen between restarts when set
+}
+
+// HandleFunc receives every ref update found by the poller. A created ref has an
+// all-zero before SHA and a deleted ref an all-zero after SHA. The first poll of
+// a repository without saved state reports its default branch with an empty before SHA.
+type HandleFunc func(repo mirror.Repository, ref, before, after string) error
+
+// Poller checks repositories for new commits on sources that can't send webhooks
+type Poller struct {
+	config       Config
+	mirrorConfig mirror.Config
+	handle       HandleFunc
+	client       *http.Client
+
+	mu     sync.Mutex
+	states map[string]*state
+}
+
+// state is what the poller remembers of a repository between polls
+type state struct {
+	repo   mirror.Repository
+	etag   string
+	refs   map[string]string // Refs whose updates were handled
+	polls  int
+	failed bool // A ref update failed, so refs are listed again on the next poll
+}
+
+// New creates a poller that feeds the changes it finds to handle
+func New(mirrorConfig mirror.Config, config Config, handle HandleFunc) *Poller {
+	if config.Interval <= 0 {
+		config.Interval = 5 * time.Minute
+	}
+	if config.FullCheckEvery <= 0 {
+		config.FullCheckEvery = 12
+	}
+	return &Poller{
+		config:       config,
+		mirrorConfig: mirrorConfig,
+		handle:       handle,
+		client:       &http.Client{Timeout: 30 * time.Second},
+		states:       make(map[string]*state),
+	}
+}
+
+// Run polls every repository once per interval until ctx is cancelled
+func (p *Poller) Run(ctx context.Context) {
+	if err := p.load(); err != nil {
+		log.Printf("Warning: Failed to load poll state, every repository is polled as new: %v", err)
+	}
+
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.PollAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PollAll polls every configured repository once and saves the state
+func (p *Poller) PollAll(ctx context.Context) {
+	for _, cloneURL := range p.config.Repositories {
+		if err := p.Poll(ctx, cloneURL); err != nil {
+			log.Printf("Warning: Failed to poll %s: %v", cloneURL, err)
+		}
+	}
+	if err := p.save(); err != nil {
+		log.Printf("Warning: Failed to save poll state: %v", err)
+	}
+}
+
+// Poll checks a single repository and hands every changed ref to the handler
+func (p *Poller) Poll(ctx context.Context, cloneURL string) error {
+	p.mu.Lock()
+	st, ok := p.states[cloneURL]
+	if !ok {
+		st = &state{repo: repository(p.mirrorConfig, cloneURL)}
+		p.states[cloneURL] = st
+	}
+	st.polls++
+	p.mu.Unlock()
+
+	if err := p.mirrorConfig.SourcePolicy.Check(ctx, cloneURL); err != nil {
+		return err
+	}
+
+	// Forges answer a conditional request for the repository with 304 while its
+	// metadata is unchanged. Only GitHub changes it on every push, refs on other
+	// forges are always listed, and on GitHub now and then in case a push was missed.
+	if provider, base := source.Instance(p.mirrorConfig, st.repo); provider != "" {
+		changed, err := p.checkForge(ctx, st, provider, base)
+		if err != nil {
+			return err
+		}
+		if !changed && st.refs != nil && !st.failed && provider == mirror.ProviderGitHub && st.polls%p.config.FullCheckEvery != 0 {
+			return nil
+		}
+	}
+
+	remote, err := p.mirrorConfig.SourceCloneURL(st.repo)
+	if err != nil {
+		return err
+	}
+	lsCtx := git.WithEnv(ctx, p.mirrorConfig.SourceGitEnv(st.repo)...)
+	refs, head, err := git.LsRemoteHead(lsCtx, remote)
+	if err != nil {
+		return err
+	}
+	if branch, ok := strings.CutPrefix(head, "refs/heads/"); ok && st.repo.DefaultBranch == "" {
+		st.repo.DefaultBranch = branch
+	}
+
+	// Only handled updates are remembered, a failed one is found again on the next poll
+	if st.refs == nil {
+		// Without earlier refs nothing can be compared, make sure the mirror exists and is current
+		defaultRef := "refs/heads/" + st.repo.DefaultBranch
+		if sha, ok := refs[defaultRef]; ok {
+			if err := p.handle(st.repo, defaultRef, "", sha); err != nil {
+				st.failed = true
+				return err
+			}
+		}
+		st.refs, st.failed = refs, false
+		return nil
+	}
+	handled, err := p.dispatch(st.repo, st.refs, refs)
+	st.refs, st.failed = handled, err != nil
+	return err
+}
+
+// dispatch hands the difference between two sets of refs to the handler, the
+// default branch last so a mirror sync sees every other ref updated. It returns
+// before with the updates that were handled applied.
+func (p *Poller) dispatch(repo mirror.Repository, before, after map[string]string) (map[string]string, error) {
+	defaultRef := "refs/heads/" + repo.DefaultBranch
+	var updates [][3]string
+	for ref, sha := range after {
+		old, ok := before[ref]
+		switch {
+		case !ok:
+			updates = append(updates, [3]string{ref, git.ZeroSHA, sha})
+		case old != sha:
+			updates = append(updates, [3]string{ref, old, sha})
+		}
+	}
+	for ref, sha := range before {
+		if _, ok := after[ref]; !ok {
+			updates = append(updates, [3]string{ref, sha, git.ZeroSHA})
+		}
+	}
+
+	// Sorted for a stable order, with the default branch moved to the end
+	sort.Slice(updates, func(i, j int) bool {
+		if (updates[i][0] == defaultRef) != (updates[j][0] == defaultRef) {
+			return updates[j][0] == defaultRef
+		}
+		return updates[i][0] < updates[j][0]
+	})
+
+	handled := maps.Clone(before)
+	var errs []string
+	for _, u := range updates {
+		log.Printf("Polled %s of %s: %s", u[0], repo.Name, shortSHA(u[2]))
+		if err := p.handle(repo, u[0], u[1], u[2]); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if u[2] == git.ZeroSHA {
+			delete(handled, u[0])
+		} else {
+			handled[u[0]] = u[2]
+		}
+	}
+	if len(errs) > 0 {
+		return handled, fmt.Errorf("failed to handle ref updates: %s", strings.Join(errs, "; "))
+	}
+	return handled, nil
+}
+
+// forgeRepository holds the fields GitHub, Gitea and GitLab share or nearly share in their repository API
+type forgeRepository struct {
+	Name          string `json:"name"` // The display name on GitLab
+	Description   string `json:"description"`
+	Private       *bool  `json:"private"`    // GitHub and Gitea
+	Visibility    string `json:"visibility"` // GitLab
+	DefaultBranch string `json:"default_branch"`
+}
+
+// checkForge makes a conditional request for the repository on its forge and
+// refreshes the metadata when it changed. It reports whether anything changed.
+func (p *Poller) checkForge(ctx context.Context, st *state, provider, base string) (bool, error) {
+	apiURL := repositoryAPIURL(provider, base, st.repo.Path())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if st.etag != "" {
+		req.Header.Set("If-None-Match", st.etag)
+	}
+	if err := p.authorize(req, provider, st.repo); err != nil {
+		return false, err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return false, nil
+	case resp.StatusCode != http.StatusOK:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return false, fmt.Errorf("%s returned %s: %s", apiURL, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var meta forgeRepository
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return false, fmt.Errorf("failed to decode repository: %v", err)
+	}
+	st.etag = resp.Header.Get("ETag")
+	if meta.Name != "" && st.repo.Owner != "" {
+		st.repo.Name = mirror.MirrorName(st.repo.Owner, meta.Name)
+	}
+	st.repo.Description = meta.Description
+	st.repo.DefaultBranch = meta.DefaultBranch
+	if meta.Private != nil {
+		st.repo.Private = *meta.Private
+	} else if meta.Visibility != "" {
+		st.repo.Private = meta.Visibility != "public"
+	}
+	return true, nil
+}
+
+// authorize adds the source credential of repo to req the way its forge expects it
+func (p *Poller) authorize(req *http.Request, provider string, repo mirror.Repository) error {
+	cred, err := p.mirrorConfig.SourceCredential(repo)
+	if err != nil || cred.Password == "" {
+		return err
+	}
+	switch {
+	case provider == mirror.ProviderGitea && cred.Username != "":
+		req.SetBasicAuth(cred.Username, cred.Password)
+	case provider == mirror.ProviderGitea:
+		req.Header.Set("Authorization", "token "+cred.Password)
+	default:
+		req.Header.Set("Authorization", "Bearer "+cred.Password)
+	}
+	return nil
+}
+
+// repositoryAPIURL returns the API URL of the repository at path on a forge
+func repositoryAPIURL(provider, base, path string) string {
+	switch provider {
+	case mirror.ProviderGitLab:
+		return base + "/api/v4/projects/" + url.PathEscape(path)
+	case mirror.ProviderGitea:
+		return base + "/api/v1/repos/" + path
+	}
+	if mirror.IsGitHubEnterpriseURL(base) {
+		return base + "/api/v3/repos/" + path
+	}
+	return "https://api.github.com/repos/" + path
+}
+
+// repository describes a polled clone URL. Without forge metadata a repository
+// counts as private when a source credential is configured for it.
+func repository(config mirror.Config, cloneURL string) mirror.Repository {
+	repo := mirror.Repository{CloneURL: cloneURL}
+	path := repo.Path()
+	name := path
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		repo.Owner, name = path[:i], path[i+1:]
+		repo.Name = mirror.MirrorName(repo.Owner, name)
+	} else {
+		repo.Name = name
+	}
+
+	if cred, err := config.SourceCredential(repo); err == nil && cred.Password != "" {
+		repo.Private = true
+	}
+	return repo
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 12 {
+		return sha[:12]
+	}
+	return sha
+}
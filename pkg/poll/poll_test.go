@@ -0,0 +1,243 @@
+package poll
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"net/netip"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/git"
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+)
+
+// update is a ref update passed to the handler
+type update struct{ ref, before, after string }
+
+func TestDispatch(t *testing.T) {
+	before := map[string]string{
+		"refs/heads/main": "1111111111111111111111111111111111111111",
+		"refs/heads/dev":  "2222222222222222222222222222222222222222",
+		"refs/tags/old":   "3333333333333333333333333333333333333333",
+	}
+	after := map[string]string{
+		"refs/heads/main": "4444444444444444444444444444444444444444",
+		"refs/heads/dev":  "2222222222222222222222222222222222222222",
+		"refs/tags/new":   "5555555555555555555555555555555555555555",
+	}
+
+	var calls []update
+	p := New(mirror.Config{}, Config{}, func(repo mirror.Repository, ref, before, after string) error {
+		calls = append(calls, update{ref, before, after})
+		return nil
+	})
+
+	handled, err := p.dispatch(mirror.Repository{Name: "acme-app", DefaultBranch: "main"}, before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []update{
+		{"refs/tags/new", git.ZeroSHA, after["refs/tags/new"]},
+		{"refs/tags/old", before["refs/tags/old"], git.ZeroSHA},
+		{"refs/heads/main", before["refs/heads/main"], after["refs/heads/main"]},
+	}
+	if !slices.Equal(calls, want) {
+		t.Errorf("handled %v, want %v", calls, want)
+	}
+	if !maps.Equal(handled, after) {
+		t.Errorf("refs after dispatch = %v, want %v", handled, after)
+	}
+}
+
+func TestDispatchKeepsFailedUpdates(t *testing.T) {
+	before := map[string]string{
+		"refs/heads/main": "1111111111111111111111111111111111111111",
+		"refs/tags/old":   "3333333333333333333333333333333333333333",
+	}
+	after := map[string]string{
+		"refs/heads/main": "4444444444444444444444444444444444444444",
+	}
+
+	// The destination is down for the default branch
+	p := New(mirror.Config{}, Config{}, func(repo mirror.Repository, ref, before, after string) error {
+		if ref == "refs/heads/main" {
+			return errors.New("destination unavailable")
+		}
+		return nil
+	})
+
+	handled, err := p.dispatch(mirror.Repository{Name: "acme-app", DefaultBranch: "main"}, before, after)
+	if err == nil {
+		t.Fatal("dispatch() succeeded, want the handler error")
+	}
+	want := map[string]string{"refs/heads/main": before["refs/heads/main"]}
+	if !maps.Equal(handled, want) {
+		t.Errorf("refs after dispatch = %v, want the deletion applied and main unchanged: %v", handled, want)
+	}
+}
+
+// run runs git in dir with a fixed identity
+func run(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Gitcloner", "-c", "user.email=gitcloner@example.com"}, args...)...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+}
+
+// newForge serves acme/app over smart HTTP and its metadata from the API of
+// provider, which answers 304 to every request with the ETag it handed out.
+// It returns the server, a work tree whose origin is the repository and the
+// number of ref listings served.
+func newForge(t *testing.T, provider string) (*httptest.Server, string, *atomic.Int64) {
+	t.Helper()
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+	root := t.TempDir()
+	work := filepath.Join(t.TempDir(), "work")
+	run(t, "", "init", "--quiet", "--bare", "--initial-branch=main", filepath.Join(root, "acme", "app.git"))
+	run(t, "", "init", "--quiet", "--initial-branch=main", work)
+	run(t, work, "commit", "--quiet", "--allow-empty", "-m", "first")
+	run(t, work, "remote", "add", "origin", filepath.Join(root, "acme", "app.git"))
+	run(t, work, "push", "--quiet", "origin", "main")
+
+	backend := &cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+
+	apiPath := "/api/v3/repos/acme/app"
+	if provider == mirror.ProviderGitea {
+		apiPath = "/api/v1/repos/acme/app"
+	}
+
+	var listings atomic.Int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+apiPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		json.NewEncoder(w).Encode(map[string]any{"name": "app", "default_branch": "main", "private": false})
+	})
+	mux.HandleFunc("/acme/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/info/refs") {
+			listings.Add(1)
+		}
+		backend.ServeHTTP(w, r)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, work, &listings
+}
+
+func TestPollNotModified(t *testing.T) {
+	tests := []struct {
+		provider string
+		want     []int64 // Ref listings after each of four polls
+	}{
+		// GitHub changes the repository on every push, refs are only listed every third poll without a change
+		{mirror.ProviderGitHub, []int64{1, 1, 2, 2}},
+		// Other forges don't, so their refs are listed on every poll
+		{mirror.ProviderGitea, []int64{1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			server, _, listings := newForge(t, tt.provider)
+			config := mirror.Config{SourcePolicy: mirror.SourcePolicy{
+				AllowedSchemes:  []string{"http"},
+				AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+			}}
+			if tt.provider == mirror.ProviderGitea {
+				config.SourceGiteaURL = server.URL
+			} else {
+				config.SourceGitHubURL = server.URL
+			}
+
+			cloneURL := server.URL + "/acme/app.git"
+			p := New(config, Config{Repositories: []string{cloneURL}, FullCheckEvery: 3}, func(mirror.Repository, string, string, string) error {
+				return nil
+			})
+			for i, want := range tt.want {
+				if err := p.Poll(context.Background(), cloneURL); err != nil {
+					t.Fatal(err)
+				}
+				if got := listings.Load(); got != want {
+					t.Errorf("after poll %d: %d ref listings, want %d", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPollRetriesFailedUpdates(t *testing.T) {
+	server, work, listings := newForge(t, mirror.ProviderGitHub)
+	config := mirror.Config{
+		SourceGitHubURL: server.URL,
+		SourcePolicy: mirror.SourcePolicy{
+			AllowedSchemes:  []string{"http"},
+			AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+		},
+	}
+	cloneURL := server.URL + "/acme/app.git"
+
+	var handled []string
+	fail := false
+	p := New(config, Config{Repositories: []string{cloneURL}, FullCheckEvery: 100}, func(repo mirror.Repository, ref, before, after string) error {
+		if fail {
+			return fmt.Errorf("destination unavailable")
+		}
+		handled = append(handled, after)
+		return nil
+	})
+	ctx := context.Background()
+
+	if err := p.Poll(ctx, cloneURL); err != nil {
+		t.Fatal(err)
+	}
+
+	// A push the API does not report, found by the next full check that fails to handle it
+	run(t, work, "commit", "--quiet", "--allow-empty", "-m", "second")
+	run(t, work, "push", "--quiet", "origin", "main")
+	p.states[cloneURL].polls = 99
+	fail = true
+	if err := p.Poll(ctx, cloneURL); err == nil {
+		t.Fatal("Poll() succeeded, want the handler error")
+	}
+
+	// GitHub answers 304, the refs are still listed again and the update redelivered
+	fail = false
+	if err := p.Poll(ctx, cloneURL); err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 2 || handled[0] == handled[1] {
+		t.Errorf("handled %v, want the first commit and then the second", handled)
+	}
+	if listings.Load() != 3 {
+		t.Errorf("%d ref listings, want 3", listings.Load())
+	}
+
+	// Once handled, an unchanged repository is not listed again
+	if err := p.Poll(ctx, cloneURL); err != nil {
+		t.Fatal(err)
+	}
+	if listings.Load() != 3 {
+		t.Errorf("%d ref listings after the update was handled, want 3", listings.Load())
+	}
+}
@@ -0,0 +1,91 @@
+package poll
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// savedState is what the state file keeps of a repository. The ETag is left
+// out, so the first poll after a restart refreshes the metadata.
+type savedState struct {
+	DefaultBranch string            `json:"default_branch,omitempty"`
+	Refs          map[string]string `json:"refs"`
+}
+
+// load reads the refs seen before a restart from the state file, so changes
+// made while the server was down are compared against them
+func (p *Poller) load() error {
+	if p.config.StateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(p.config.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var saved map[string]savedState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("invalid state file %s: %v", p.config.StateFile, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for cloneURL, s := range saved {
+		// Repositories no longer polled are dropped with the next save
+		if s.Refs == nil || !slices.Contains(p.config.Repositories, cloneURL) {
+			continue
+		}
+		st := &state{repo: repository(p.mirrorConfig, cloneURL), refs: s.Refs}
+		st.repo.DefaultBranch = s.DefaultBranch
+		p.states[cloneURL] = st
+	}
+	return nil
+}
+
+// save writes the refs of every polled repository to the state file
+func (p *Poller) save() error {
+	if p.config.StateFile == "" {
+		return nil
+	}
+
+	p.mu.Lock()
+	saved := make(map[string]savedState, len(p.states))
+	for cloneURL, st := range p.states {
+		if st.refs != nil {
+			saved[cloneURL] = savedState{DefaultBranch: st.repo.DefaultBranch, Refs: st.refs}
+		}
+	}
+	p.mu.Unlock()
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated state behind
+	dir := filepath.Dir(p.config.StateFile)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p.config.StateFile)
+}
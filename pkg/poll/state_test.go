@@ -0,0 +1,47 @@
+package poll
+
+import (
+	"maps"
+	"path/filepath"
+	"testing"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+)
+
+func TestStateFile(t *testing.T) {
+	config := Config{
+		Repositories: []string{"https://git.example.com/acme/app.git"},
+		StateFile:    filepath.Join(t.TempDir(), "state", "poll.json"),
+	}
+	refs := map[string]string{
+		"refs/heads/main": "1111111111111111111111111111111111111111",
+		"refs/tags/v1":    "2222222222222222222222222222222222222222",
+	}
+
+	p := New(mirror.Config{}, config, nil)
+	p.states[config.Repositories[0]] = &state{repo: mirror.Repository{DefaultBranch: "main"}, refs: refs}
+	// Removed from the configuration, and left out when the state is loaded
+	p.states["https://git.example.com/acme/old.git"] = &state{refs: refs}
+	if err := p.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := New(mirror.Config{}, config, nil)
+	if err := restarted.load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(restarted.states) != 1 {
+		t.Fatalf("loaded %d repositories, want 1", len(restarted.states))
+	}
+	st := restarted.states[config.Repositories[0]]
+	if st == nil || !maps.Equal(st.refs, refs) || st.repo.DefaultBranch != "main" || st.repo.Name != "acme-app" {
+		t.Errorf("loaded state = %+v", st)
+	}
+}
+
+func TestStateFileMissing(t *testing.T) {
+	p := New(mirror.Config{}, Config{StateFile: filepath.Join(t.TempDir(), "poll.json")}, nil)
+	if err := p.load(); err != nil {
+		t.Errorf("load() without a state file = %v", err)
+	}
+}
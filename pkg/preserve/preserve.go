@@ -0,0 +1,436 @@
+package preserve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/git"
+	"github.com/janyksteenbeek/gitcloner/pkg/snapshot"
+)
+
+// Namespace is the ref prefix under which preserved refs are stored
+const Namespace = "refs/gitcloner/preserved/"
+
+const (
+	incomingNamespace = "refs/gitcloner/incoming/"
+	timestampFormat   = "20060102T150405.000000000Z"
+
+	// legacyTimestampFormat is how refs were named before they got sub-second precision
+	legacyTimestampFormat = "20060102T150405Z"
+)
+
+var (
+	// ErrObjectNotFound is returned when the previous value of a ref could not be fetched from any remote
+	ErrObjectNotFound = errors.New("previous ref value not found on any remote")
+
+	// ErrInvalidName is returned when a mirror name can't safely be used as a directory name
+	ErrInvalidName = errors.New("invalid mirror name")
+)
+
+// Config holds the configuration for preserving overwritten refs
+type Config struct {
+	Dir      string        // Work directory holding one bare repository per mirror that previous values are fetched into
+	Timeout  time.Duration // How long a sync waits for the previous value to be fetched, defaults to a minute
+	MaxAge   time.Duration // Preserved refs older than this are pruned, zero keeps them forever
+	MaxCount int           // Number of preserved refs kept per repository, zero keeps all of them
+
+	// Storage receives a side bundle of every preserved ref, encrypted with
+	// Encryption when it is configured
+	Storage    snapshot.Storage
+	Encryption snapshot.Encryption
+}
+
+// Preserver keeps the previous value of force-pushed and deleted refs before a mirror sync overwrites them
+type Preserver struct {
+	config  Config
+	locks   sync.Map
+	pending sync.WaitGroup
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// New creates a new Preserver
+func New(config Config) (*Preserver, error) {
+	if config.Dir == "" {
+		return nil, fmt.Errorf("preserve directory is required")
+	}
+	if config.Storage == nil {
+		return nil, fmt.Errorf("preserve storage is required")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = time.Minute
+	}
+	if err := os.MkdirAll(config.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create preserve directory: %v", err)
+	}
+	return &Preserver{config: config, last: make(map[string]time.Time)}, nil
+}
+
+// lock serialises work on the repository called name
+func (p *Preserver) lock(name string) func() {
+	mu, _ := p.locks.LoadOrStore(name, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// open locks the work repository of name, creating it when needed
+func (p *Preserver) open(ctx context.Context, name string) (string, func(), error) {
+	if !validName(name) {
+		return "", nil, fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+
+	unlock := p.lock(name)
+	dir := filepath.Join(p.config.Dir, name+".git")
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		if err := git.InitBare(ctx, dir); err != nil {
+			unlock()
+			return "", nil, err
+		}
+	}
+	return dir, unlock, nil
+}
+
+// validName reports whether name stays inside the work directory
+func validName(name string) bool {
+	return filepath.IsLocal(name) && !strings.ContainsAny(name, `/\`) && !strings.Contains(name, "..")
+}
+
+// Overwrites reports whether updating ref from before to after drops history,
+// which is the case when the ref is deleted or force-pushed. For sources that
+// don't flag forced pushes, both commits are fetched into the work repository
+// of name from remotes, listed as for Preserve, and their ancestry is compared.
+func (p *Preserver) Overwrites(ctx context.Context, name, ref, before, after string, remotes ...string) (bool, error) {
+	switch {
+	case before == "" || before == git.ZeroSHA:
+		return false, nil
+	case after == "" || after == git.ZeroSHA:
+		return true, nil
+	}
+
+	dir, unlock, err := p.open(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+
+	// The previous value comes first, so fetching the new one only transfers what was pushed
+	for _, sha := range []string{before, after} {
+		if err := fetchObject(ctx, dir, ref, sha, remotes); err != nil {
+			return false, err
+		}
+	}
+
+	ancestor, err := git.IsAncestor(ctx, dir, before, after)
+	if err != nil {
+		return false, fmt.Errorf("failed to compare %s with %s: %v", before, after, err)
+	}
+	return !ancestor, nil
+}
+
+// Preserve stores sha, the value ref had before it was overwritten, under
+// refs/gitcloner/preserved/<timestamp>/<ref> in a side bundle of the mirror
+// called name. The object is fetched from the first remote that has it, so
+// the destination mirror should be listed before the source. Only the fetch
+// holds up the caller, the bundle is written in the background. The name of
+// the preserved ref is returned.
+func (p *Preserver) Preserve(ctx context.Context, name, ref, sha string, remotes ...string) (string, error) {
+	if sha == "" || sha == git.ZeroSHA {
+		return "", nil
+	}
+
+	dir, unlock, err := p.open(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	fetchCtx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+	if err := fetchObject(fetchCtx, dir, ref, sha, remotes); err != nil {
+		return "", err
+	}
+
+	preserved := Namespace + p.timestamp(name) + "/" + strings.TrimPrefix(ref, "refs/")
+	if err := git.UpdateRef(ctx, dir, preserved, sha); err != nil {
+		return "", fmt.Errorf("failed to preserve %s: %v", ref, err)
+	}
+
+	p.pending.Add(1)
+	go func() {
+		defer p.pending.Done()
+		if err := p.upload(context.WithoutCancel(ctx), dir, name); err != nil {
+			log.Printf("Warning: Failed to store preserved refs of %s: %v", name, err)
+		}
+	}()
+
+	return preserved, nil
+}
+
+// Wait blocks until the side bundles that are being written have been stored
+func (p *Preserver) Wait() {
+	p.pending.Wait()
+}
+
+// timestamp returns the time to preserve a ref of name under. It is always
+// later than the previous one, so two preserves of the same ref never collide.
+func (p *Preserver) timestamp(name string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := time.Now().UTC()
+	if last := p.last[name]; !t.After(last) {
+		t = last.Add(time.Nanosecond)
+	}
+	p.last[name] = t
+	return t.Format(timestampFormat)
+}
+
+// fetchObject makes sure sha exists in dir, trying each remote in turn
+func fetchObject(ctx context.Context, dir, ref, sha string, remotes []string) error {
+	if git.HasObject(ctx, dir, sha) {
+		return nil
+	}
+
+	for _, remote := range remotes {
+		if remote == "" {
+			continue
+		}
+
+		// Fetching a single object is cheapest, but servers may refuse it
+		if err := git.FetchObject(ctx, dir, remote, sha); err == nil && git.HasObject(ctx, dir, sha) {
+			return nil
+		}
+
+		// Otherwise fetch just the ref, which on the mirror still points at the previous value
+		err := git.Fetch(ctx, dir, remote, "+"+ref+":"+incomingNamespace+strings.TrimPrefix(ref, "refs/"))
+		found := err == nil && git.HasObject(ctx, dir, sha)
+		if err := git.DeleteRefs(ctx, dir, incomingNamespace); err != nil {
+			return err
+		}
+		if found {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrObjectNotFound, sha)
+}
+
+// upload writes a side bundle of every preserved ref still in the work
+// repository of name, records it in the index and removes the local ref.
+// Refs that fail to upload are retried with the next preserve.
+func (p *Preserver) upload(ctx context.Context, dir, name string) error {
+	defer p.lock(name)()
+
+	refs, err := localRefs(ctx, dir)
+	if err != nil || len(refs) == 0 {
+		return err
+	}
+
+	index, err := LoadIndex(ctx, p.config.Storage, name)
+	if err != nil {
+		return err
+	}
+
+	var uploadErr error
+	var uploaded []string
+	for _, ref := range refs {
+		key, err := p.storeBundle(ctx, dir, name, ref.Name)
+		if err != nil {
+			uploadErr = fmt.Errorf("failed to store side bundle for %s: %v", ref.Name, err)
+			break
+		}
+		uploaded = append(uploaded, ref.Name)
+		ref.Key, ref.Encryption = key, p.config.Encryption.Scheme()
+		if ref.Encryption != "" {
+			// The ref names are only kept inside the encrypted bundle
+			ref.Name, ref.Original = "", ""
+		}
+		index.Refs = append(index.Refs, ref)
+	}
+	if len(uploaded) == 0 {
+		return uploadErr
+	}
+
+	sort.SliceStable(index.Refs, func(i, j int) bool {
+		return index.Refs[i].Time.Before(index.Refs[j].Time)
+	})
+	removed := p.prune(index)
+	if err := SaveIndex(ctx, p.config.Storage, name, index); err != nil {
+		return err
+	}
+
+	for _, ref := range removed {
+		if err := p.config.Storage.Delete(ctx, ref.Key); err != nil {
+			log.Printf("Warning: Failed to delete preserved bundle %s: %v", ref.Key, err)
+		}
+	}
+	for _, ref := range uploaded {
+		if err := git.DeleteRef(ctx, dir, ref); err != nil {
+			return err
+		}
+	}
+	if _, err := git.Run(ctx, dir, "gc", "--auto", "--quiet"); err != nil {
+		log.Printf("Warning: Failed to clean up preserve repository of %s: %v", name, err)
+	}
+	return uploadErr
+}
+
+// storeBundle writes a bundle of the preserved ref next to the snapshots and returns its key
+func (p *Preserver) storeBundle(ctx context.Context, dir, name, ref string) (string, error) {
+	tmpDir, err := os.MkdirTemp("", "gitcloner-preserve-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	file := filepath.Join(tmpDir, "preserved.bundle")
+	if err := git.CreateRefBundle(ctx, dir, file, ref); err != nil {
+		return "", err
+	}
+
+	scheme := p.config.Encryption.Scheme()
+	if scheme != "" {
+		if err := p.config.Encryption.EncryptFile(file, file+".enc"); err != nil {
+			return "", err
+		}
+		file += ".enc"
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	// Encrypted bundles are named by their timestamp alone, so the key doesn't give the ref away
+	base := strings.ReplaceAll(strings.TrimPrefix(ref, Namespace), "/", "_")
+	if scheme != "" {
+		base, _, _ = strings.Cut(strings.TrimPrefix(ref, Namespace), "/")
+	}
+	key := fmt.Sprintf("%s/preserved/%s.bundle%s", name, base, snapshot.Extension(scheme))
+	if err := p.config.Storage.Put(ctx, key, f, info.Size()); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// prune drops the refs that fall outside the retention settings from index and returns them
+func (p *Preserver) prune(index *Index) []Ref {
+	if p.config.MaxAge <= 0 && p.config.MaxCount <= 0 {
+		return nil
+	}
+
+	cutoff := time.Now().Add(-p.config.MaxAge)
+	var kept, removed []Ref
+	for i, ref := range index.Refs {
+		expired := p.config.MaxAge > 0 && ref.Time.Before(cutoff)
+		overflow := p.config.MaxCount > 0 && len(index.Refs)-i > p.config.MaxCount
+		if expired || overflow {
+			removed = append(removed, ref)
+		} else {
+			kept = append(kept, ref)
+		}
+	}
+	index.Refs = kept
+	return removed
+}
+
+// Ref is a preserved ref
+type Ref struct {
+	Name       string    `json:"name,omitempty"`     // Full name under the preserve namespace, left out when encrypted
+	Original   string    `json:"original,omitempty"` // Name of the ref that was overwritten, left out when encrypted
+	Time       time.Time `json:"time"`               // When the ref was preserved
+	SHA        string    `json:"sha"`
+	Key        string    `json:"key,omitempty"` // Storage key of the side bundle
+	Encryption string    `json:"encryption,omitempty"`
+}
+
+// Index lists the preserved refs of a mirror, oldest first
+type Index struct {
+	Refs []Ref `json:"refs"`
+}
+
+// LoadIndex reads the index of preserved refs of the mirror called name, which is empty when there is none yet
+func LoadIndex(ctx context.Context, storage snapshot.Storage, name string) (*Index, error) {
+	r, err := storage.Get(ctx, indexKey(name))
+	if errors.Is(err, snapshot.ErrNotFound) {
+		return &Index{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read preserve index: %v", err)
+	}
+	defer r.Close()
+
+	var index Index
+	if err := json.NewDecoder(r).Decode(&index); err != nil {
+		return nil, fmt.Errorf("failed to parse preserve index: %v", err)
+	}
+	return &index, nil
+}
+
+// SaveIndex writes the index of preserved refs of the mirror called name
+func SaveIndex(ctx context.Context, storage snapshot.Storage, name string, index *Index) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := storage.Put(ctx, indexKey(name), bytes.NewReader(data), int64(len(data))); err != nil {
+		return fmt.Errorf("failed to write preserve index: %v", err)
+	}
+	return nil
+}
+
+func indexKey(name string) string {
+	return name + "/preserved/index.json"
+}
+
+// localRefs returns the preserved refs in the work repository at dir that have not been uploaded yet, oldest first
+func localRefs(ctx context.Context, dir string) ([]Ref, error) {
+	all, err := git.Refs(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []Ref
+	for name, sha := range all {
+		rest, ok := strings.CutPrefix(name, Namespace)
+		if !ok {
+			continue
+		}
+		stamp, original, ok := strings.Cut(rest, "/")
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(timestampFormat, stamp)
+		if err != nil {
+			if t, err = time.Parse(legacyTimestampFormat, stamp); err != nil {
+				continue
+			}
+		}
+		refs = append(refs, Ref{Name: name, Original: "refs/" + original, Time: t, SHA: sha})
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Time.Before(refs[j].Time)
+	})
+	return refs, nil
+}
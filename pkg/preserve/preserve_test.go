@@ -0,0 +1,245 @@
+package preserve
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"github.com/janyksteenbeek/gitcloner/pkg/git"
+	"github.com/janyksteenbeek/gitcloner/pkg/snapshot"
+)
+
+// run runs git in dir with a fixed identity and returns its trimmed output
+func run(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Gitcloner", "-c", "user.email=gitcloner@example.com"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newPreserver returns a preserver storing its bundles in a temporary directory
+func newPreserver(t *testing.T, config Config) *Preserver {
+	t.Helper()
+	storage, err := snapshot.NewStorage(t.TempDir(), snapshot.S3Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Dir, config.Storage = t.TempDir(), storage
+	p, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// setup returns a source whose main went from first to second, and a rewrite
+// of second that does not contain it
+func setup(t *testing.T) (source, first, second, rewritten string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	source = filepath.Join(t.TempDir(), "source")
+	run(t, "", "init", "--quiet", "--initial-branch=main", source)
+	run(t, source, "commit", "--quiet", "--allow-empty", "-m", "first")
+	first = run(t, source, "rev-parse", "HEAD")
+	run(t, source, "commit", "--quiet", "--allow-empty", "-m", "second")
+	second = run(t, source, "rev-parse", "HEAD")
+
+	// The force-push keeps the old commit on another branch, like a mirror that has not synced yet
+	run(t, source, "branch", "old")
+	run(t, source, "reset", "--quiet", "--hard", first)
+	run(t, source, "commit", "--quiet", "--allow-empty", "-m", "second, rewritten")
+	rewritten = run(t, source, "rev-parse", "HEAD")
+	return source, first, second, rewritten
+}
+
+func TestOverwrites(t *testing.T) {
+	source, first, second, rewritten := setup(t)
+	p := newPreserver(t, Config{})
+
+	for _, tt := range []struct {
+		name          string
+		before, after string
+		want          bool
+	}{
+		{"fast-forward", first, second, false},
+		{"force-push", second, rewritten, true},
+		{"delete", second, git.ZeroSHA, true},
+		{"create", git.ZeroSHA, second, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.Overwrites(context.Background(), "acme-app", "refs/heads/main", tt.before, tt.after, source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Overwrites() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOverwritesMissingCommit(t *testing.T) {
+	_, first, second, _ := setup(t)
+	p := newPreserver(t, Config{})
+
+	// An ancestry that can't be checked is an error, so the caller preserves to be safe
+	empty := filepath.Join(t.TempDir(), "empty.git")
+	run(t, "", "init", "--quiet", "--bare", empty)
+	if _, err := p.Overwrites(context.Background(), "acme-app", "refs/heads/main", first, second, empty); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Overwrites() error = %v, want ErrObjectNotFound", err)
+	}
+}
+
+func TestFetchObjectFallback(t *testing.T) {
+	source, _, second, _ := setup(t)
+	ctx := context.Background()
+
+	// The mirror has synced already and lost the commit, the source still has it
+	mirror := filepath.Join(t.TempDir(), "mirror.git")
+	run(t, "", "clone", "--quiet", "--bare", "--no-local", "--single-branch", "--branch", "main", source, mirror)
+	missing := filepath.Join(t.TempDir(), "missing.git")
+
+	dir := filepath.Join(t.TempDir(), "work.git")
+	if err := git.InitBare(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fetchObject(ctx, dir, "refs/heads/main", second, []string{"", missing, mirror}); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("fetchObject() without the source = %v, want ErrObjectNotFound", err)
+	}
+	if err := fetchObject(ctx, dir, "refs/heads/main", second, []string{"", missing, mirror, source}); err != nil {
+		t.Fatal(err)
+	}
+	if !git.HasObject(ctx, dir, second) {
+		t.Error("commit not fetched from the source")
+	}
+	if refs, err := git.Run(ctx, dir, "for-each-ref", incomingNamespace); err != nil || len(refs) > 0 {
+		t.Errorf("incoming refs left behind: %q, %v", refs, err)
+	}
+}
+
+func TestPreserve(t *testing.T) {
+	source, _, second, _ := setup(t)
+	p := newPreserver(t, Config{})
+	ctx := context.Background()
+
+	preserved, err := p.Preserve(ctx, "acme-app", "refs/heads/main", second, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(preserved, Namespace) || !strings.HasSuffix(preserved, "/heads/main") {
+		t.Errorf("preserved as %s, want a ref under %s", preserved, Namespace)
+	}
+	p.Wait()
+
+	index, err := LoadIndex(ctx, p.config.Storage, "acme-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Refs) != 1 {
+		t.Fatalf("index = %+v, want the preserved ref", index)
+	}
+	ref := index.Refs[0]
+	if ref.Name != preserved || ref.Original != "refs/heads/main" || ref.SHA != second {
+		t.Errorf("index ref = %+v, want %s of refs/heads/main at %s", ref, preserved, second)
+	}
+	r, err := p.config.Storage.Get(ctx, ref.Key)
+	if err != nil {
+		t.Fatalf("side bundle %s: %v", ref.Key, err)
+	}
+	r.Close()
+
+	if _, err := p.Preserve(ctx, "../acme-app", "refs/heads/main", second, source); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Preserve() outside the work directory = %v, want ErrInvalidName", err)
+	}
+}
+
+func TestPreserveEncrypted(t *testing.T) {
+	source, _, second, _ := setup(t)
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newPreserver(t, Config{Encryption: snapshot.Encryption{AgeRecipients: []string{identity.Recipient().String()}}})
+	ctx := context.Background()
+
+	if _, err := p.Preserve(ctx, "acme-app", "refs/heads/fix-cve-2024-1234", second, source); err != nil {
+		t.Fatal(err)
+	}
+	p.Wait()
+
+	// The index and the bundle key are readable without the identity, so they must not name the ref
+	r, err := p.config.Storage.Get(ctx, indexKey("acme-app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "cve") {
+		t.Errorf("index names the preserved ref:\n%s", data)
+	}
+
+	index, err := LoadIndex(ctx, p.config.Storage, "acme-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Refs) != 1 || index.Refs[0].SHA != second || !strings.HasSuffix(index.Refs[0].Key, ".bundle.age") {
+		t.Errorf("index = %+v, want one encrypted bundle of %s", index, second)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Now()
+	refs := func() []Ref {
+		return []Ref{
+			{Name: "four days", Time: now.Add(-96 * time.Hour)},
+			{Name: "three days", Time: now.Add(-72 * time.Hour)},
+			{Name: "one day", Time: now.Add(-24 * time.Hour)},
+			{Name: "one hour", Time: now.Add(-time.Hour)},
+		}
+	}
+
+	for _, tt := range []struct {
+		name     string
+		maxAge   time.Duration
+		maxCount int
+		want     []string
+	}{
+		{"keep everything", 0, 0, []string{"four days", "three days", "one day", "one hour"}},
+		{"max age", 48 * time.Hour, 0, []string{"one day", "one hour"}},
+		{"max count", 0, 3, []string{"three days", "one day", "one hour"}},
+		{"both", 80 * time.Hour, 1, []string{"one hour"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Preserver{config: Config{MaxAge: tt.maxAge, MaxCount: tt.maxCount}}
+			index := &Index{Refs: refs()}
+			removed := p.prune(index)
+
+			var kept []string
+			for _, ref := range index.Refs {
+				kept = append(kept, ref.Name)
+			}
+			if strings.Join(kept, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("kept %v, want %v", kept, tt.want)
+			}
+			if len(removed)+len(kept) != 4 {
+				t.Errorf("removed %d and kept %d of 4 refs", len(removed), len(kept))
+			}
+		})
+	}
+}
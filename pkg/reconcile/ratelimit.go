@@ -0,0 +1,48 @@
+package reconcile
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v60/github"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// defaultRetryAfter is how long to wait when a rate limited answer doesn't say
+const defaultRetryAfter = time.Minute
+
+// retryAfter reports whether err means an API is rate limited, and how long
+// it asks to wait before the next request
+func retryAfter(err error) (time.Duration, bool) {
+	var rateErr *github.RateLimitError
+	if errors.As(err, &rateErr) {
+		return time.Until(rateErr.Rate.Reset.Time), true
+	}
+
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		if abuseErr.RetryAfter != nil {
+			return *abuseErr.RetryAfter, true
+		}
+		return defaultRetryAfter, true
+	}
+
+	var gitlabErr *gitlab.ErrorResponse
+	if errors.As(err, &gitlabErr) && gitlabErr.Response != nil && gitlabErr.Response.StatusCode == http.StatusTooManyRequests {
+		return headerRetryAfter(gitlabErr.Response.Header), true
+	}
+	return 0, false
+}
+
+// headerRetryAfter reads the wait from the Retry-After or RateLimit-Reset header
+func headerRetryAfter(header http.Header) time.Duration {
+	if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if reset, err := strconv.ParseInt(header.Get("RateLimit-Reset"), 10, 64); err == nil {
+		return time.Until(time.Unix(reset, 0))
+	}
+	return defaultRetryAfter
+}
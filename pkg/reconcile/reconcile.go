@@ -0,0 +1,325 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/importer"
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/source"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrRateLimited is returned when a run stops early because an API is rate limited
+var ErrRateLimited = errors.New("rate limited")
+
+// Actions taken on a mirror during a run
+const (
+	ActionCreated  = "created"
+	ActionUpdated  = "updated"
+	ActionFailed   = "failed"
+	ActionConflict = "conflict" // A repository that is not a mirror of the source has the name of the mirror
+)
+
+// maxAttempts is how often a rate limited request is tried before the run stops
+const maxAttempts = 3
+
+// Source is an owner on a source forge whose repositories should all be mirrored
+type Source struct {
+	Platform string // github, gitlab or gitea, optionally with @https://host
+	Target   importer.Target
+}
+
+func (s Source) String() string {
+	return s.Platform + " " + s.Target.String()
+}
+
+// ParseSource parses "platform kind:owner", e.g. "gitlab@https://git.corp group:platform"
+func ParseSource(spec string) (Source, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 2 {
+		return Source{}, fmt.Errorf("%w %q, use 'platform org:name'", importer.ErrInvalidTarget, spec)
+	}
+	target, err := importer.ParseTarget(fields[1])
+	if err != nil {
+		return Source{}, err
+	}
+	return Source{Platform: fields[0], Target: target}, nil
+}
+
+// Config holds the configuration of the reconciler
+type Config struct {
+	Sources  []Source
+	Interval time.Duration // Defaults to 6 hours
+	Rate     float64       // API requests per second to the source and destination, defaults to 1
+	Options  source.ListOptions
+}
+
+// Change is something the reconciler did to a mirror
+type Change struct {
+	Name   string
+	Source string
+	Action string
+	Reason string
+}
+
+// Report lists what a run changed
+type Report struct {
+	Changes   []Change
+	Unchanged int
+	Skipped   int // Repositories rejected by the source policy
+}
+
+// Reconciler periodically compares the repositories of the configured sources
+// with the mirrors on the destination, creating missing mirrors and updating
+// drifted metadata. It catches up on webhooks that were never delivered.
+type Reconciler struct {
+	config       Config
+	mirrorConfig mirror.Config
+	limiter      *rate.Limiter
+}
+
+// New creates a reconciler for the mirrors of the configured destination
+func New(mirrorConfig mirror.Config, config Config) *Reconciler {
+	if config.Interval <= 0 {
+		config.Interval = 6 * time.Hour
+	}
+	if config.Rate <= 0 {
+		config.Rate = 1
+	}
+	return &Reconciler{
+		config:       config,
+		mirrorConfig: mirrorConfig,
+		limiter:      rate.NewLimiter(rate.Limit(config.Rate), 1),
+	}
+}
+
+// Run reconciles once per interval until ctx is cancelled
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := r.Reconcile(ctx)
+		if err != nil {
+			log.Printf("Warning: Reconciliation failed: %v", err)
+		}
+		r.log(report)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile compares every source with the destination inventory once. The
+// report holds what was changed before an error stopped the run.
+func (r *Reconciler) Reconcile(ctx context.Context) (Report, error) {
+	var report Report
+
+	service, err := mirror.NewMirrorService(r.mirrorConfig)
+	if err != nil {
+		return report, fmt.Errorf("failed to create mirror service: %v", err)
+	}
+	var mirrors []mirror.Repository
+	err = r.call(ctx, func() error {
+		mirrors, err = service.ListMirrors()
+		return err
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to list mirrors: %w", err)
+	}
+	inventory := make(map[string]mirror.Repository, len(mirrors))
+	for _, m := range mirrors {
+		inventory[strings.ToLower(m.Name)] = m
+	}
+
+	for _, s := range r.config.Sources {
+		if err := r.reconcileSource(ctx, s, inventory, &report); err != nil {
+			if errors.Is(err, ErrRateLimited) || ctx.Err() != nil {
+				return report, err
+			}
+			log.Printf("Warning: Failed to reconcile %s: %v", s, err)
+		}
+	}
+	return report, nil
+}
+
+func (r *Reconciler) reconcileSource(ctx context.Context, s Source, inventory map[string]mirror.Repository, report *Report) error {
+	src, config, err := source.ForPlatform(r.mirrorConfig, s.Platform)
+	if err != nil {
+		return err
+	}
+	// Inline instances change the config, mirrors created for them need to know their provider
+	service, err := mirror.NewMirrorService(config)
+	if err != nil {
+		return err
+	}
+
+	opts := r.config.Options
+	opts.Limiter = r.limiter
+	var repos []mirror.Repository
+	err = r.call(ctx, func() error {
+		repos, err = src.ListRepositories(ctx, s.Target.Kind, s.Target.Owner, opts)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, repo := range repos {
+		if err := r.reconcileRepository(ctx, config, service, src, repo, inventory, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileRepository creates or updates the mirror of one source repository.
+// A mirror with the same name that pulls from another source is a conflict and
+// left alone, as two sources can map to one mirror name. Only a rate limit
+// error is returned, other failures are recorded in the report.
+func (r *Reconciler) reconcileRepository(ctx context.Context, config mirror.Config, service mirror.MirrorService, src source.Source, repo mirror.Repository, inventory map[string]mirror.Repository, report *Report) error {
+	sourcePath := repo.Path()
+	repo.Name = mirror.MirrorName(repo.Owner, repo.Name)
+
+	existing, exists := inventory[strings.ToLower(repo.Name)]
+	otherSource := exists && !mirror.SameSource(existing.SourceURL, repo.CloneURL)
+	var fields []string
+	if exists && !otherSource {
+		if fields = drift(config.Type, existing, repo); len(fields) == 0 {
+			report.Unchanged++
+			return nil
+		}
+	}
+	if err := config.SourcePolicy.Check(ctx, repo.CloneURL); err != nil {
+		report.Skipped++
+		return nil
+	}
+
+	var err error
+	change := Change{Name: repo.Name, Source: sourcePath}
+	switch {
+	case otherSource:
+		change.Action, change.Reason = ActionConflict, "the mirror with this name pulls from another source"
+	case exists:
+		change.Action, change.Reason = ActionUpdated, strings.Join(fields, ", ")
+		err = r.call(ctx, func() error { return service.UpdateRepository(repo) })
+	default:
+		change.Action, err = r.create(ctx, service, src, repo)
+		if change.Action == ActionConflict {
+			change.Reason = "a repository that is not a mirror has this name"
+		}
+	}
+	if err != nil {
+		change.Action, change.Reason = ActionFailed, err.Error()
+	}
+	if change.Action == "" {
+		// Mirrored after the inventory was listed
+		report.Unchanged++
+		return nil
+	}
+	report.Changes = append(report.Changes, change)
+	if change.Reason != "" {
+		log.Printf("Reconcile: %s %s from %s: %s", change.Action, change.Name, change.Source, change.Reason)
+	} else {
+		log.Printf("Reconcile: %s %s from %s", change.Action, change.Name, change.Source)
+	}
+
+	if errors.Is(err, ErrRateLimited) {
+		return err
+	}
+	if err == nil && change.Action != ActionConflict {
+		// Later sources with the same mirror name must see which source it pulls from
+		repo.SourceURL = repo.CloneURL
+		inventory[strings.ToLower(repo.Name)] = repo
+	}
+	return nil
+}
+
+// create creates the missing mirror of repo. A repository that already has
+// its name is left alone, as mirrors only list mirrors: the action is
+// ActionConflict when it isn't a mirror, and empty when it is.
+func (r *Reconciler) create(ctx context.Context, service mirror.MirrorService, src source.Source, repo mirror.Repository) (string, error) {
+	var exists, isMirror bool
+	err := r.call(ctx, func() (err error) {
+		exists, isMirror, _, err = service.CheckRepository(repo)
+		return err
+	})
+	switch {
+	case err != nil:
+		return ActionFailed, err
+	case exists && isMirror:
+		return "", nil
+	case exists:
+		return ActionConflict, nil
+	}
+
+	repo = source.WithTopics(ctx, src, repo)
+	return ActionCreated, r.call(ctx, func() error { return service.CreateMirror(repo) })
+}
+
+// drift returns the metadata of a mirror that differs from its source. Empty
+// default branches and homepages on the source are not compared, and GitLab
+// destinations don't store a homepage.
+func drift(destination string, existing, repo mirror.Repository) []string {
+	var fields []string
+	if existing.Description != repo.Description {
+		fields = append(fields, "description")
+	}
+	if existing.Private != repo.Private {
+		fields = append(fields, "visibility")
+	}
+	if repo.DefaultBranch != "" && existing.DefaultBranch != repo.DefaultBranch {
+		fields = append(fields, "default branch")
+	}
+	if repo.Homepage != "" && destination != "gitlab" && existing.Homepage != repo.Homepage {
+		fields = append(fields, "homepage")
+	}
+	return fields
+}
+
+// call makes an API request once the limiter allows it. When the API answers
+// that it is rate limited, the request is retried after the time it asks for.
+// A wait longer than the interval stops the run, the next one picks up the rest.
+func (r *Reconciler) call(ctx context.Context, request func() error) error {
+	for attempt := 1; ; attempt++ {
+		if err := r.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		err := request()
+		wait, limited := retryAfter(err)
+		if !limited {
+			return err
+		}
+		if attempt == maxAttempts || wait > r.config.Interval {
+			return fmt.Errorf("%w: %v", ErrRateLimited, err)
+		}
+
+		log.Printf("Reconcile: rate limited, retrying in %s", wait.Round(time.Second))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// log writes a summary of a run
+func (r *Reconciler) log(report Report) {
+	counts := make(map[string]int)
+	for _, change := range report.Changes {
+		counts[change.Action]++
+	}
+	log.Printf("Reconciled %d sources: %d created, %d updated, %d failed, %d conflicting, %d unchanged, %d skipped by the source policy",
+		len(r.config.Sources), counts[ActionCreated], counts[ActionUpdated], counts[ActionFailed], counts[ActionConflict], report.Unchanged, report.Skipped)
+}
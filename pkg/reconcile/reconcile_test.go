@@ -0,0 +1,173 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v60/github"
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestDrift(t *testing.T) {
+	existing := mirror.Repository{Description: "API", Private: true, DefaultBranch: "main", Homepage: "https://acme.example"}
+
+	tests := []struct {
+		name        string
+		destination string
+		repo        mirror.Repository
+		want        []string
+	}{
+		{"unchanged", "gitea", existing, nil},
+		{"description", "gitea", mirror.Repository{Description: "The API", Private: true}, []string{"description"}},
+		{"visibility and branch", "github", mirror.Repository{Description: "API", DefaultBranch: "trunk"}, []string{"visibility", "default branch"}},
+		{"homepage", "gitea", mirror.Repository{Description: "API", Private: true, Homepage: "https://api.example"}, []string{"homepage"}},
+		{"homepage on gitlab", "gitlab", mirror.Repository{Description: "API", Private: true, Homepage: "https://api.example"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := drift(tt.destination, existing, tt.repo); !slices.Equal(got, tt.want) {
+				t.Errorf("drift() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	abuseWait := 30 * time.Second
+	gitlabResponse := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"12"}}}
+
+	tests := []struct {
+		name    string
+		err     error
+		want    time.Duration
+		limited bool
+	}{
+		{"primary rate limit", fmt.Errorf("failed to list repositories: %w", &github.RateLimitError{
+			Rate: github.Rate{Reset: github.Timestamp{Time: time.Now().Add(time.Hour)}},
+		}), time.Hour, true},
+		{"secondary rate limit", fmt.Errorf("%w: %w", mirror.ErrMirrorCreationFailed, &github.AbuseRateLimitError{RetryAfter: &abuseWait}), abuseWait, true},
+		{"gitlab", fmt.Errorf("failed to list projects: %w", &gitlab.ErrorResponse{Response: gitlabResponse}), 12 * time.Second, true},
+		{"repository named 429", errors.New("failed to get repository acme-429: 404 Not Found"), 0, false},
+		{"no error", nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, limited := retryAfter(tt.err)
+			if limited != tt.limited || (got-tt.want).Abs() > time.Second {
+				t.Errorf("retryAfter() = %s, %v, want %s, %v", got, limited, tt.want, tt.limited)
+			}
+		})
+	}
+}
+
+func TestCallRetriesRateLimited(t *testing.T) {
+	r := New(mirror.Config{}, Config{Rate: 1000})
+	wait := time.Millisecond
+
+	calls := 0
+	err := r.call(context.Background(), func() error {
+		if calls++; calls == 1 {
+			return &github.AbuseRateLimitError{RetryAfter: &wait}
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("call() = %v after %d calls, want success after 2", err, calls)
+	}
+
+	// A reset after the next run stops this one
+	err = r.call(context.Background(), func() error {
+		return &github.RateLimitError{Rate: github.Rate{Reset: github.Timestamp{Time: time.Now().Add(12 * time.Hour)}}}
+	})
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("call() = %v, want ErrRateLimited", err)
+	}
+}
+
+// fakeService is a destination holding repositories by name
+type fakeService struct {
+	mirror.MirrorService
+	repos   map[string]bool // Name to whether it is a mirror
+	created []string
+	updated []string
+}
+
+func (f *fakeService) CheckRepository(repo mirror.Repository) (bool, bool, bool, error) {
+	isMirror, exists := f.repos[repo.Name]
+	return exists, isMirror, false, nil
+}
+
+func (f *fakeService) CreateMirror(repo mirror.Repository) error {
+	f.created = append(f.created, repo.Name)
+	return nil
+}
+
+func (f *fakeService) UpdateRepository(repo mirror.Repository) error {
+	f.updated = append(f.updated, repo.Name)
+	return nil
+}
+
+func TestReconcileRepositoryOtherSource(t *testing.T) {
+	r := New(mirror.Config{}, Config{Rate: 1000})
+	service := &fakeService{repos: map[string]bool{}}
+	inventory := map[string]mirror.Repository{
+		"acme-api": {Name: "acme-api", Private: true, SourceURL: "https://203.0.113.10/acme/api.git"},
+	}
+	var report Report
+
+	repos := []mirror.Repository{
+		// Same name on another forge, public, must not make the private mirror public
+		{Name: "api", Owner: "acme", CloneURL: "https://203.0.113.20/acme/api.git"},
+		// The source of the mirror itself with a new description
+		{Name: "api", Owner: "acme", Private: true, Description: "The API", CloneURL: "https://203.0.113.10/acme/api.git"},
+		// Two subgroups with a project of the same name, the first one is created
+		{Name: "web", Owner: "acme/a", CloneURL: "https://203.0.113.10/acme/a/web.git"},
+		{Name: "web", Owner: "acme/b", CloneURL: "https://203.0.113.10/acme/b/web.git"},
+	}
+	for _, repo := range repos {
+		if err := r.reconcileRepository(context.Background(), mirror.Config{}, service, nil, repo, inventory, &report); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var actions []string
+	for _, change := range report.Changes {
+		actions = append(actions, change.Source+" "+change.Action)
+	}
+	want := []string{"acme/api conflict", "acme/api updated", "acme/a/web created", "acme/b/web conflict"}
+	if !slices.Equal(actions, want) {
+		t.Errorf("changes = %v, want %v", actions, want)
+	}
+	if !slices.Equal(service.updated, []string{"acme-api"}) || !slices.Equal(service.created, []string{"acme-web"}) {
+		t.Errorf("updated %v and created %v, want one update of acme-api and one creation of acme-web", service.updated, service.created)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	r := New(mirror.Config{}, Config{Rate: 1000})
+	service := &fakeService{repos: map[string]bool{"acme-app": false, "acme-api": true}}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"acme-app", ActionConflict},
+		{"acme-api", ""},
+		{"acme-web", ActionCreated},
+	}
+	for _, tt := range tests {
+		action, err := r.create(context.Background(), service, nil, mirror.Repository{Name: tt.name})
+		if err != nil || action != tt.want {
+			t.Errorf("create(%s) = %q, %v, want %q", tt.name, action, err, tt.want)
+		}
+	}
+	if !slices.Equal(service.created, []string{"acme-web"}) {
+		t.Errorf("created %v, want only acme-web", service.created)
+	}
+}
@@ -0,0 +1,119 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by a Provider when a secret does not exist
+var ErrNotFound = errors.New("secret not found")
+
+// Provider resolves secrets by key. What a key means depends on the provider.
+// Resolved secrets are registered for redaction.
+type Provider interface {
+	Get(ctx context.Context, key string) (string, error)
+}
+
+// Env resolves a key as the name of an environment variable
+type Env struct{}
+
+// Get returns the value of the environment variable key
+func (Env) Get(ctx context.Context, key string) (string, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return "", fmt.Errorf("%w: environment variable %s is empty", ErrNotFound, key)
+	}
+	Register(value)
+	return value, nil
+}
+
+// File resolves a key as the path of a file holding the secret, such as a mounted Kubernetes secret
+type File struct{}
+
+// Get returns the contents of the file at key without the trailing newline
+func (File) Get(ctx context.Context, key string) (string, error) {
+	data, err := os.ReadFile(key)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s does not exist", ErrNotFound, key)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %v", err)
+	}
+	value := strings.TrimRight(string(data), "\r\n")
+	Register(value)
+	return value, nil
+}
+
+// Value holds a secret that may change while the process runs. A nil Value is empty.
+type Value struct {
+	mu    sync.RWMutex
+	value string
+}
+
+// NewValue returns a Value holding value
+func NewValue(value string) *Value {
+	v := &Value{}
+	v.Set(value)
+	return v
+}
+
+// Get returns the current secret
+func (v *Value) Get() string {
+	if v == nil {
+		return ""
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.value
+}
+
+// Set replaces the secret and registers it for redaction
+func (v *Value) Set(value string) {
+	Register(value)
+	v.mu.Lock()
+	v.value = value
+	v.mu.Unlock()
+}
+
+// Watch resolves key from provider and keeps the returned Value up to date by
+// resolving it again every interval until ctx is cancelled. A zero interval
+// resolves the secret once.
+func Watch(ctx context.Context, provider Provider, key string, interval time.Duration) (*Value, error) {
+	value, err := provider.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	v := NewValue(value)
+
+	if interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					value, err := provider.Get(ctx, key)
+					if err != nil {
+						log.Printf("Warning: Failed to refresh secret %s: %v", key, err)
+						continue
+					}
+					if value != v.Get() {
+						v.Set(value)
+						log.Printf("Reloaded secret %s", key)
+					}
+				}
+			}
+		}()
+	}
+
+	return v, nil
+}
@@ -0,0 +1,83 @@
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultVaultField is the field read from a Vault secret when the key does not name one
+const DefaultVaultField = "value"
+
+// Vault resolves secrets from a HashiCorp Vault KV version 2 engine. Keys are
+// paths within the engine with an optional field, e.g. gitcloner/gitea#token.
+type Vault struct {
+	Address string // e.g. https://vault.example.com:8200
+	Token   string
+	Mount   string // Mount path of the KV engine, defaults to secret
+	Client  *http.Client
+}
+
+// NewVault creates a Vault provider and registers its token for redaction
+func NewVault(address, token, mount string) (*Vault, error) {
+	if address == "" || token == "" {
+		return nil, fmt.Errorf("Vault address and token are required")
+	}
+	if mount == "" {
+		mount = "secret"
+	}
+	Register(token)
+	return &Vault{
+		Address: strings.TrimSuffix(address, "/"),
+		Token:   token,
+		Mount:   strings.Trim(mount, "/"),
+		Client:  http.DefaultClient,
+	}, nil
+}
+
+// Get reads the field of key from Vault
+func (v *Vault) Get(ctx context.Context, key string) (string, error) {
+	path, field, ok := strings.Cut(key, "#")
+	if !ok || field == "" {
+		field = DefaultVaultField
+	}
+
+	endpoint := v.Address + "/v1/" + v.Mount + "/data/" + (&url.URL{Path: strings.Trim(path, "/")}).EscapedPath()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid Vault request: %v", err)
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s from Vault: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", fmt.Errorf("%w: %s in Vault", ErrNotFound, path)
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("failed to read %s from Vault: %s", path, resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid Vault response for %s: %v", path, err)
+	}
+
+	value, ok := body.Data.Data[field].(string)
+	if !ok || value == "" {
+		return "", fmt.Errorf("%w: field %s of %s in Vault", ErrNotFound, field, path)
+	}
+	Register(value)
+	return value, nil
+}
@@ -0,0 +1,94 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newVaultServer returns a stand-in for a Vault KV version 2 engine mounted at kv
+func newVaultServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv/data/gitcloner/gitea":
+			w.Write([]byte(`{"data":{"data":{"value":"gitea-token","webhook":"webhook-secret"}}}`))
+		case "/v1/kv/data/gitcloner/broken":
+			w.Write([]byte(`{"data":`))
+		default:
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestVaultGet(t *testing.T) {
+	server := newVaultServer(t)
+	vault, err := NewVault(server.URL+"/", "vault-token", "/kv/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key     string
+		want    string
+		wantErr error
+	}{
+		{key: "gitcloner/gitea", want: "gitea-token"},
+		{key: "/gitcloner/gitea#webhook", want: "webhook-secret"},
+		{key: "gitcloner/gitea#", want: "gitea-token"},
+		{key: "gitcloner/gitea#missing", wantErr: ErrNotFound},
+		{key: "gitcloner/missing", wantErr: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			got, err := vault.Get(context.Background(), tt.key)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Get() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Get() = %q, want %q", got, tt.want)
+			}
+			if Redact(got) != Redacted {
+				t.Errorf("%q is not registered for redaction", got)
+			}
+		})
+	}
+}
+
+func TestVaultErrors(t *testing.T) {
+	server := newVaultServer(t)
+
+	vault, err := NewVault(server.URL, "wrong-token", "kv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vault.Get(context.Background(), "gitcloner/gitea"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() with a wrong token error = %v, want a permission error", err)
+	}
+
+	vault, err = NewVault(server.URL, "vault-token", "kv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vault.Get(context.Background(), "gitcloner/broken"); err == nil {
+		t.Error("Get() of an invalid response succeeded")
+	}
+
+	if _, err := NewVault(server.URL, "", "kv"); err == nil {
+		t.Error("NewVault() without a token succeeded")
+	}
+}
@@ -0,0 +1,248 @@
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// Supported encryption schemes
+const (
+	EncryptionAge = "age"
+	EncryptionPGP = "pgp"
+)
+
+// ErrNoIdentity is returned when an encrypted snapshot is read without a key to decrypt it
+var ErrNoIdentity = errors.New("snapshot is encrypted but no identity was configured")
+
+// Encryption configures how snapshot bundles are encrypted. Either age
+// recipients or an OpenPGP keyring can be set, not both. The identity file is
+// only needed to decrypt, so a server that only writes snapshots never has to
+// hold a private key.
+type Encryption struct {
+	AgeRecipients []string // age X25519 or SSH public keys
+	PGPKeyring    string   // Path to an armored OpenPGP public keyring
+	IdentityFile  string   // Path to an age identity file or an armored OpenPGP private key
+	Passphrase    string   // Unlocks a passphrase-protected OpenPGP private key
+}
+
+// Scheme returns the configured encryption scheme, or an empty string when bundles are stored in plain text
+func (e Encryption) Scheme() string {
+	switch {
+	case len(e.AgeRecipients) > 0:
+		return EncryptionAge
+	case e.PGPKeyring != "":
+		return EncryptionPGP
+	}
+	return ""
+}
+
+// Validate checks the configuration without reporting any key material in its errors
+func (e Encryption) Validate() error {
+	if len(e.AgeRecipients) > 0 && e.PGPKeyring != "" {
+		return fmt.Errorf("%w: age recipients and an OpenPGP keyring cannot be used together", ErrInvalidConfig)
+	}
+	_, err := e.encrypter()
+	return err
+}
+
+// Extension returns the file extension for bundles written with scheme
+func Extension(scheme string) string {
+	switch scheme {
+	case EncryptionAge:
+		return ".age"
+	case EncryptionPGP:
+		return ".gpg"
+	}
+	return ""
+}
+
+type encrypter func(w io.Writer) (io.WriteCloser, error)
+
+func (e Encryption) encrypter() (encrypter, error) {
+	switch e.Scheme() {
+	case EncryptionAge:
+		recipients, err := parseAgeRecipients(e.AgeRecipients)
+		if err != nil {
+			return nil, err
+		}
+		return func(w io.Writer) (io.WriteCloser, error) {
+			return age.Encrypt(w, recipients...)
+		}, nil
+	case EncryptionPGP:
+		keyring, err := readPGPKeyring(e.PGPKeyring)
+		if err != nil {
+			return nil, err
+		}
+		return func(w io.Writer) (io.WriteCloser, error) {
+			return openpgp.Encrypt(w, keyring, nil, &openpgp.FileHints{IsBinary: true}, nil)
+		}, nil
+	}
+	return nil, nil
+}
+
+// EncryptFile encrypts src into dst using the configured scheme
+func (e Encryption) EncryptFile(src, dst string) error {
+	encrypt, err := e.encrypter()
+	if err != nil {
+		return err
+	}
+	if encrypt == nil {
+		return fmt.Errorf("%w: no encryption recipients configured", ErrInvalidConfig)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w, err := encrypt(out)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt snapshot: %v", err)
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		return fmt.Errorf("failed to encrypt snapshot: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to encrypt snapshot: %v", err)
+	}
+	return out.Close()
+}
+
+// Encrypt encrypts data using the configured scheme
+func (e Encryption) Encrypt(data []byte) ([]byte, error) {
+	encrypt, err := e.encrypter()
+	if err != nil {
+		return nil, err
+	}
+	if encrypt == nil {
+		return nil, fmt.Errorf("%w: no encryption recipients configured", ErrInvalidConfig)
+	}
+
+	var buf bytes.Buffer
+	w, err := encrypt(&buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decrypt returns a reader for the plain text of r, which was encrypted with scheme
+func (e Encryption) Decrypt(scheme string, r io.Reader) (io.Reader, error) {
+	if scheme == "" {
+		return r, nil
+	}
+	if e.IdentityFile == "" {
+		return nil, ErrNoIdentity
+	}
+
+	f, err := os.Open(e.IdentityFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open identity file: %v", err)
+	}
+	defer f.Close()
+
+	switch scheme {
+	case EncryptionAge:
+		identities, err := age.ParseIdentities(f)
+		if err != nil {
+			return nil, errors.New("failed to parse age identity file")
+		}
+		plain, err := age.Decrypt(r, identities...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt snapshot: %v", err)
+		}
+		return plain, nil
+	case EncryptionPGP:
+		keyring, err := openpgp.ReadArmoredKeyRing(f)
+		if err != nil {
+			return nil, errors.New("failed to parse OpenPGP private key")
+		}
+		md, err := openpgp.ReadMessage(r, keyring, e.pgpPrompt(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt snapshot: %v", err)
+		}
+		return md.UnverifiedBody, nil
+	}
+	return nil, fmt.Errorf("unknown snapshot encryption %q", scheme)
+}
+
+// pgpPrompt returns the prompt that unlocks passphrase-protected keys with the
+// configured passphrase. OpenPGP keeps prompting until a key is unlocked, so a
+// wrong passphrase is only tried once.
+func (e Encryption) pgpPrompt() openpgp.PromptFunction {
+	tried := false
+	return func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if symmetric || e.Passphrase == "" {
+			return nil, errors.New("OpenPGP private key is protected by a passphrase, none was configured")
+		}
+		if tried {
+			return nil, errors.New("OpenPGP passphrase does not unlock the private key")
+		}
+		tried = true
+		for _, key := range keys {
+			if key.PrivateKey != nil && key.PrivateKey.Encrypted {
+				// A key the passphrase does not fit stays locked and is skipped by ReadMessage
+				key.PrivateKey.Decrypt([]byte(e.Passphrase))
+			}
+		}
+		return nil, nil
+	}
+}
+
+func parseAgeRecipients(keys []string) ([]age.Recipient, error) {
+	recipients := make([]age.Recipient, 0, len(keys))
+	for i, key := range keys {
+		key = strings.TrimSpace(key)
+
+		var (
+			recipient age.Recipient
+			err       error
+		)
+		if strings.HasPrefix(key, "ssh-") {
+			recipient, err = agessh.ParseRecipient(key)
+		} else {
+			recipient, err = age.ParseX25519Recipient(key)
+		}
+		if err != nil {
+			// The key itself is left out of the error on purpose
+			return nil, fmt.Errorf("%w: age recipient #%d is invalid", ErrInvalidConfig, i+1)
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+func readPGPKeyring(path string) (openpgp.EntityList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open OpenPGP keyring: %v", err)
+	}
+	defer f.Close()
+
+	keyring, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to parse OpenPGP keyring", ErrInvalidConfig)
+	}
+	return keyring, nil
+}
@@ -0,0 +1,182 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+// ageEncryption returns an age configuration with a fresh identity written to a file
+func ageEncryption(t *testing.T) Encryption {
+	t.Helper()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "identity.txt")
+	if err := os.WriteFile(file, []byte(identity.String()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return Encryption{AgeRecipients: []string{identity.Recipient().String()}, IdentityFile: file}
+}
+
+// pgpEncryption returns an OpenPGP configuration with a fresh key pair, its
+// private key protected by passphrase when one is given
+func pgpEncryption(t *testing.T, passphrase string) Encryption {
+	t.Helper()
+	entity, err := openpgp.NewEntity("gitcloner", "test", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	public := writeArmored(t, filepath.Join(dir, "public.asc"), openpgp.PublicKeyType, entity.Serialize)
+	if passphrase != "" {
+		if err := entity.EncryptPrivateKeys([]byte(passphrase), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	private := writeArmored(t, filepath.Join(dir, "private.asc"), openpgp.PrivateKeyType, func(w io.Writer) error {
+		return entity.SerializePrivateWithoutSigning(w, nil)
+	})
+	return Encryption{PGPKeyring: public, IdentityFile: private, Passphrase: passphrase}
+}
+
+func writeArmored(t *testing.T, file, blockType string, serialize func(io.Writer) error) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, blockType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// roundTrip encrypts random bytes with e and decrypts them with d
+func roundTrip(t *testing.T, e, d Encryption) ([]byte, []byte, error) {
+	t.Helper()
+	plain := make([]byte, 256*1024)
+	if _, err := rand.Read(plain); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "plain"), filepath.Join(dir, "encrypted")
+	if err := os.WriteFile(src, plain, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.EncryptFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encrypted, plain[:64]) {
+		t.Fatal("encrypted file contains the plain text")
+	}
+
+	r, err := d.Decrypt(e.Scheme(), bytes.NewReader(encrypted))
+	if err != nil {
+		return plain, nil, err
+	}
+	decrypted, err := io.ReadAll(r)
+	return plain, decrypted, err
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		encryption Encryption
+	}{
+		{"age", ageEncryption(t)},
+		{"pgp", pgpEncryption(t, "")},
+		{"pgp with passphrase", pgpEncryption(t, "correct horse battery staple")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.encryption.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			plain, decrypted, err := roundTrip(t, tt.encryption, tt.encryption)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(plain, decrypted) {
+				t.Fatal("decrypted bytes differ from the original")
+			}
+		})
+	}
+}
+
+func TestDecryptPGPPassphrase(t *testing.T) {
+	e := pgpEncryption(t, "correct horse battery staple")
+
+	for _, passphrase := range []string{"", "wrong"} {
+		d := e
+		d.Passphrase = passphrase
+		if _, _, err := roundTrip(t, e, d); err == nil {
+			t.Errorf("decrypting with passphrase %q succeeded", passphrase)
+		}
+	}
+}
+
+func TestDecryptWithoutIdentity(t *testing.T) {
+	e := ageEncryption(t)
+	d := e
+	d.IdentityFile = ""
+	if _, _, err := roundTrip(t, e, d); !errors.Is(err, ErrNoIdentity) {
+		t.Fatalf("Decrypt without identity = %v, want ErrNoIdentity", err)
+	}
+}
+
+func TestEncryptionValidate(t *testing.T) {
+	both := ageEncryption(t)
+	both.PGPKeyring = pgpEncryption(t, "").PGPKeyring
+	if err := both.Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Validate with age and OpenPGP = %v, want ErrInvalidConfig", err)
+	}
+
+	invalid := Encryption{AgeRecipients: []string{"age1notakey"}}
+	if err := invalid.Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Validate with an invalid recipient = %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestRestoreEncryptedChain(t *testing.T) {
+	for name, encryption := range map[string]Encryption{
+		"age": ageEncryption(t),
+		"pgp": pgpEncryption(t, "passphrase"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := newDestination(t)
+			s := newSnapshotter(t, Config{Incremental: true, Encryption: encryption})
+
+			snapshot(t, s, d)
+			commit(t, d.work, "second")
+			d.push(t)
+			if second := snapshot(t, s, d); !second.Incremental || second.RefsKey == "" || second.Refs != nil {
+				t.Fatalf("second snapshot = %+v, want incremental with sealed refs", second)
+			}
+
+			assertRestored(t, s, "", d.refs(t))
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/git"
+)
+
+// Restore rebuilds the repository called name as a bare repository in dir. The
+// snapshot with the given ID is restored, or the latest one when id is empty.
+// The restored refs match the snapshot exactly, including refs that were deleted
+// between the bundles of an incremental chain. Encrypted bundles and refs are
+// decrypted with the identity configured in encryption.
+func Restore(ctx context.Context, storage Storage, encryption Encryption, name, id, dir string) error {
+	index, err := LoadIndex(ctx, storage, name)
+	if err != nil {
+		return err
+	}
+
+	var (
+		target Entry
+		ok     bool
+	)
+	if id == "" {
+		target, ok = index.Latest()
+	} else {
+		target, ok = index.Find(id)
+	}
+	if !ok {
+		return fmt.Errorf("%w: no snapshot %q for %s", ErrNotFound, id, name)
+	}
+
+	chain, err := index.Chain(target)
+	if err != nil {
+		return err
+	}
+	if target, err = openRefs(ctx, storage, encryption, target); err != nil {
+		return err
+	}
+
+	if err := git.InitBare(ctx, dir); err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "gitcloner-restore-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, e := range chain {
+		file, err := download(ctx, storage, encryption, e, tmpDir)
+		if err != nil {
+			return err
+		}
+		if err := git.VerifyBundle(ctx, dir, file); err != nil {
+			return fmt.Errorf("snapshot %s failed verification: %v", e.ID, err)
+		}
+		if err := git.FetchBundle(ctx, dir, file); err != nil {
+			return fmt.Errorf("failed to apply snapshot %s: %v", e.ID, err)
+		}
+		os.Remove(file)
+	}
+
+	return resetRefs(ctx, dir, target)
+}
+
+// resetRefs makes the refs in dir match the ones recorded in e
+func resetRefs(ctx context.Context, dir string, e Entry) error {
+	current, err := git.Refs(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	for ref := range current {
+		if _, ok := e.Refs[ref]; !ok {
+			if err := git.DeleteRef(ctx, dir, ref); err != nil {
+				return err
+			}
+		}
+	}
+	for ref, sha := range e.Refs {
+		if current[ref] == sha {
+			continue
+		}
+		if err := git.UpdateRef(ctx, dir, ref, sha); err != nil {
+			return err
+		}
+	}
+
+	if e.Head != "" {
+		if _, err := git.Run(ctx, dir, "symbolic-ref", "HEAD", e.Head); err != nil {
+			return err
+		}
+	}
+	return nil
+}
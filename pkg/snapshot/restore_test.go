@@ -0,0 +1,266 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/git"
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
+)
+
+// gitCmd runs git in dir with a fixed identity and fails the test on error
+func gitCmd(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.email=test@example.com", "-c", "user.name=test"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+	return string(out)
+}
+
+func commit(t *testing.T, dir, message string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte(message), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	gitCmd(t, dir, "add", "file.txt")
+	gitCmd(t, dir, "commit", "--quiet", "-m", message)
+}
+
+func newStorage(t *testing.T) Storage {
+	t.Helper()
+	storage, err := NewStorage(t.TempDir(), S3Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage
+}
+
+// destination is a mirror acme-api served over smart HTTP like a destination
+// forge, with a work tree that updates it
+type destination struct {
+	repo mirror.Repository
+	dir  string // The bare repository that is served
+	work string
+}
+
+// newDestination creates acme-api with one commit on main
+func newDestination(t *testing.T) *destination {
+	t.Helper()
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+	root := t.TempDir()
+	d := &destination{dir: filepath.Join(root, "acme-api.git"), work: filepath.Join(t.TempDir(), "work")}
+	gitCmd(t, "", "init", "--quiet", "--bare", "--initial-branch=main", d.dir)
+	gitCmd(t, "", "init", "--quiet", "--initial-branch=main", d.work)
+	gitCmd(t, d.work, "remote", "add", "origin", d.dir)
+	commit(t, d.work, "initial")
+	d.push(t)
+
+	server := httptest.NewServer(&cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	})
+	t.Cleanup(server.Close)
+	d.repo = mirror.Repository{Name: "acme-api", CloneURL: server.URL + "/acme-api.git"}
+	return d
+}
+
+// push makes the mirror match the work tree, including deleted and rewritten refs
+func (d *destination) push(t *testing.T) {
+	t.Helper()
+	gitCmd(t, d.work, "push", "--quiet", "--force", "--prune", "origin", "refs/heads/*:refs/heads/*", "refs/tags/*:refs/tags/*")
+}
+
+// refs returns the refs of the mirror
+func (d *destination) refs(t *testing.T) map[string]string {
+	t.Helper()
+	refs, err := git.Refs(context.Background(), d.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return refs
+}
+
+// newSnapshotter returns a snapshotter for the destination forge of d
+func newSnapshotter(t *testing.T, config Config) *Snapshotter {
+	t.Helper()
+	config.Destination = t.TempDir()
+	s, err := New(mirror.Config{Type: "gitea", TokenSecret: secret.NewValue("destination-token")}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// snapshot snapshots the mirror of d and returns the latest entry of its index
+func snapshot(t *testing.T, s *Snapshotter, d *destination) Entry {
+	t.Helper()
+	ctx := context.Background()
+	if err := s.Snapshot(ctx, d.repo); err != nil {
+		t.Fatal(err)
+	}
+	index, err := LoadIndex(ctx, s.storage, d.repo.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := index.Latest()
+	if !ok {
+		t.Fatal("no snapshot was written")
+	}
+	return e
+}
+
+// assertRestored restores id and checks that the refs match want and HEAD is main
+func assertRestored(t *testing.T, s *Snapshotter, id string, want map[string]string) {
+	t.Helper()
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "restored.git")
+	if err := Restore(ctx, s.storage, s.config.Encryption, "acme-api", id, dir); err != nil {
+		t.Fatalf("Restore(%q): %v", id, err)
+	}
+
+	refs, err := git.Refs(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(refs, want) {
+		t.Errorf("Restore(%q) refs = %v, want %v", id, refs, want)
+	}
+	if head := symbolicHead(ctx, dir); head != "refs/heads/main" {
+		t.Errorf("Restore(%q) HEAD = %q, want refs/heads/main", id, head)
+	}
+	gitCmd(t, dir, "fsck", "--no-dangling")
+}
+
+func TestRestoreFullBundle(t *testing.T) {
+	d := newDestination(t)
+	gitCmd(t, d.work, "branch", "feature")
+	gitCmd(t, d.work, "tag", "-a", "v1.0.0", "-m", "release")
+	d.push(t)
+	s := newSnapshotter(t, Config{})
+
+	full := snapshot(t, s, d)
+	if full.Incremental {
+		t.Error("incremental snapshot without incremental snapshots configured")
+	}
+	assertRestored(t, s, "", d.refs(t))
+}
+
+func TestRestoreIncrementalChain(t *testing.T) {
+	d := newDestination(t)
+	gitCmd(t, d.work, "branch", "feature")
+	d.push(t)
+	s := newSnapshotter(t, Config{Incremental: true})
+
+	full := snapshot(t, s, d)
+	fullRefs := d.refs(t)
+
+	commit(t, d.work, "second")
+	gitCmd(t, d.work, "tag", "v1.0.0")
+	d.push(t)
+	second := snapshot(t, s, d)
+	secondRefs := d.refs(t)
+
+	// The third snapshot deletes a branch and rewrites main, so part of its base is gone from the clone
+	commit(t, d.work, "third")
+	gitCmd(t, d.work, "branch", "-D", "feature")
+	gitCmd(t, d.work, "commit", "--quiet", "--amend", "-m", "third, amended")
+	d.push(t)
+	third := snapshot(t, s, d)
+
+	if full.Incremental || !second.Incremental || second.Base != full.ID || !third.Incremental || third.Base != second.ID {
+		t.Fatalf("snapshots %+v, %+v, %+v, want a full bundle and an incremental chain on it", full, second, third)
+	}
+
+	assertRestored(t, s, "", d.refs(t))
+	assertRestored(t, s, second.ID, secondRefs)
+	assertRestored(t, s, full.ID, fullRefs)
+}
+
+func TestSnapshotSkipsUnchanged(t *testing.T) {
+	d := newDestination(t)
+	s := newSnapshotter(t, Config{Incremental: true})
+
+	first := snapshot(t, s, d)
+	if again := snapshot(t, s, d); again.ID != first.ID {
+		t.Errorf("unchanged repository snapshotted again as %s", again.ID)
+	}
+
+	// Moving a tag changes no branch but is still a change
+	gitCmd(t, d.work, "tag", "v1.0.0")
+	d.push(t)
+	if tagged := snapshot(t, s, d); tagged.ID == first.ID {
+		t.Error("new tag was not snapshotted")
+	}
+}
+
+func TestSnapshotFullEvery(t *testing.T) {
+	d := newDestination(t)
+	s := newSnapshotter(t, Config{Incremental: true, FullEvery: 2})
+
+	var incremental []bool
+	for i := 0; i < 5; i++ {
+		if i > 0 {
+			commit(t, d.work, fmt.Sprintf("change %d", i))
+			d.push(t)
+		}
+		incremental = append(incremental, snapshot(t, s, d).Incremental)
+	}
+
+	// A chain holds at most two snapshots, the full bundle included
+	want := []bool{false, true, false, true, false}
+	if !slices.Equal(incremental, want) {
+		t.Errorf("incremental = %v, want %v", incremental, want)
+	}
+	assertRestored(t, s, "", d.refs(t))
+}
+
+func TestRestoreMissingBase(t *testing.T) {
+	d := newDestination(t)
+	s := newSnapshotter(t, Config{Incremental: true})
+	snapshot(t, s, d)
+	commit(t, d.work, "second")
+	d.push(t)
+	snapshot(t, s, d)
+
+	ctx := context.Background()
+	index, err := LoadIndex(ctx, s.storage, "acme-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Snapshots = index.Snapshots[1:]
+	if err := SaveIndex(ctx, s.storage, "acme-api", index); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Restore(ctx, s.storage, Encryption{}, "acme-api", "", filepath.Join(t.TempDir(), "restored.git")); err == nil {
+		t.Fatal("Restore succeeded without the base of an incremental snapshot")
+	}
+}
+
+func TestRestoreUnknownSnapshot(t *testing.T) {
+	d := newDestination(t)
+	s := newSnapshotter(t, Config{})
+	snapshot(t, s, d)
+
+	err := Restore(context.Background(), s.storage, Encryption{}, "acme-api", "19700101T000000Z", filepath.Join(t.TempDir(), "restored.git"))
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Restore of an unknown snapshot = %v, want ErrNotFound", err)
+	}
+}
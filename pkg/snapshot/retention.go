@@ -0,0 +1,82 @@
+package snapshot
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Retention describes a grandfather-father-son retention policy. Each field is the
+// number of daily, weekly and monthly snapshots to keep. When every field is zero,
+// all snapshots are kept.
+type Retention struct {
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+// IsZero reports whether the policy keeps everything
+func (r Retention) IsZero() bool {
+	return r.Daily == 0 && r.Weekly == 0 && r.Monthly == 0
+}
+
+// Apply splits entries into the ones to keep and the ones to delete. The newest
+// snapshot is always kept, and every incremental snapshot that is kept also keeps
+// the chain it depends on.
+func (r Retention) Apply(entries []Entry) (keep, drop []Entry) {
+	if r.IsZero() || len(entries) == 0 {
+		return entries, nil
+	}
+
+	sorted := make([]Entry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Time.After(sorted[j].Time)
+	})
+
+	kept := map[string]bool{sorted[0].ID: true}
+	markBuckets(sorted, r.Daily, kept, func(e Entry) string {
+		return e.Time.UTC().Format("2006-01-02")
+	})
+	markBuckets(sorted, r.Weekly, kept, func(e Entry) string {
+		year, week := e.Time.UTC().ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	markBuckets(sorted, r.Monthly, kept, func(e Entry) string {
+		return e.Time.UTC().Format("2006-01")
+	})
+
+	byID := make(map[string]Entry, len(sorted))
+	for _, e := range sorted {
+		byID[e.ID] = e
+	}
+	for id := range kept {
+		for e := byID[id]; e.Incremental; e = byID[e.Base] {
+			kept[e.Base] = true
+		}
+	}
+
+	for _, e := range entries {
+		if kept[e.ID] {
+			keep = append(keep, e)
+		} else {
+			drop = append(drop, e)
+		}
+	}
+	return keep, drop
+}
+
+// markBuckets keeps the newest entry of each of the first n buckets. Entries must be sorted newest first.
+func markBuckets(sorted []Entry, n int, kept map[string]bool, bucket func(Entry) string) {
+	seen := make(map[string]bool)
+	for _, e := range sorted {
+		if len(seen) == n {
+			return
+		}
+		b := bucket(e)
+		if seen[b] {
+			continue
+		}
+		seen[b] = true
+		kept[e.ID] = true
+	}
+}
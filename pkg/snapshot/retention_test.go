@@ -0,0 +1,100 @@
+package snapshot
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// daily returns one full snapshot per day for n days, oldest first
+func daily(start time.Time, n int) []Entry {
+	entries := make([]Entry, n)
+	for i := range entries {
+		at := start.AddDate(0, 0, i)
+		entries[i] = Entry{ID: at.Format("20060102T150405Z"), Time: at}
+	}
+	return entries
+}
+
+func ids(entries []Entry) []string {
+	var out []string
+	for _, e := range entries {
+		out = append(out, e.ID)
+	}
+	return out
+}
+
+func TestRetentionApply(t *testing.T) {
+	start := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC) // A Monday
+	entries := daily(start, 70)
+
+	tests := []struct {
+		name      string
+		retention Retention
+		keep      []string
+	}{
+		{
+			name:      "zero keeps everything",
+			retention: Retention{},
+			keep:      ids(entries),
+		},
+		{
+			name:      "daily",
+			retention: Retention{Daily: 3},
+			keep:      []string{"20240308T030000Z", "20240309T030000Z", "20240310T030000Z"},
+		},
+		{
+			name:      "weekly keeps the newest of each week",
+			retention: Retention{Weekly: 3},
+			keep:      []string{"20240225T030000Z", "20240303T030000Z", "20240310T030000Z"},
+		},
+		{
+			name:      "monthly keeps the newest of each month",
+			retention: Retention{Monthly: 3},
+			keep:      []string{"20240131T030000Z", "20240229T030000Z", "20240310T030000Z"},
+		},
+		{
+			name:      "buckets overlap",
+			retention: Retention{Daily: 2, Weekly: 2, Monthly: 2},
+			keep:      []string{"20240229T030000Z", "20240303T030000Z", "20240309T030000Z", "20240310T030000Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep, drop := tt.retention.Apply(entries)
+			if got := ids(keep); !slices.Equal(got, tt.keep) {
+				t.Errorf("keep = %v, want %v", got, tt.keep)
+			}
+			if len(keep)+len(drop) != len(entries) {
+				t.Errorf("kept %d and dropped %d of %d entries", len(keep), len(drop), len(entries))
+			}
+		})
+	}
+}
+
+func TestRetentionApplyKeepsChains(t *testing.T) {
+	start := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+	entries := daily(start, 4)
+	for i := 1; i < len(entries); i++ {
+		entries[i].Incremental, entries[i].Base = true, entries[i-1].ID
+	}
+
+	keep, drop := Retention{Daily: 1}.Apply(entries)
+	if len(drop) != 0 {
+		t.Errorf("dropped %v, which the newest snapshot depends on", ids(drop))
+	}
+	if len(keep) != len(entries) {
+		t.Errorf("keep = %v, want the whole chain", ids(keep))
+	}
+}
+
+func TestRetentionApplyKeepsNewest(t *testing.T) {
+	entries := daily(time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC), 3)
+	// Entries out of order must not change which one is the newest
+	entries[0], entries[2] = entries[2], entries[0]
+
+	keep, _ := Retention{Monthly: 1}.Apply(entries)
+	if got := ids(keep); !slices.Equal(got, []string{"20240103T030000Z"}) {
+		t.Errorf("keep = %v, want only the newest", got)
+	}
+}
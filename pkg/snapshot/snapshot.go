@@ -0,0 +1,453 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/git"
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+)
+
+// ErrInvalidConfig is returned when the snapshot configuration is invalid
+var ErrInvalidConfig = errors.New("invalid snapshot configuration")
+
+// Config holds the configuration for periodic bundle snapshots
+type Config struct {
+	Destination string // Local directory or s3://bucket/prefix
+	Interval    time.Duration
+	Incremental bool
+	FullEvery   int // Number of snapshots in a chain before a new full bundle is written
+	Retention   Retention
+	S3          S3Config
+	Encryption  Encryption
+}
+
+// Entry describes a single bundle snapshot of a repository
+type Entry struct {
+	ID          string            `json:"id"`
+	Time        time.Time         `json:"time"`
+	Key         string            `json:"key"`
+	Incremental bool              `json:"incremental"`
+	Base        string            `json:"base,omitempty"` // ID of the snapshot an incremental bundle builds on
+	Encryption  string            `json:"encryption,omitempty"`
+	Head        string            `json:"head,omitempty"`
+	Refs        map[string]string `json:"refs,omitempty"`
+
+	// Ref names can say more than the bundles should, so encrypted snapshots
+	// keep Head and Refs in a file encrypted like the bundle under RefsKey. The
+	// index then only holds what the next snapshot needs: a digest to skip an
+	// unchanged repository and the commits an incremental bundle builds on.
+	RefsKey    string   `json:"refs_key,omitempty"`
+	RefsDigest string   `json:"refs_digest,omitempty"`
+	Objects    []string `json:"objects,omitempty"`
+}
+
+// sealedRefs is the encrypted file holding the refs of a snapshot
+type sealedRefs struct {
+	Head string            `json:"head,omitempty"`
+	Refs map[string]string `json:"refs"`
+}
+
+// digest returns the digest of the refs of e
+func (e Entry) digest() string {
+	if e.RefsDigest != "" {
+		return e.RefsDigest
+	}
+	return refsDigest(e.Refs)
+}
+
+// objects returns the commits the refs of e point at, sorted and without duplicates
+func (e Entry) objects() []string {
+	if e.Objects != nil {
+		return e.Objects
+	}
+	objects := slices.Sorted(maps.Values(e.Refs))
+	return slices.Compact(objects)
+}
+
+// refsDigest returns a digest that changes when any ref is added, removed or moved
+func refsDigest(refs map[string]string) string {
+	h := sha256.New()
+	for _, ref := range slices.Sorted(maps.Keys(refs)) {
+		fmt.Fprintf(h, "%s %s\n", refs[ref], ref)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Index lists the snapshots stored for a repository, oldest first
+type Index struct {
+	Snapshots []Entry `json:"snapshots"`
+}
+
+// Latest returns the newest snapshot in the index
+func (i *Index) Latest() (Entry, bool) {
+	if len(i.Snapshots) == 0 {
+		return Entry{}, false
+	}
+	return i.Snapshots[len(i.Snapshots)-1], true
+}
+
+// Find returns the snapshot with the given ID
+func (i *Index) Find(id string) (Entry, bool) {
+	for _, e := range i.Snapshots {
+		if e.ID == id {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// Chain returns the snapshots that need to be applied in order to restore e,
+// starting with the full bundle it is based on
+func (i *Index) Chain(e Entry) ([]Entry, error) {
+	chain := []Entry{e}
+	for e.Incremental {
+		base, ok := i.Find(e.Base)
+		if !ok {
+			return nil, fmt.Errorf("snapshot %s is missing its base %s", e.ID, e.Base)
+		}
+		chain = append([]Entry{base}, chain...)
+		e = base
+	}
+	return chain, nil
+}
+
+// Snapshotter periodically writes git bundles of every destination mirror
+type Snapshotter struct {
+	config       Config
+	mirrorConfig mirror.Config
+	storage      Storage
+}
+
+// New creates a new Snapshotter for the mirrors of the configured destination
+func New(mirrorConfig mirror.Config, config Config) (*Snapshotter, error) {
+	if err := config.Encryption.Validate(); err != nil {
+		return nil, err
+	}
+
+	storage, err := NewStorage(config.Destination, config.S3)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Interval <= 0 {
+		config.Interval = 24 * time.Hour
+	}
+	if config.FullEvery <= 0 {
+		config.FullEvery = 7
+	}
+
+	return &Snapshotter{
+		config:       config,
+		mirrorConfig: mirrorConfig,
+		storage:      storage,
+	}, nil
+}
+
+// Run snapshots all mirrors once per interval until ctx is cancelled
+func (s *Snapshotter) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.SnapshotAll(ctx); err != nil {
+			log.Printf("Warning: Snapshot run failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SnapshotAll writes a snapshot of every mirror on the destination
+func (s *Snapshotter) SnapshotAll(ctx context.Context) error {
+	mirrorService, err := mirror.NewMirrorService(s.mirrorConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create mirror service: %v", err)
+	}
+
+	repos, err := mirrorService.ListMirrors()
+	if err != nil {
+		return err
+	}
+
+	for _, repo := range repos {
+		if err := s.Snapshot(ctx, repo); err != nil {
+			log.Printf("Warning: Failed to snapshot repository %s: %v", repo.Name, err)
+			continue
+		}
+	}
+
+	return nil
+}
+
+// Snapshot writes a bundle of a single destination repository and applies the retention policy
+func (s *Snapshotter) Snapshot(ctx context.Context, repo mirror.Repository) error {
+	workDir, err := os.MkdirTemp("", "gitcloner-snapshot-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	cloneURL, err := s.mirrorConfig.DestinationCloneURL(repo)
+	if err != nil {
+		return err
+	}
+
+	repoDir := filepath.Join(workDir, "repo.git")
+	if err := git.CloneMirror(ctx, cloneURL, repoDir); err != nil {
+		return fmt.Errorf("failed to clone repository: %v", err)
+	}
+
+	refs, err := git.Refs(ctx, repoDir)
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		log.Printf("Skipping snapshot of %s: %v", repo.Name, git.ErrEmptyRepository)
+		return nil
+	}
+
+	index, err := LoadIndex(ctx, s.storage, repo.Name)
+	if err != nil {
+		return err
+	}
+
+	last, hasLast := index.Latest()
+	if hasLast && last.digest() == refsDigest(refs) {
+		log.Printf("Skipping snapshot of %s: no changes since %s", repo.Name, last.ID)
+		return nil
+	}
+
+	// IDs have a resolution of a second, so a snapshot right after the last one moves on to the next
+	now := time.Now().UTC().Truncate(time.Second)
+	if hasLast && !now.After(last.Time) {
+		now = last.Time.Add(time.Second)
+	}
+	entry := Entry{
+		ID:         now.Format("20060102T150405Z"),
+		Time:       now,
+		Encryption: s.config.Encryption.Scheme(),
+		Head:       symbolicHead(ctx, repoDir),
+		Refs:       refs,
+	}
+	entry.Key = fmt.Sprintf("%s/%s.bundle%s", repo.Name, entry.ID, Extension(entry.Encryption))
+
+	bundleFile := filepath.Join(workDir, "snapshot.bundle")
+	if exclude := s.incrementalBase(ctx, repoDir, index, last, hasLast); len(exclude) > 0 {
+		if err := git.CreateBundle(ctx, repoDir, bundleFile, exclude); err == nil {
+			entry.Incremental = true
+			entry.Base = last.ID
+		} else {
+			log.Printf("Incremental bundle for %s failed, writing a full bundle: %v", repo.Name, err)
+		}
+	}
+	if !entry.Incremental {
+		if err := git.CreateBundle(ctx, repoDir, bundleFile, nil); err != nil {
+			return fmt.Errorf("failed to create bundle: %v", err)
+		}
+	}
+
+	if entry.Encryption != "" {
+		encrypted := bundleFile + Extension(entry.Encryption)
+		if err := s.config.Encryption.EncryptFile(bundleFile, encrypted); err != nil {
+			return err
+		}
+		bundleFile = encrypted
+	}
+
+	if err := s.upload(ctx, entry.Key, bundleFile); err != nil {
+		return fmt.Errorf("failed to store bundle: %v", err)
+	}
+	if entry.Encryption != "" {
+		if err := s.sealRefs(ctx, repo.Name, &entry); err != nil {
+			return fmt.Errorf("failed to store refs: %v", err)
+		}
+	}
+
+	index.Snapshots = append(index.Snapshots, entry)
+	keep, drop := s.config.Retention.Apply(index.Snapshots)
+	index.Snapshots = keep
+
+	if err := SaveIndex(ctx, s.storage, repo.Name, index); err != nil {
+		return err
+	}
+
+	// Only delete bundles once the index no longer references them
+	for _, e := range drop {
+		for _, key := range []string{e.Key, e.RefsKey} {
+			if key == "" {
+				continue
+			}
+			if err := s.storage.Delete(ctx, key); err != nil {
+				log.Printf("Warning: Failed to delete expired snapshot %s: %v", key, err)
+			}
+		}
+	}
+
+	log.Printf("Created snapshot %s of %s (incremental: %t)", entry.ID, repo.Name, entry.Incremental)
+	return nil
+}
+
+// incrementalBase returns the objects an incremental bundle can exclude, or nil when a full bundle is due
+func (s *Snapshotter) incrementalBase(ctx context.Context, repoDir string, index *Index, last Entry, hasLast bool) []string {
+	if !s.config.Incremental || !hasLast {
+		return nil
+	}
+
+	chain, err := index.Chain(last)
+	if err != nil || len(chain) >= s.config.FullEvery {
+		return nil
+	}
+
+	var exclude []string
+	for _, sha := range last.objects() {
+		// Objects that were force-pushed away are no longer in the clone and cannot be excluded
+		if git.HasObject(ctx, repoDir, sha) {
+			exclude = append(exclude, sha)
+		}
+	}
+	return exclude
+}
+
+// sealRefs stores the refs of e encrypted next to its bundle and leaves only
+// their digest and commits in e
+func (s *Snapshotter) sealRefs(ctx context.Context, name string, e *Entry) error {
+	data, err := json.Marshal(sealedRefs{Head: e.Head, Refs: e.Refs})
+	if err != nil {
+		return err
+	}
+	data, err = s.config.Encryption.Encrypt(data)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("%s/%s.refs.json%s", name, e.ID, Extension(e.Encryption))
+	if err := s.storage.Put(ctx, key, bytes.NewReader(data), int64(len(data))); err != nil {
+		return err
+	}
+	e.RefsKey, e.RefsDigest, e.Objects = key, e.digest(), e.objects()
+	e.Head, e.Refs = "", nil
+	return nil
+}
+
+// openRefs returns e with the refs that were sealed under its RefsKey
+func openRefs(ctx context.Context, storage Storage, encryption Encryption, e Entry) (Entry, error) {
+	if e.RefsKey == "" {
+		return e, nil
+	}
+
+	r, err := storage.Get(ctx, e.RefsKey)
+	if err != nil {
+		return e, fmt.Errorf("failed to fetch %s: %w", e.RefsKey, err)
+	}
+	defer r.Close()
+
+	plain, err := encryption.Decrypt(e.Encryption, r)
+	if err != nil {
+		return e, err
+	}
+	var sealed sealedRefs
+	if err := json.NewDecoder(plain).Decode(&sealed); err != nil {
+		return e, fmt.Errorf("failed to parse %s: %v", e.RefsKey, err)
+	}
+	e.Head, e.Refs = sealed.Head, sealed.Refs
+	return e, nil
+}
+
+func (s *Snapshotter) upload(ctx context.Context, key, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return s.storage.Put(ctx, key, f, info.Size())
+}
+
+// LoadIndex reads the snapshot index of a repository, returning an empty index when none exists
+func LoadIndex(ctx context.Context, storage Storage, name string) (*Index, error) {
+	r, err := storage.Get(ctx, indexKey(name))
+	if errors.Is(err, ErrNotFound) {
+		return &Index{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot index: %v", err)
+	}
+	defer r.Close()
+
+	var index Index
+	if err := json.NewDecoder(r).Decode(&index); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot index: %v", err)
+	}
+	return &index, nil
+}
+
+// SaveIndex writes the snapshot index of a repository
+func SaveIndex(ctx context.Context, storage Storage, name string, index *Index) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := storage.Put(ctx, indexKey(name), bytes.NewReader(data), int64(len(data))); err != nil {
+		return fmt.Errorf("failed to write snapshot index: %v", err)
+	}
+	return nil
+}
+
+func indexKey(name string) string {
+	return name + "/index.json"
+}
+
+func symbolicHead(ctx context.Context, dir string) string {
+	out, err := git.Run(ctx, dir, "symbolic-ref", "HEAD")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// download decrypts the bundle of e into a new file in dir
+func download(ctx context.Context, storage Storage, encryption Encryption, e Entry, dir string) (string, error) {
+	r, err := storage.Get(ctx, e.Key)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %w", e.Key, err)
+	}
+	defer r.Close()
+
+	plain, err := encryption.Decrypt(e.Encryption, r)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp(dir, "*.bundle")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, plain); err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %v", e.Key, err)
+	}
+	return f.Name(), f.Close()
+}
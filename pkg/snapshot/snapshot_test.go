@@ -0,0 +1,115 @@
+package snapshot
+
+import (
+	"context"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestIndexChain(t *testing.T) {
+	index := &Index{Snapshots: []Entry{
+		{ID: "a"},
+		{ID: "b", Incremental: true, Base: "a"},
+		{ID: "c", Incremental: true, Base: "b"},
+		{ID: "d"},
+		{ID: "e", Incremental: true, Base: "d"},
+	}}
+
+	tests := []struct {
+		id   string
+		want []string
+	}{
+		{"a", []string{"a"}},
+		{"c", []string{"a", "b", "c"}},
+		{"d", []string{"d"}},
+		{"e", []string{"d", "e"}},
+	}
+	for _, tt := range tests {
+		e, ok := index.Find(tt.id)
+		if !ok {
+			t.Fatalf("Find(%q) found nothing", tt.id)
+		}
+		chain, err := index.Chain(e)
+		if err != nil {
+			t.Fatalf("Chain(%q): %v", tt.id, err)
+		}
+		if got := ids(chain); !slices.Equal(got, tt.want) {
+			t.Errorf("Chain(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestIndexChainMissingBase(t *testing.T) {
+	index := &Index{Snapshots: []Entry{{ID: "b", Incremental: true, Base: "a"}}}
+	if _, err := index.Chain(index.Snapshots[0]); err == nil {
+		t.Fatal("Chain succeeded without the base snapshot")
+	}
+}
+
+func TestIndexLatest(t *testing.T) {
+	if _, ok := (&Index{}).Latest(); ok {
+		t.Error("Latest of an empty index found a snapshot")
+	}
+	index := &Index{Snapshots: []Entry{{ID: "a"}, {ID: "b"}}}
+	if e, _ := index.Latest(); e.ID != "b" {
+		t.Errorf("Latest = %q, want b", e.ID)
+	}
+}
+
+func TestSealedRefs(t *testing.T) {
+	ctx := context.Background()
+	storage := newStorage(t)
+	encryption := ageEncryption(t)
+	s := &Snapshotter{config: Config{Encryption: encryption}, storage: storage}
+
+	refs := map[string]string{
+		"refs/heads/main":              "1111111111111111111111111111111111111111",
+		"refs/heads/fix-cve-2024-1234": "2222222222222222222222222222222222222222",
+		"refs/tags/v1.0.0":             "1111111111111111111111111111111111111111",
+	}
+	e := Entry{ID: "20240501T120000Z", Encryption: encryption.Scheme(), Head: "refs/heads/main", Refs: maps.Clone(refs)}
+	if err := s.sealRefs(ctx, "acme-api", &e); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveIndex(ctx, storage, "acme-api", &Index{Snapshots: []Entry{e}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The index is stored in plain text, so it must not name any ref
+	r, err := storage.Get(ctx, "acme-api/index.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "refs/") {
+		t.Errorf("index names refs:\n%s", data)
+	}
+
+	// The server still knows enough to skip an unchanged repository and build on the last snapshot
+	index, err := LoadIndex(ctx, storage, "acme-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, _ := index.Latest()
+	if last.digest() != refsDigest(refs) {
+		t.Error("digest of the sealed refs does not match the refs")
+	}
+	if want := []string{refs["refs/heads/main"], refs["refs/heads/fix-cve-2024-1234"]}; !slices.Equal(last.objects(), want) {
+		t.Errorf("objects = %v, want %v", last.objects(), want)
+	}
+
+	opened, err := openRefs(ctx, storage, encryption, last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.Head != "refs/heads/main" || !maps.Equal(opened.Refs, refs) {
+		t.Errorf("opened refs = %s %v, want %v", opened.Head, opened.Refs, refs)
+	}
+}
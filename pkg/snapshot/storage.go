@@ -0,0 +1,160 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// ErrNotFound is returned by a Storage when the requested key does not exist
+var ErrNotFound = errors.New("snapshot object not found")
+
+// Storage stores snapshot bundles and indexes by key
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// S3Config holds the settings for an S3-compatible snapshot bucket
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Insecure  bool // Use plain HTTP, e.g. for a local MinIO
+}
+
+// NewStorage returns the storage for destination, which is either a local
+// directory or an s3://bucket/prefix URL
+func NewStorage(destination string, s3 S3Config) (Storage, error) {
+	if destination == "" {
+		return nil, fmt.Errorf("%w: snapshot destination is empty", ErrInvalidConfig)
+	}
+
+	if rest, ok := strings.CutPrefix(destination, "s3://"); ok {
+		bucket, prefix, _ := strings.Cut(rest, "/")
+		return newS3Storage(bucket, prefix, s3)
+	}
+
+	if err := os.MkdirAll(destination, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %v", err)
+	}
+	return &localStorage{root: destination}, nil
+}
+
+type localStorage struct {
+	root string
+}
+
+func (s *localStorage) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+func (s *localStorage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated snapshot behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *localStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *localStorage) Delete(ctx context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+type s3Storage struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func newS3Storage(bucket, prefix string, config S3Config) (*s3Storage, error) {
+	if bucket == "" || config.Endpoint == "" {
+		return nil, fmt.Errorf("%w: S3 bucket and endpoint are required", ErrInvalidConfig)
+	}
+
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: !config.Insecure,
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %v", err)
+	}
+
+	return &s3Storage{
+		client: client,
+		bucket: bucket,
+		prefix: strings.Trim(prefix, "/"),
+	}, nil
+}
+
+func (s *s3Storage) object(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + "/" + key
+}
+
+func (s *s3Storage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.object(key), r, size, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	return err
+}
+
+func (s *s3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, s.object(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// GetObject is lazy, stat it so a missing key is reported here and not on first read
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, s.object(key), minio.RemoveObjectOptions{})
+}
@@ -0,0 +1,143 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+)
+
+type giteaSource struct {
+	config  mirror.Config
+	baseURL string
+}
+
+// NewGitea creates a source for the Gitea or Forgejo instance at baseURL, authenticated with the source credentials
+func NewGitea(config mirror.Config, baseURL string) Source {
+	return &giteaSource{
+		config:  config,
+		baseURL: baseURL,
+	}
+}
+
+// client returns an API client authenticated for the repositories of owner
+func (s *giteaSource) client(ctx context.Context, owner string) (*gitea.Client, error) {
+	cred, err := s.config.SourceCredential(mirror.Repository{CloneURL: s.baseURL + "/" + owner + "/", Owner: owner})
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []gitea.ClientOption{gitea.SetContext(ctx)}
+	switch {
+	case cred.Username != "":
+		opts = append(opts, gitea.SetBasicAuth(cred.Username, cred.Password))
+	case cred.Password != "":
+		opts = append(opts, gitea.SetToken(cred.Password))
+	}
+
+	client, err := gitea.NewClient(s.baseURL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Gitea client: %v", err)
+	}
+	return client, nil
+}
+
+func (s *giteaSource) GetRepository(ctx context.Context, owner, name string) (*mirror.Repository, error) {
+	client, err := s.client(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, resp, err := client.GetRepo(owner, name)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s/%s", ErrRepositoryNotFound, owner, name)
+		}
+		return nil, fmt.Errorf("failed to get source repository: %v", err)
+	}
+
+	result := giteaRepository(repo)
+	topics, _, err := client.ListRepoTopics(owner, name, gitea.ListRepoTopicsOptions{})
+	if err != nil {
+		log.Printf("Warning: Failed to get topics of %s: %v", repo.FullName, err)
+	}
+	result.Topics = topics
+	return result, nil
+}
+
+// Topics returns the topics of a repository, which Gitea leaves out of repository listings
+func (s *giteaSource) Topics(ctx context.Context, owner, name string) ([]string, error) {
+	client, err := s.client(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	topics, _, err := client.ListRepoTopics(owner, name, gitea.ListRepoTopicsOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list topics of %s/%s: %v", owner, name, err)
+	}
+	return topics, nil
+}
+
+// ListRepositories returns every repository of an organization or user that the source credentials can see
+func (s *giteaSource) ListRepositories(ctx context.Context, kind, owner string, opts ListOptions) ([]mirror.Repository, error) {
+	client, err := s.client(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	var repos []mirror.Repository
+	page := gitea.ListOptions{Page: 1, PageSize: 50}
+	for {
+		if err := opts.wait(ctx); err != nil {
+			return nil, err
+		}
+		var (
+			batch []*gitea.Repository
+			resp  *gitea.Response
+			err   error
+		)
+		switch kind {
+		case OwnerOrg, OwnerGroup:
+			batch, resp, err = client.ListOrgRepos(owner, gitea.ListOrgReposOptions{ListOptions: page})
+		case OwnerUser:
+			batch, resp, err = client.ListUserRepos(owner, gitea.ListReposOptions{ListOptions: page})
+		default:
+			return nil, fmt.Errorf("%w %q for Gitea", ErrUnknownOwnerKind, kind)
+		}
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				return nil, fmt.Errorf("%w: %s %s", ErrRepositoryNotFound, kind, owner)
+			}
+			return nil, fmt.Errorf("failed to list repositories of %s: %v", owner, err)
+		}
+
+		for _, r := range batch {
+			if !opts.skip(r.Fork, r.Archived) {
+				repos = append(repos, *giteaRepository(r))
+			}
+		}
+		if resp == nil || resp.NextPage == 0 {
+			break
+		}
+		page.Page = resp.NextPage
+	}
+	return repos, nil
+}
+
+// giteaRepository converts repo. Gitea doesn't include topics in repositories,
+// see Topics.
+func giteaRepository(repo *gitea.Repository) *mirror.Repository {
+	return &mirror.Repository{
+		Name:          repo.Name,
+		Description:   repo.Description,
+		Private:       repo.Private,
+		CloneURL:      repo.CloneURL,
+		Owner:         repo.Owner.UserName,
+		DefaultBranch: repo.DefaultBranch,
+		Homepage:      repo.Website,
+	}
+}
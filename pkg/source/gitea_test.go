@@ -0,0 +1,85 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+)
+
+// newGiteaServer serves the Gitea API with an organization acme holding app,
+// counting the requests for topics
+func newGiteaServer(t *testing.T, topicRequests *atomic.Int64) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	repo := func() map[string]any {
+		return map[string]any{
+			"name":           "app",
+			"full_name":      "acme/app",
+			"description":    "The app",
+			"clone_url":      server.URL + "/acme/app.git",
+			"default_branch": "main",
+			"owner":          map[string]any{"login": "acme"},
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/version", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"version": "1.22.0"})
+	})
+	mux.HandleFunc("GET /api/v1/orgs/acme/repos", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]any{repo()})
+	})
+	mux.HandleFunc("GET /api/v1/repos/acme/app", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(repo())
+	})
+	mux.HandleFunc("GET /api/v1/repos/acme/app/topics", func(w http.ResponseWriter, r *http.Request) {
+		topicRequests.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{"topics": []string{"go", "backup"}})
+	})
+
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGiteaTopics(t *testing.T) {
+	var topicRequests atomic.Int64
+	server := newGiteaServer(t, &topicRequests)
+	src := NewGitea(mirror.Config{}, server.URL)
+	ctx := context.Background()
+
+	repos, err := src.ListRepositories(ctx, OwnerOrg, "acme", ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 1 || repos[0].Topics != nil {
+		t.Fatalf("ListRepositories = %+v, want one repository without topics", repos)
+	}
+	if n := topicRequests.Load(); n != 0 {
+		t.Errorf("ListRepositories requested topics %d times", n)
+	}
+
+	// The mirror name replaces the source name before the mirror is created
+	repo := repos[0]
+	repo.Name = mirror.MirrorName(repo.Owner, repo.Name)
+	if got := WithTopics(ctx, src, repo); !slices.Equal(got.Topics, []string{"go", "backup"}) {
+		t.Errorf("WithTopics = %v", got.Topics)
+	}
+
+	got, err := src.GetRepository(ctx, "acme", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got.Topics, []string{"go", "backup"}) {
+		t.Errorf("GetRepository topics = %v", got.Topics)
+	}
+}
@@ -0,0 +1,141 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v60/github"
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"golang.org/x/oauth2"
+)
+
+type githubSource struct {
+	config  mirror.Config
+	baseURL string
+}
+
+// NewGitHub creates a source for github.com or the GitHub Enterprise Server in
+// config.SourceGitHubURL. Requests are authenticated with the GitHub App when
+// it belongs to the same instance, and with the source credentials otherwise.
+func NewGitHub(config mirror.Config) Source {
+	return newGitHub(config, config.GitHubSourceURL())
+}
+
+func newGitHub(config mirror.Config, baseURL string) Source {
+	return &githubSource{
+		config:  config,
+		baseURL: baseURL,
+	}
+}
+
+// client returns an API client authenticated for repositories of owner
+func (s *githubSource) client(owner string) (*github.Client, error) {
+	probe := mirror.Repository{CloneURL: s.baseURL + "/" + owner + "/", Owner: owner}
+
+	// The credential is an installation token when the GitHub App covers owner
+	cred, err := s.config.SourceCredential(probe)
+	if err != nil {
+		return nil, err
+	}
+
+	var httpClient *http.Client
+	if cred.Password != "" {
+		httpClient = oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(
+			&oauth2.Token{AccessToken: cred.Password},
+		))
+	}
+
+	client := github.NewClient(httpClient)
+	if mirror.IsGitHubEnterpriseURL(s.baseURL) {
+		return client.WithEnterpriseURLs(s.baseURL, s.baseURL)
+	}
+	return client, nil
+}
+
+func (s *githubSource) GetRepository(ctx context.Context, owner, name string) (*mirror.Repository, error) {
+	client, err := s.client(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, resp, err := client.Repositories.Get(ctx, owner, name)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s/%s", ErrRepositoryNotFound, owner, name)
+		}
+		return nil, fmt.Errorf("failed to get source repository: %w", err)
+	}
+
+	return githubRepository(repo), nil
+}
+
+// ListRepositories returns every repository of an organization or user that the source credentials can see
+func (s *githubSource) ListRepositories(ctx context.Context, kind, owner string, opts ListOptions) ([]mirror.Repository, error) {
+	client, err := s.client(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	// Private repositories of a user are only listed when the user is the one authenticated
+	authenticated := false
+	if kind == OwnerUser {
+		if user, _, err := client.Users.Get(ctx, ""); err == nil && strings.EqualFold(user.GetLogin(), owner) {
+			authenticated = true
+		}
+	}
+
+	var repos []mirror.Repository
+	page := github.ListOptions{PerPage: 100}
+	for {
+		if err := opts.wait(ctx); err != nil {
+			return nil, err
+		}
+		var (
+			batch []*github.Repository
+			resp  *github.Response
+			err   error
+		)
+		switch {
+		case kind == OwnerOrg || kind == OwnerGroup:
+			batch, resp, err = client.Repositories.ListByOrg(ctx, owner, &github.RepositoryListByOrgOptions{Type: "all", ListOptions: page})
+		case kind == OwnerUser && authenticated:
+			batch, resp, err = client.Repositories.ListByAuthenticatedUser(ctx, &github.RepositoryListByAuthenticatedUserOptions{Affiliation: "owner", ListOptions: page})
+		case kind == OwnerUser:
+			batch, resp, err = client.Repositories.ListByUser(ctx, owner, &github.RepositoryListByUserOptions{Type: "owner", ListOptions: page})
+		default:
+			return nil, fmt.Errorf("%w %q for GitHub", ErrUnknownOwnerKind, kind)
+		}
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				return nil, fmt.Errorf("%w: %s %s", ErrRepositoryNotFound, kind, owner)
+			}
+			return nil, fmt.Errorf("failed to list repositories of %s: %w", owner, err)
+		}
+
+		for _, r := range batch {
+			if !opts.skip(r.GetFork(), r.GetArchived()) {
+				repos = append(repos, *githubRepository(r))
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		page.Page = resp.NextPage
+	}
+	return repos, nil
+}
+
+func githubRepository(repo *github.Repository) *mirror.Repository {
+	return &mirror.Repository{
+		Name:          repo.GetName(),
+		Description:   repo.GetDescription(),
+		Private:       repo.GetPrivate(),
+		CloneURL:      repo.GetCloneURL(),
+		Owner:         repo.GetOwner().GetLogin(),
+		DefaultBranch: repo.GetDefaultBranch(),
+		Topics:        repo.Topics,
+		Homepage:      repo.GetHomepage(),
+	}
+}
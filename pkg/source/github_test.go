@@ -0,0 +1,76 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
+)
+
+// newEnterpriseServer serves the GitHub Enterprise Server REST API under /api/v3
+// with a single private repository acme/app
+func newEnterpriseServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v3/repos/acme/app", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer source-token" {
+			http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"name":           "app",
+			"description":    "The app",
+			"private":        true,
+			"clone_url":      "https://" + r.Host + "/acme/app.git",
+			"default_branch": "trunk",
+			"owner":          map[string]any{"login": "acme"},
+		})
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGitHubEnterpriseGetRepository(t *testing.T) {
+	server := newEnterpriseServer(t)
+	src := NewGitHub(mirror.Config{
+		SourceGitHubURL:   server.URL,
+		SourceTokenSecret: secret.NewValue("source-token"),
+	})
+
+	repo, err := src.GetRepository(context.Background(), "acme", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.Owner != "acme" || !repo.Private || repo.DefaultBranch != "trunk" || repo.Description != "The app" {
+		t.Errorf("GetRepository = %+v", repo)
+	}
+
+	_, err = src.GetRepository(context.Background(), "acme", "missing")
+	if !errors.Is(err, ErrRepositoryNotFound) {
+		t.Errorf("GetRepository of a missing repository = %v, want ErrRepositoryNotFound", err)
+	}
+}
+
+func TestGitHubEnterpriseWithoutCredentials(t *testing.T) {
+	server := newEnterpriseServer(t)
+	src := NewGitHub(mirror.Config{SourceGitHubURL: server.URL})
+
+	// The server hides private repositories from anonymous requests
+	_, err := src.GetRepository(context.Background(), "acme", "app")
+	if !errors.Is(err, ErrRepositoryNotFound) {
+		t.Errorf("GetRepository without credentials = %v, want ErrRepositoryNotFound", err)
+	}
+}
@@ -0,0 +1,128 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+type gitlabSource struct {
+	config  mirror.Config
+	baseURL string
+}
+
+// NewGitLab creates a source for gitlab.com or the self-hosted GitLab in
+// config.SourceGitLabURL, authenticated with the source credentials
+func NewGitLab(config mirror.Config) Source {
+	return newGitLab(config, config.GitLabSourceURL())
+}
+
+func newGitLab(config mirror.Config, baseURL string) Source {
+	return &gitlabSource{
+		config:  config,
+		baseURL: baseURL,
+	}
+}
+
+// client returns an API client authenticated for the projects under owner
+func (s *gitlabSource) client(owner string) (*gitlab.Client, error) {
+	cred, err := s.config.SourceCredential(mirror.Repository{CloneURL: s.baseURL + "/" + owner + "/", Owner: owner})
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := gitlab.NewClient(cred.Password, gitlab.WithBaseURL(s.baseURL))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GitLab client: %v", err)
+	}
+	return client, nil
+}
+
+// GetRepository looks up a project. The owner is the full namespace path, e.g. group/subgroup.
+func (s *gitlabSource) GetRepository(ctx context.Context, owner, name string) (*mirror.Repository, error) {
+	client, err := s.client(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	path := owner + "/" + name
+	project, resp, err := client.Projects.GetProject(path, nil, gitlab.WithContext(ctx))
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s", ErrRepositoryNotFound, path)
+		}
+		return nil, fmt.Errorf("failed to get source project: %w", err)
+	}
+
+	return gitlabRepository(project), nil
+}
+
+// ListRepositories returns the projects of a group or user. Projects of subgroups
+// are only included with opts.IncludeSubgroups.
+func (s *gitlabSource) ListRepositories(ctx context.Context, kind, owner string, opts ListOptions) ([]mirror.Repository, error) {
+	client, err := s.client(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	var repos []mirror.Repository
+	page := gitlab.ListOptions{Page: 1, PerPage: 100}
+	for {
+		if err := opts.wait(ctx); err != nil {
+			return nil, err
+		}
+		var (
+			batch []*gitlab.Project
+			resp  *gitlab.Response
+			err   error
+		)
+		switch kind {
+		case OwnerGroup, OwnerOrg:
+			batch, resp, err = client.Groups.ListGroupProjects(owner, &gitlab.ListGroupProjectsOptions{
+				IncludeSubGroups: gitlab.Ptr(opts.IncludeSubgroups),
+				ListOptions:      page,
+			}, gitlab.WithContext(ctx))
+		case OwnerUser:
+			batch, resp, err = client.Projects.ListUserProjects(owner, &gitlab.ListProjectsOptions{
+				ListOptions: page,
+			}, gitlab.WithContext(ctx))
+		default:
+			return nil, fmt.Errorf("%w %q for GitLab", ErrUnknownOwnerKind, kind)
+		}
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				return nil, fmt.Errorf("%w: %s %s", ErrRepositoryNotFound, kind, owner)
+			}
+			return nil, fmt.Errorf("failed to list projects of %s: %w", owner, err)
+		}
+
+		for _, p := range batch {
+			if !opts.skip(p.ForkedFromProject != nil, p.Archived) {
+				repos = append(repos, *gitlabRepository(p))
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		page.Page = resp.NextPage
+	}
+	return repos, nil
+}
+
+// gitlabRepository converts a project. Its name is the display name, which
+// webhooks name mirrors by.
+func gitlabRepository(project *gitlab.Project) *mirror.Repository {
+	return &mirror.Repository{
+		Name:          project.Name,
+		Description:   project.Description,
+		Private:       project.Visibility != gitlab.PublicVisibility,
+		CloneURL:      project.HTTPURLToRepo,
+		Owner:         strings.TrimSuffix(project.PathWithNamespace, "/"+project.Path),
+		DefaultBranch: project.DefaultBranch,
+		Topics:        project.Topics,
+	}
+}
@@ -0,0 +1,186 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	// ErrRepositoryNotFound is returned when a repository does not exist on the source or is not visible to the source credentials
+	ErrRepositoryNotFound = errors.New("source repository not found")
+	// ErrUnknownProvider is returned when the forge hosting a repository cannot be determined
+	ErrUnknownProvider = errors.New("unknown source provider")
+	// ErrUnknownOwnerKind is returned when repositories are listed for an owner kind a source does not have
+	ErrUnknownOwnerKind = errors.New("unknown owner kind")
+)
+
+// Kinds of owners repositories are listed for. GitHub and Gitea organizations
+// and GitLab groups are interchangeable.
+const (
+	OwnerOrg   = "org"
+	OwnerGroup = "group"
+	OwnerUser  = "user"
+)
+
+// ListOptions filters the repositories returned by ListRepositories
+type ListOptions struct {
+	IncludeSubgroups bool // Also list the projects of GitLab subgroups
+	SkipForks        bool
+	SkipArchived     bool
+	Limiter          *rate.Limiter // Paces the requests for pages when set
+}
+
+// Source looks up repositories on the forge mirrors are created from. The
+// returned repositories carry the source owner and name, callers decide on the
+// name of the mirror.
+type Source interface {
+	GetRepository(ctx context.Context, owner, name string) (*mirror.Repository, error)
+	ListRepositories(ctx context.Context, kind, owner string, opts ListOptions) ([]mirror.Repository, error)
+}
+
+// TopicSource is implemented by sources whose listings leave out topics. They
+// are fetched one repository at a time, so only for repositories being mirrored.
+type TopicSource interface {
+	Topics(ctx context.Context, owner, name string) ([]string, error)
+}
+
+// WithTopics fills in the topics of repo when src left them out of its listing.
+// repo keeps its clone URL, so its name may already be the mirror name. A
+// failure is logged, as the mirror is complete without topics.
+func WithTopics(ctx context.Context, src Source, repo mirror.Repository) mirror.Repository {
+	topicSource, ok := src.(TopicSource)
+	if !ok {
+		return repo
+	}
+	path := repo.Path()
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return repo
+	}
+
+	topics, err := topicSource.Topics(ctx, path[:i], path[i+1:])
+	if err != nil {
+		log.Printf("Warning: Failed to get topics of %s: %v", path, err)
+		return repo
+	}
+	repo.Topics = topics
+	return repo
+}
+
+// wait blocks until the limiter allows requesting the next page
+func (opts ListOptions) wait(ctx context.Context) error {
+	if opts.Limiter == nil {
+		return nil
+	}
+	return opts.Limiter.Wait(ctx)
+}
+
+// skip reports whether a repository is left out of a listing by opts
+func (opts ListOptions) skip(fork, archived bool) bool {
+	return (opts.SkipForks && fork) || (opts.SkipArchived && archived)
+}
+
+// ForRepository returns the source hosting repo, picked by the provider of its
+// clone URL host, and the owner and name repo is known by on that source
+func ForRepository(config mirror.Config, repo mirror.Repository) (Source, string, string, error) {
+	path := repo.Path()
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return nil, "", "", fmt.Errorf("%w: no owner in %s", mirror.ErrInvalidCloneURL, repo.CloneURL)
+	}
+	owner, name := path[:i], path[i+1:]
+
+	switch provider, base := Instance(config, repo); provider {
+	case mirror.ProviderGitHub:
+		return newGitHub(config, base), owner, name, nil
+	case mirror.ProviderGitLab:
+		return newGitLab(config, base), owner, name, nil
+	case mirror.ProviderGitea:
+		return NewGitea(config, base), owner, name, nil
+	}
+	return nil, "", "", fmt.Errorf("%w for %s", ErrUnknownProvider, repo.Host())
+}
+
+// Instance returns the provider of the forge hosting repo and the base URL of
+// that instance, or empty strings when the host is not a known forge
+func Instance(config mirror.Config, repo mirror.Repository) (string, string) {
+	switch provider := config.Provider(repo.Host()); provider {
+	case mirror.ProviderGitHub:
+		return provider, baseURL(repo, config.GitHubSourceURL())
+	case mirror.ProviderGitLab:
+		return provider, baseURL(repo, config.GitLabSourceURL())
+	case mirror.ProviderGitea:
+		return provider, baseURL(repo, config.SourceGiteaURL)
+	}
+	return "", ""
+}
+
+// ForPlatform returns the source for a platform named on the command line. The
+// platform is github, gitlab or gitea for the configured instance, or
+// platform@https://host for another one. The returned config knows the provider of that host.
+func ForPlatform(config mirror.Config, platform string) (Source, mirror.Config, error) {
+	platform, base, inline := strings.Cut(platform, "@")
+	switch platform {
+	case mirror.ProviderGitHub, mirror.ProviderGitLab, mirror.ProviderGitea:
+	default:
+		return nil, config, fmt.Errorf("%w: %s. Use github, gitlab, or gitea", ErrUnknownProvider, platform)
+	}
+	if inline {
+		parsedURL, err := url.Parse(base)
+		if err != nil || parsedURL.Host == "" || (parsedURL.Scheme != "https" && parsedURL.Scheme != "http") {
+			return nil, config, fmt.Errorf("%w: %q is not an http(s) base URL", mirror.ErrInvalidCloneURL, base)
+		}
+		base = parsedURL.Scheme + "://" + parsedURL.Host
+		config = config.WithSourceProvider(parsedURL.Hostname(), platform)
+	}
+
+	switch platform {
+	case mirror.ProviderGitHub:
+		if !inline {
+			base = config.GitHubSourceURL()
+		}
+		return newGitHub(config, base), config, nil
+	case mirror.ProviderGitLab:
+		if !inline {
+			base = config.GitLabSourceURL()
+		}
+		return newGitLab(config, base), config, nil
+	default:
+		if !inline {
+			base = config.GiteaSourceURL()
+		}
+		return NewGitea(config, base), config, nil
+	}
+}
+
+// SplitPlatformURL splits platform@https://host/owner/repo into platform@https://host and owner/repo
+func SplitPlatformURL(spec string) (string, string, error) {
+	platform, rawURL, ok := strings.Cut(spec, "@")
+	parsedURL, err := url.Parse(rawURL)
+	if !ok || err != nil || parsedURL.Host == "" {
+		return "", "", fmt.Errorf("%w: %q, use platform@https://host/owner/repo", mirror.ErrInvalidCloneURL, spec)
+	}
+	path := strings.TrimSuffix(strings.Trim(parsedURL.Path, "/"), ".git")
+	return platform + "@" + parsedURL.Scheme + "://" + parsedURL.Host, path, nil
+}
+
+// baseURL returns configured when repo lives on that instance, and the scheme and host of the clone URL otherwise
+func baseURL(repo mirror.Repository, configured string) string {
+	if configured != "" && (&mirror.Repository{CloneURL: configured}).Host() == repo.Host() {
+		return configured
+	}
+
+	if parsedURL, err := url.Parse(repo.CloneURL); err == nil && (parsedURL.Scheme == "https" || parsedURL.Scheme == "http") {
+		return parsedURL.Scheme + "://" + parsedURL.Host
+	}
+	// SSH clone URLs are served by the HTTPS API of the same host
+	return "https://" + repo.Host()
+}
@@ -0,0 +1,366 @@
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/git"
+	"github.com/janyksteenbeek/gitcloner/pkg/gitsync"
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
+)
+
+// Statuses of a mirror compared with its source
+const (
+	StatusCurrent  = "current"
+	StatusBehind   = "behind"   // Missing source commits, but for less than the maximum lag
+	StatusStale    = "stale"    // Missing source commits for longer than the maximum lag
+	StatusDiverged = "diverged" // Holds commits the source does not have, e.g. after a force-push
+	StatusSkipped  = "skipped"
+	StatusFailed   = "failed"
+)
+
+// Config holds the configuration of the verifier
+type Config struct {
+	MaxLag   time.Duration // How long a mirror may miss source commits before it is stale, defaults to 24 hours
+	Interval time.Duration // How often Run verifies every mirror, defaults to 24 hours
+
+	// Cache holds the source repositories of the git destinations. Mirrors are
+	// verified in them, so only new commits are fetched. Without a cache every
+	// differing mirror is fetched into a temporary repository.
+	Cache *gitsync.Cache
+}
+
+// mirrorPrefix is where the refs of a mirror are fetched to next to those of its source
+const mirrorPrefix = "refs/gitcloner-verify/"
+
+// Result is the outcome of verifying one mirror
+type Result struct {
+	Name   string        `json:"name"`
+	Status string        `json:"status"`
+	Behind int           `json:"behind"`         // Source commits the mirror does not have
+	Lag    time.Duration `json:"-"`              // How long the mirror has been missing source commits
+	Refs   []string      `json:"refs,omitempty"` // Branches and tags that differ
+	Reason string        `json:"reason,omitempty"`
+}
+
+// MarshalJSON writes the lag in whole seconds
+func (r Result) MarshalJSON() ([]byte, error) {
+	type result Result
+	return json.Marshal(struct {
+		result
+		LagSeconds int64 `json:"lag_seconds"`
+	}{result(r), int64(r.Lag.Seconds())})
+}
+
+// Unhealthy reports whether the mirror needs attention
+func (r Result) Unhealthy() bool {
+	return r.Status == StatusStale || r.Status == StatusDiverged || r.Status == StatusFailed
+}
+
+// Summary describes the lag of a result in a few words
+func (r Result) Summary() string {
+	if r.Status == StatusFailed || r.Status == StatusSkipped {
+		return r.Reason
+	}
+	parts := []string{fmt.Sprintf("%d commits behind", r.Behind)}
+	if r.Lag > 0 {
+		parts = append(parts, "for "+r.Lag.String())
+	}
+	if len(r.Refs) > 0 {
+		parts = append(parts, fmt.Sprintf("%d refs differ", len(r.Refs)))
+	}
+	if r.Reason != "" {
+		parts = append(parts, r.Reason)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Verifier compares the branches and tags of mirrors with those of their sources
+type Verifier struct {
+	config       Config
+	mirrorConfig mirror.Config
+	service      mirror.MirrorService
+}
+
+// New creates a verifier for the mirrors of the configured destination
+func New(mirrorConfig mirror.Config, config Config) *Verifier {
+	if config.MaxLag <= 0 {
+		config.MaxLag = 24 * time.Hour
+	}
+	if config.Interval <= 0 {
+		config.Interval = 24 * time.Hour
+	}
+	return &Verifier{config: config, mirrorConfig: mirrorConfig}
+}
+
+// Run verifies every mirror once per interval until ctx is cancelled, logging unhealthy mirrors
+func (v *Verifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(v.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		results, err := v.VerifyAll(ctx)
+		if err != nil {
+			log.Printf("Warning: Failed to verify mirrors: %v", err)
+		}
+		unhealthy := 0
+		for _, r := range results {
+			if r.Unhealthy() {
+				unhealthy++
+				log.Printf("Warning: Mirror %s is %s: %s", r.Name, r.Status, r.Summary())
+			}
+		}
+		log.Printf("Verified %d mirrors, %d need attention", len(results), unhealthy)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// VerifyAll verifies every mirror of the destination
+func (v *Verifier) VerifyAll(ctx context.Context) ([]Result, error) {
+	service, err := v.destination()
+	if err != nil {
+		return nil, err
+	}
+	mirrors, err := service.ListMirrors()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mirrors: %v", err)
+	}
+
+	results := make([]Result, 0, len(mirrors))
+	for _, m := range mirrors {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		results = append(results, v.Verify(ctx, m))
+	}
+	return results, nil
+}
+
+// destination returns the mirror service of the destination, creating it on first use
+func (v *Verifier) destination() (mirror.MirrorService, error) {
+	if v.service == nil {
+		service, err := mirror.NewMirrorService(v.mirrorConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create mirror service: %v", err)
+		}
+		v.service = service
+	}
+	return v.service, nil
+}
+
+// Verify compares the branches and tags of mirror m with its source. The refs
+// are listed with git ls-remote first, and only when they differ are both sides
+// fetched to count the missing commits.
+//
+// The lag runs from the oldest missing commit, or from the last successful
+// update of the mirror when the destination reports a later one. A commit can be
+// pushed long after it was made, and a mirror that updated since then was only
+// behind from that update on. Update attempts don't count, as they move on
+// while a failing mirror falls further behind.
+func (v *Verifier) Verify(ctx context.Context, m mirror.Repository) Result {
+	result := Result{Name: m.Name}
+	if m.SourceURL == "" {
+		result.Status, result.Reason = StatusSkipped, "source URL unknown"
+		return result
+	}
+	source := mirror.SourceRepository(m)
+
+	sourceURL, err := v.mirrorConfig.SourceCloneURL(source)
+	if err != nil {
+		return failed(result, err)
+	}
+	destURL, err := v.mirrorConfig.DestinationCloneURL(m)
+	if err != nil {
+		return failed(result, err)
+	}
+	// The destination is reached over HTTPS, so the source deploy key only affects the source remote
+	sourceCtx := git.WithEnv(ctx, v.mirrorConfig.SourceGitEnv(source)...)
+
+	sourceRefs, _, err := git.LsRemoteHead(sourceCtx, sourceURL)
+	if err != nil {
+		return failed(result, fmt.Errorf("failed to list source refs: %v", err))
+	}
+	mirrorRefs, _, err := git.LsRemoteHead(ctx, destURL)
+	if err != nil {
+		return failed(result, fmt.Errorf("failed to list mirror refs: %v", err))
+	}
+
+	for ref, sha := range sourceRefs {
+		if mirrorRefs[ref] != sha {
+			result.Refs = append(result.Refs, ref)
+		}
+	}
+	for ref := range mirrorRefs {
+		if _, ok := sourceRefs[ref]; !ok {
+			result.Refs = append(result.Refs, ref)
+		}
+	}
+	if len(result.Refs) == 0 {
+		result.Status = StatusCurrent
+		return result
+	}
+	sort.Strings(result.Refs)
+
+	oldest, err := v.measure(ctx, sourceCtx, sourceURL, destURL, sourceRefs, mirrorRefs, &result)
+	if err != nil {
+		return failed(result, err)
+	}
+	result.Lag = lag(oldest, v.lastUpdate(m), time.Now())
+
+	switch {
+	case result.Status == StatusDiverged:
+	case result.Lag > v.config.MaxLag:
+		result.Status = StatusStale
+	default:
+		result.Status = StatusBehind
+	}
+	return result
+}
+
+// measure fetches the branches and tags of both sides into one repository,
+// counts the source commits the mirror does not have and returns the commit
+// time of the oldest of them
+func (v *Verifier) measure(ctx, sourceCtx context.Context, sourceURL, destURL string, sourceRefs, mirrorRefs map[string]string, result *Result) (time.Time, error) {
+	dir, sourcePrefix, release, err := v.repository(ctx, sourceURL)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer release()
+
+	if len(mirrorRefs) > 0 {
+		if err := git.Fetch(ctx, dir, destURL, "+refs/heads/*:"+mirrorPrefix+"heads/*", "+refs/tags/*:"+mirrorPrefix+"tags/*"); err != nil {
+			return time.Time{}, fmt.Errorf("failed to fetch mirror: %v", err)
+		}
+	}
+	if len(sourceRefs) > 0 {
+		if err := git.Fetch(sourceCtx, dir, sourceURL, "+refs/heads/*:"+sourcePrefix+"heads/*", "+refs/tags/*:"+sourcePrefix+"tags/*"); err != nil {
+			return time.Time{}, fmt.Errorf("failed to fetch source: %v", err)
+		}
+	}
+
+	var sourceTips, mirrorTips []string
+	for _, ref := range result.Refs {
+		name := strings.TrimPrefix(ref, "refs/")
+		_, inSource := sourceRefs[ref]
+		if inSource {
+			sourceTips = append(sourceTips, sourcePrefix+name)
+		}
+		// A ref the source deleted is behind rather than diverged
+		if _, ok := mirrorRefs[ref]; ok && inSource {
+			mirrorTips = append(mirrorTips, mirrorPrefix+name)
+		}
+	}
+
+	// Commits on the source that no mirror ref reaches
+	var oldest time.Time
+	if len(sourceTips) > 0 {
+		args := append(append([]string{"log", "--format=%ct"}, sourceTips...), "--not", "--glob="+mirrorPrefix+"*")
+		out, err := git.Run(ctx, dir, args...)
+		if err != nil {
+			return time.Time{}, err
+		}
+		for _, line := range strings.Fields(string(out)) {
+			ts, err := strconv.ParseInt(line, 10, 64)
+			if err != nil {
+				continue
+			}
+			result.Behind++
+			if commit := time.Unix(ts, 0); oldest.IsZero() || commit.Before(oldest) {
+				oldest = commit
+			}
+		}
+	}
+
+	// Commits on a mirror ref that the source no longer has
+	if len(mirrorTips) > 0 {
+		args := append(append([]string{"rev-list", "--count"}, mirrorTips...), "--not", "--glob="+sourcePrefix+"heads/*", "--glob="+sourcePrefix+"tags/*")
+		out, err := git.Run(ctx, dir, args...)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if n, _ := strconv.Atoi(strings.TrimSpace(string(out))); n > 0 {
+			result.Status = StatusDiverged
+			result.Reason = fmt.Sprintf("%d commits not on the source", n)
+		}
+	}
+	return oldest, nil
+}
+
+// repository returns the repository to fetch both sides of a mirror into and
+// the prefix the source branches and tags are kept under. The cached source
+// repository is used when there is a cache, and the refs of the mirror are
+// removed from it again on release.
+func (v *Verifier) repository(ctx context.Context, sourceURL string) (string, string, func(), error) {
+	if v.config.Cache != nil {
+		dir, release, err := v.config.Cache.Acquire(ctx, sourceURL)
+		if err != nil {
+			return "", "", nil, err
+		}
+		return dir, "refs/", func() {
+			if err := git.DeleteRefs(context.Background(), dir, mirrorPrefix); err != nil {
+				log.Printf("Warning: Failed to clean up verification refs in %s: %v", dir, err)
+			}
+			release()
+		}, nil
+	}
+
+	dir, err := os.MkdirTemp("", "gitcloner-verify-*")
+	if err != nil {
+		return "", "", nil, err
+	}
+	if err := git.InitBare(ctx, dir); err != nil {
+		os.RemoveAll(dir)
+		return "", "", nil, err
+	}
+	return dir, "refs/source/", func() { os.RemoveAll(dir) }, nil
+}
+
+// lastUpdate returns when the destination last successfully updated mirror m,
+// or the zero time when it does not report successful updates
+func (v *Verifier) lastUpdate(m mirror.Repository) time.Time {
+	service, err := v.destination()
+	if err != nil {
+		return time.Time{}
+	}
+	reporter, ok := service.(mirror.HealthReporter)
+	if !ok {
+		return time.Time{}
+	}
+	health, err := reporter.MirrorHealth(m)
+	if err != nil {
+		log.Printf("Warning: Failed to get the last update of mirror %s: %v", m.Name, secret.RedactError(err))
+		return time.Time{}
+	}
+	return health.LastUpdate
+}
+
+// lag returns how long a mirror has been missing source commits, from the
+// later of the oldest missing commit and the last successful update of the mirror
+func lag(oldest, lastUpdate, now time.Time) time.Duration {
+	since := oldest
+	if lastUpdate.After(since) {
+		since = lastUpdate
+	}
+	if since.IsZero() {
+		return 0
+	}
+	return now.Sub(since).Truncate(time.Minute)
+}
+
+func failed(result Result, err error) Result {
+	result.Status, result.Reason = StatusFailed, err.Error()
+	return result
+}
@@ -0,0 +1,146 @@
+package verify
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/git"
+	"github.com/janyksteenbeek/gitcloner/pkg/gitsync"
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+)
+
+// run runs git in dir with a fixed identity and returns its trimmed output
+func run(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Gitcloner", "-c", "user.email=gitcloner@example.com"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// setup returns a source with three commits on main and a mirror that only has the first
+func setup(t *testing.T) (source, dest string) {
+	t.Helper()
+	source = filepath.Join(t.TempDir(), "source")
+	dest = filepath.Join(t.TempDir(), "dest.git")
+	run(t, "", "init", "--quiet", "--initial-branch=main", source)
+	run(t, source, "commit", "--quiet", "--allow-empty", "-m", "first")
+	run(t, "", "clone", "--quiet", "--bare", source, dest)
+	run(t, source, "commit", "--quiet", "--allow-empty", "-m", "second")
+	run(t, source, "commit", "--quiet", "--allow-empty", "-m", "third")
+	return source, dest
+}
+
+func TestMeasure(t *testing.T) {
+	ctx := context.Background()
+	cache, err := gitsync.NewCache(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		cache *gitsync.Cache
+	}{
+		{"temporary repository", nil},
+		{"cache", cache},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			source, dest := setup(t)
+			v := New(mirror.Config{}, Config{Cache: tt.cache})
+
+			sourceRefs := map[string]string{"refs/heads/main": run(t, source, "rev-parse", "HEAD")}
+			mirrorRefs := map[string]string{"refs/heads/main": run(t, dest, "rev-parse", "HEAD")}
+			result := Result{Refs: []string{"refs/heads/main"}}
+
+			oldest, err := v.measure(ctx, ctx, source, dest, sourceRefs, mirrorRefs, &result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Behind != 2 {
+				t.Errorf("Behind = %d, want 2", result.Behind)
+			}
+			if result.Status == StatusDiverged {
+				t.Errorf("mirror reported as diverged: %s", result.Reason)
+			}
+			if oldest.IsZero() {
+				t.Error("oldest missing commit has no time")
+			}
+
+			if tt.cache == nil {
+				return
+			}
+			dir, release, err := tt.cache.Acquire(ctx, source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer release()
+			if got := run(t, dir, "rev-parse", "refs/heads/main"); got != sourceRefs["refs/heads/main"] {
+				t.Errorf("cached main = %s, want the source tip %s", got, sourceRefs["refs/heads/main"])
+			}
+			if refs, err := git.Run(ctx, dir, "for-each-ref", mirrorPrefix); err != nil || len(refs) > 0 {
+				t.Errorf("mirror refs left in the cache: %q, %v", refs, err)
+			}
+		})
+	}
+}
+
+// fakeService reports the health of every mirror as health
+type fakeService struct {
+	mirror.MirrorService
+	health mirror.Health
+}
+
+func (f *fakeService) MirrorHealth(mirror.Repository) (mirror.Health, error) {
+	return f.health, nil
+}
+
+func TestLagIgnoresUpdateAttempts(t *testing.T) {
+	now := time.Now()
+	oldest := now.Add(-30 * 24 * time.Hour)
+	service := &fakeService{}
+	v := New(mirror.Config{}, Config{MaxLag: 24 * time.Hour})
+	v.service = service
+	m := mirror.Repository{Name: "acme-app"}
+
+	// A failing Gitea mirror keeps trying, so its last attempt moves while the commits stay missing
+	for _, attempt := range []time.Duration{3 * time.Hour, 2 * time.Hour, time.Hour} {
+		service.health = mirror.Health{Status: mirror.HealthUnknown, LastAttempt: now.Add(-attempt)}
+		if got := lag(oldest, v.lastUpdate(m), now); got <= v.config.MaxLag {
+			t.Fatalf("lag after an attempt %s ago = %s, want it beyond the maximum lag", attempt, got)
+		}
+	}
+
+	// A successful update proves the mirror was current until then
+	service.health = mirror.Health{Status: mirror.HealthOK, LastUpdate: now.Add(-time.Hour)}
+	if got := lag(oldest, v.lastUpdate(m), now); got != time.Hour {
+		t.Errorf("lag after a successful update = %s, want 1h0m0s", got)
+	}
+}
+
+func TestLag(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		name               string
+		oldest, lastUpdate time.Time
+		want               time.Duration
+	}{
+		{"no missing commits", time.Time{}, time.Time{}, 0},
+		{"no mirror update", now.Add(-3 * time.Hour), time.Time{}, 3 * time.Hour},
+		{"old commit pushed after the last update", now.Add(-30 * 24 * time.Hour), now.Add(-time.Hour), time.Hour},
+		{"mirror not updated since the commit", now.Add(-2 * time.Hour), now.Add(-48 * time.Hour), 2 * time.Hour},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lag(tt.oldest, tt.lastUpdate, now); got != tt.want {
+				t.Errorf("lag = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -38,28 +38,27 @@ func (h *Handler) handleGiteaRepositoryEvent(mirrorService mirror.MirrorService,
 	}
 
 	repo := mirror.Repository{
-		Name:        formatRepoName(payload.Repository.Owner.UserName, payload.Repository.Name),
-		Description: payload.Repository.Description,
-		Private:     payload.Repository.Private,
-		CloneURL:    payload.Repository.CloneURL,
-		Owner:       payload.Repository.Owner.UserName,
+		Name:          mirror.MirrorName(payload.Repository.Owner.UserName, payload.Repository.Name),
+		Description:   payload.Repository.Description,
+		Private:       payload.Repository.Private,
+		CloneURL:      payload.Repository.CloneURL,
+		Owner:         payload.Repository.Owner.UserName,
+		DefaultBranch: payload.Repository.DefaultBranch,
 	}
 
-	return mirrorService.CreateMirror(repo)
+	return h.createMirror(mirrorService, repo)
 }
 
 func (h *Handler) handleGiteaPushEvent(mirrorService mirror.MirrorService, payload types.GiteaWebhookPayload) error {
-	if payload.Ref != "refs/heads/"+payload.Repository.DefaultBranch {
-		return nil
-	}
-
 	repo := mirror.Repository{
-		Name:        formatRepoName(payload.Repository.Owner.UserName, payload.Repository.Name),
-		Description: payload.Repository.Description,
-		Private:     payload.Repository.Private,
-		CloneURL:    payload.Repository.CloneURL,
-		Owner:       payload.Repository.Owner.UserName,
+		Name:          mirror.MirrorName(payload.Repository.Owner.UserName, payload.Repository.Name),
+		Description:   payload.Repository.Description,
+		Private:       payload.Repository.Private,
+		CloneURL:      payload.Repository.CloneURL,
+		Owner:         payload.Repository.Owner.UserName,
+		DefaultBranch: payload.Repository.DefaultBranch,
 	}
 
-	return h.handlePushEvent(mirrorService, repo)
+	// Gitea does not flag forced pushes, so the ancestry of the update is checked
+	return h.handlePush(mirrorService, push{repo: repo, ref: payload.Ref, before: payload.Before, after: payload.After, confirm: true})
 }
@@ -1,17 +1,29 @@
 package webhook
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/source"
 	"github.com/janyksteenbeek/gitcloner/pkg/webhook/types"
 )
 
 func (h *Handler) handleGitHubWebhook(r *http.Request) error {
 	eventType := r.Header.Get("X-GitHub-Event")
 
+	// GitHub Enterprise Server deliveries name the instance they come from
+	if host := r.Header.Get("X-GitHub-Enterprise-Host"); host != "" {
+		if err := h.checkEnterpriseHost(host); err != nil {
+			return err
+		}
+	}
+
 	// Parse the form if content type is form-urlencoded
 	if r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
 		if err := r.ParseForm(); err != nil {
@@ -47,26 +59,120 @@ func (h *Handler) handleGitHubPayload(eventType string, payload types.GitHubWebh
 	case "repository":
 		if payload.Action == "created" {
 			repo := mirror.Repository{
-				Name:        formatRepoName(payload.Repository.Owner.Login, payload.Repository.Name),
-				Description: payload.Repository.Description,
-				Private:     payload.Repository.Private,
-				CloneURL:    payload.Repository.CloneURL,
-				Owner:       payload.Repository.Owner.Login,
+				Name:          mirror.MirrorName(payload.Repository.Owner.Login, payload.Repository.Name),
+				Description:   payload.Repository.Description,
+				Private:       payload.Repository.Private,
+				CloneURL:      payload.Repository.CloneURL,
+				Owner:         payload.Repository.Owner.Login,
+				DefaultBranch: payload.Repository.DefaultBranch,
 			}
-			return mirrorService.CreateMirror(repo)
+			return h.createMirror(mirrorService, repo)
 		}
 	case "push":
-		if payload.Ref == fmt.Sprintf("refs/heads/%s", payload.Repository.DefaultBranch) {
-			repo := mirror.Repository{
-				Name:        formatRepoName(payload.Repository.Owner.Login, payload.Repository.Name),
-				Description: payload.Repository.Description,
-				Private:     payload.Repository.Private,
-				CloneURL:    payload.Repository.CloneURL,
-				Owner:       payload.Repository.Owner.Login,
-			}
-			return h.handlePushEvent(mirrorService, repo)
+		repo := mirror.Repository{
+			Name:          mirror.MirrorName(payload.Repository.Owner.Login, payload.Repository.Name),
+			Description:   payload.Repository.Description,
+			Private:       payload.Repository.Private,
+			CloneURL:      payload.Repository.CloneURL,
+			Owner:         payload.Repository.Owner.Login,
+			DefaultBranch: payload.Repository.DefaultBranch,
+		}
+
+		// Keep the overwritten history of any ref, not just the default branch
+		return h.handlePush(mirrorService, push{
+			repo:    repo,
+			ref:     payload.Ref,
+			before:  payload.Before,
+			after:   payload.After,
+			flagged: true,
+			forced:  payload.Forced || payload.Deleted,
+			confirm: true,
+		})
+	case "installation":
+		switch payload.Action {
+		case "created":
+			return h.mirrorInstallationRepositories(mirrorService, payload.Repositories)
+		case "deleted":
+			return h.removeInstallationRepositories(mirrorService, payload.Repositories)
+		}
+	case "installation_repositories":
+		switch payload.Action {
+		case "added":
+			return h.mirrorInstallationRepositories(mirrorService, payload.RepositoriesAdded)
+		case "removed":
+			return h.removeInstallationRepositories(mirrorService, payload.RepositoriesRemoved)
+		}
+	}
+
+	return nil
+}
+
+// mirrorInstallationRepositories creates mirrors for repositories added to the GitHub App installation.
+// Installation payloads only carry repository names, so the metadata is fetched from the API.
+func (h *Handler) mirrorInstallationRepositories(mirrorService mirror.MirrorService, repos []types.GitHubInstallationRepository) error {
+	src := source.NewGitHub(h.mirrorConfig)
+
+	var errs []error
+	for _, r := range repos {
+		owner, name, ok := strings.Cut(r.FullName, "/")
+		if !ok {
+			continue
+		}
+
+		repo, err := src.GetRepository(context.Background(), owner, name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.FullName, err))
+			continue
+		}
+		repo.Name = mirror.MirrorName(repo.Owner, repo.Name)
+
+		if err := mirrorService.CreateMirror(*repo); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.FullName, err))
+			continue
 		}
 	}
 
+	return errors.Join(errs...)
+}
+
+// removeInstallationRepositories applies the removal policy to repositories removed from the GitHub App installation
+func (h *Handler) removeInstallationRepositories(mirrorService mirror.MirrorService, repos []types.GitHubInstallationRepository) error {
+	var errs []error
+	for _, r := range repos {
+		owner, name, ok := strings.Cut(r.FullName, "/")
+		if !ok {
+			continue
+		}
+
+		// The mirror must pull from the removed repository on the configured GitHub source
+		repo := mirror.Repository{
+			Name:     mirror.MirrorName(owner, name),
+			Owner:    owner,
+			Private:  r.Private,
+			CloneURL: h.mirrorConfig.GitHubSourceURL() + "/" + r.FullName + ".git",
+		}
+		if err := mirror.RemoveMirror(mirrorService, h.mirrorConfig.RemovalPolicy, repo); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.FullName, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkEnterpriseHost rejects deliveries from a GitHub Enterprise Server other than the configured source
+func (h *Handler) checkEnterpriseHost(host string) error {
+	sourceURL := h.mirrorConfig.GitHubSourceURL()
+	if !mirror.IsGitHubEnterpriseURL(sourceURL) {
+		// No enterprise source configured, accept deliveries from any instance
+		return nil
+	}
+
+	parsedURL, err := url.Parse(sourceURL)
+	if err != nil {
+		return fmt.Errorf("invalid GitHub source URL: %v", err)
+	}
+	if !strings.EqualFold(parsedURL.Hostname(), host) {
+		return fmt.Errorf("unexpected GitHub Enterprise host %q", host)
+	}
 	return nil
 }
@@ -0,0 +1,51 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+)
+
+func TestCheckEnterpriseHost(t *testing.T) {
+	tests := []struct {
+		name      string
+		sourceURL string
+		host      string
+		wantErr   bool
+	}{
+		{"configured instance", "https://github.example.com", "github.example.com", false},
+		{"host is case-insensitive", "https://github.example.com/", "GitHub.Example.com", false},
+		{"instance with a port", "https://github.example.com:8443", "github.example.com", false},
+		{"other instance", "https://github.example.com", "github.evil.example", true},
+		{"no enterprise source", "", "github.example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(mirror.Config{SourceGitHubURL: tt.sourceURL})
+			err := h.checkEnterpriseHost(tt.host)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkEnterpriseHost(%q) = %v, want error %v", tt.host, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEnterpriseDeliveryFromOtherHost(t *testing.T) {
+	h := NewHandler(mirror.Config{SourceGitHubURL: "https://github.example.com"})
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{}`))
+	req.Header.Set("X-GitHub-Event", "push")
+	req.Header.Set("X-GitHub-Enterprise-Host", "github.evil.example")
+	w := httptest.NewRecorder()
+	h.HandleWebhook(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if strings.Contains(w.Body.String(), "evil") {
+		t.Errorf("response leaks the rejected host: %s", w.Body.String())
+	}
+}
@@ -22,26 +22,46 @@ func (h *Handler) handleGitLabWebhook(r *http.Request) error {
 
 	switch {
 	case payload.ObjectKind == "project" && payload.EventType == "project_create":
-		repo := mirror.Repository{
-			Name:        formatRepoName(getOwnerFromPath(payload.Project.PathWithNamespace), payload.Project.Name),
-			Description: payload.Project.Description,
-			Private:     payload.Project.VisibilityLevel < 20,
-			CloneURL:    payload.Project.GitHTTPURL,
-			Owner:       getOwnerFromPath(payload.Project.PathWithNamespace),
-		}
-		return mirrorService.CreateMirror(repo)
+		return h.createMirror(mirrorService, h.gitlabCreatedRepository(payload))
 	case payload.ObjectKind == "push":
-		if payload.Ref == "refs/heads/"+payload.Project.DefaultBranch {
-			repo := mirror.Repository{
-				Name:        formatRepoName(getOwnerFromPath(payload.Project.PathWithNamespace), payload.Project.Name),
-				Description: payload.Project.Description,
-				Private:     payload.Project.VisibilityLevel < 20,
-				CloneURL:    payload.Project.GitHTTPURL,
-				Owner:       getOwnerFromPath(payload.Project.PathWithNamespace),
-			}
-			return h.handlePushEvent(mirrorService, repo)
+		repo := mirror.Repository{
+			Name:          mirror.MirrorName(getOwnerFromPath(payload.Project.PathWithNamespace), payload.Project.Name),
+			Description:   payload.Project.Description,
+			Private:       payload.Project.VisibilityLevel < 20,
+			CloneURL:      payload.Project.GitHTTPURL,
+			Owner:         getOwnerFromPath(payload.Project.PathWithNamespace),
+			DefaultBranch: payload.Project.DefaultBranch,
 		}
+
+		// GitLab does not flag forced pushes, so the ancestry of the update is checked
+		return h.handlePush(mirrorService, push{repo: repo, ref: payload.Ref, before: payload.Before, after: payload.After, confirm: true})
 	}
 
 	return nil
 }
+
+// gitlabCreatedRepository builds the repository of a project_create event. These
+// events name the project at the top level and leave out the clone URL, which is
+// then derived from the GitLab source.
+func (h *Handler) gitlabCreatedRepository(payload types.GitLabWebhookPayload) mirror.Repository {
+	path, name := payload.Project.PathWithNamespace, payload.Project.Name
+	private := payload.Project.VisibilityLevel < 20
+	if path == "" {
+		path, name = payload.PathWithNamespace, payload.Name
+		private = payload.ProjectVisibility != "public"
+	}
+
+	cloneURL := payload.Project.GitHTTPURL
+	if cloneURL == "" && path != "" {
+		cloneURL = h.mirrorConfig.GitLabSourceURL() + "/" + path + ".git"
+	}
+
+	return mirror.Repository{
+		Name:          mirror.MirrorName(getOwnerFromPath(path), name),
+		Description:   payload.Project.Description,
+		Private:       private,
+		CloneURL:      cloneURL,
+		Owner:         getOwnerFromPath(path),
+		DefaultBranch: payload.Project.DefaultBranch,
+	}
+}
@@ -1,24 +1,74 @@
 package webhook
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"sync"
 
+	"github.com/janyksteenbeek/gitcloner/pkg/git"
+	"github.com/janyksteenbeek/gitcloner/pkg/gitsync"
 	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/preserve"
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
+	"github.com/janyksteenbeek/gitcloner/pkg/source"
 )
 
+// ErrSourceMismatch is returned when a webhook payload does not match the repository on its source
+var ErrSourceMismatch = errors.New("webhook payload does not match the source")
+
 type Handler struct {
 	mirrorConfig mirror.Config
 	repoCache    *sync.Map
+	preserver    *preserve.Preserver
+	syncer       *gitsync.Syncer
+	pushes       *pushQueue
+
+	confirmSources bool
+}
+
+// Option configures optional behaviour of a Handler
+type Option func(*Handler)
+
+// WithPreserver keeps the previous value of force-pushed and deleted refs
+// before mirrors are synced. Push events are then applied in the background,
+// so the webhook doesn't wait for the previous value to be fetched.
+func WithPreserver(preserver *preserve.Preserver) Option {
+	return func(h *Handler) {
+		h.preserver = preserver
+	}
+}
+
+// WithSyncer pushes every ref update to the git destinations of syncer
+func WithSyncer(syncer *gitsync.Syncer) Option {
+	return func(h *Handler) {
+		h.syncer = syncer
+	}
+}
+
+// WithSourceConfirmation looks repositories up on their source forge before a
+// mirror is created, rejecting events that do not match it
+func WithSourceConfirmation() Option {
+	return func(h *Handler) {
+		h.confirmSources = true
+	}
 }
 
-func NewHandler(config mirror.Config) *Handler {
-	return &Handler{
+func NewHandler(config mirror.Config, opts ...Option) *Handler {
+	h := &Handler{
 		mirrorConfig: config,
 		repoCache:    &sync.Map{},
+		pushes:       newPushQueue(),
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
+	return h
 }
 
 func (h *Handler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
@@ -42,20 +92,100 @@ func (h *Handler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to handle webhook: %v", err), http.StatusInternalServerError)
+		// The sender only gets an ID to correlate with the log, internal details stay on the server
+		id := errorID()
+		log.Printf("Failed to handle webhook [%s]: %v", id, secret.RedactError(err))
+		http.Error(w, fmt.Sprintf("Failed to handle webhook, error ID %s", id), http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
-func (h *Handler) handlePushEvent(mirrorService mirror.MirrorService, repo mirror.Repository) error {
+// HandleRefUpdate applies a ref update found without a webhook, such as by
+// polling the source, the same way as a push event. The repository comes from
+// the source itself, so a missing mirror is created without confirming it.
+// The update is queued behind the push events of the same mirror and its
+// result returned once it has been applied.
+func (h *Handler) HandleRefUpdate(repo mirror.Repository, ref, before, after string) error {
+	mirrorService, err := mirror.NewMirrorService(h.mirrorConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create mirror service: %v", err)
+	}
+
+	p := push{repo: repo, ref: ref, before: before, after: after}
+	if h.preserver == nil {
+		return h.applyPush(mirrorService, p)
+	}
+
+	done := make(chan error, 1)
+	h.pushes.add(repo.Name, func() {
+		done <- h.applyPush(mirrorService, p)
+	})
+	return <-done
+}
+
+// push is a ref update on the source
+type push struct {
+	repo               mirror.Repository
+	ref, before, after string
+
+	// flagged is set when the source tells whether the push overwrote history,
+	// which it then did when forced is set
+	flagged, forced bool
+
+	// confirm looks the repository up on its source before the mirror is changed
+	confirm bool
+}
+
+// handlePush applies a push event. With a preserver, the push is queued
+// behind the earlier pushes of the mirror and applied in the background, where
+// a failure is logged instead of returned.
+func (h *Handler) handlePush(mirrorService mirror.MirrorService, p push) error {
+	if h.preserver == nil {
+		return h.applyPush(mirrorService, p)
+	}
+
+	h.pushes.add(p.repo.Name, func() {
+		if err := h.applyPush(mirrorService, p); err != nil {
+			log.Printf("Failed to apply push to %s of %s: %v", p.ref, p.repo.Name, secret.RedactError(err))
+		}
+	})
+	return nil
+}
+
+// applyPush preserves the previous value of the ref when the push overwrites
+// it, then syncs the ref to the git destinations and the mirror when the
+// default branch changed
+func (h *Handler) applyPush(mirrorService mirror.MirrorService, p push) error {
+	h.preserveRef(mirrorService, p)
+
+	if err := h.syncRef(p.repo, p.ref, p.before, p.after); err != nil {
+		return err
+	}
+
+	if p.ref != "refs/heads/"+p.repo.DefaultBranch {
+		return nil
+	}
+
+	return h.updateMirror(mirrorService, p.repo, p.confirm)
+}
+
+// updateMirror brings the mirror of repo up to date, creating it when it does
+// not exist yet. With confirm, repo comes from an event payload and is
+// confirmed against its source before the mirror is created or updated from it.
+func (h *Handler) updateMirror(mirrorService mirror.MirrorService, repo mirror.Repository, confirm bool) error {
 	exists, isMirror, needsUpdate, err := mirrorService.CheckRepository(repo)
 	if err != nil {
 		return fmt.Errorf("failed to check repository: %v", err)
 	}
 
 	if !exists {
+		if confirm {
+			if repo, err = h.confirmSource(repo); err != nil {
+				return err
+			}
+		}
 		if err := mirrorService.CreateMirror(repo); err != nil {
 			return fmt.Errorf("failed to create repository: %v", err)
 		}
@@ -67,6 +197,12 @@ func (h *Handler) handlePushEvent(mirrorService mirror.MirrorService, repo mirro
 	}
 
 	if needsUpdate {
+		// The payload only tells that something changed, the metadata comes from the source
+		if confirm {
+			if repo, err = h.confirmSource(repo); err != nil {
+				return err
+			}
+		}
 		if err := mirrorService.UpdateRepository(repo); err != nil {
 			return fmt.Errorf("failed to update repository: %v", err)
 		}
@@ -80,3 +216,124 @@ func (h *Handler) handlePushEvent(mirrorService mirror.MirrorService, repo mirro
 	// Sync the repository
 	return mirrorService.SyncRepository(repo)
 }
+
+// createMirror creates the mirror of repo once it is confirmed against its source
+func (h *Handler) createMirror(mirrorService mirror.MirrorService, repo mirror.Repository) error {
+	repo, err := h.confirmSource(repo)
+	if err != nil {
+		return err
+	}
+	return mirrorService.CreateMirror(repo)
+}
+
+// confirmSource looks repo up on its source forge with the source credentials.
+// The repository must exist and match the visibility and default branch of the
+// payload, and is returned with the authoritative values from the source.
+func (h *Handler) confirmSource(repo mirror.Repository) (mirror.Repository, error) {
+	if !h.confirmSources {
+		return repo, nil
+	}
+
+	src, owner, name, err := source.ForRepository(h.mirrorConfig, repo)
+	if err != nil {
+		return repo, fmt.Errorf("failed to confirm %s: %v", repo.Name, err)
+	}
+
+	actual, err := src.GetRepository(context.Background(), owner, name)
+	if err != nil {
+		if errors.Is(err, source.ErrRepositoryNotFound) {
+			log.Printf("Security: rejected event for %s: %s/%s does not exist on the source", repo.Name, owner, name)
+		}
+		return repo, fmt.Errorf("failed to confirm %s: %w", repo.Name, err)
+	}
+
+	switch {
+	case actual.Private != repo.Private:
+		log.Printf("Security: rejected event for %s: payload visibility does not match the source", repo.Name)
+		return repo, fmt.Errorf("%w: visibility of %s", ErrSourceMismatch, repo.Name)
+	case repo.DefaultBranch != "" && actual.DefaultBranch != repo.DefaultBranch:
+		log.Printf("Security: rejected event for %s: payload default branch %q does not match %q on the source", repo.Name, repo.DefaultBranch, actual.DefaultBranch)
+		return repo, fmt.Errorf("%w: default branch of %s", ErrSourceMismatch, repo.Name)
+	}
+
+	// The mirror keeps its name, everything else comes from the source
+	repo.Description = actual.Description
+	repo.Private = actual.Private
+	repo.DefaultBranch = actual.DefaultBranch
+	if actual.CloneURL != "" {
+		repo.CloneURL = actual.CloneURL
+	}
+	return repo, nil
+}
+
+// preserveRef keeps the previous value of a force-pushed or deleted ref before
+// the mirror is synced. For sources that don't flag forced pushes, the
+// ancestry of before and after is checked instead. A failure is logged but
+// doesn't hold up the sync.
+func (h *Handler) preserveRef(mirrorService mirror.MirrorService, p push) {
+	if h.preserver == nil || p.before == "" || p.before == git.ZeroSHA || (p.flagged && !p.forced) {
+		return
+	}
+	repo, ref, before, after := p.repo, p.ref, p.before, p.after
+
+	existing, err := mirrorService.GetMirror(repo)
+	if err != nil {
+		log.Printf("Warning: Failed to preserve %s of %s: failed to look up mirror: %v", ref, repo.Name, secret.RedactError(err))
+		return
+	}
+	if existing == nil {
+		// Nothing has been mirrored yet, so there is no history to lose
+		return
+	}
+
+	// The mirror still holds the old history until it syncs, so try it before the source
+	var remotes []string
+	if cloneURL, err := h.mirrorConfig.DestinationCloneURL(*existing); err == nil {
+		remotes = append(remotes, cloneURL)
+	}
+	if cloneURL, err := h.mirrorConfig.SourceCloneURL(repo); err == nil {
+		remotes = append(remotes, cloneURL)
+	}
+
+	// The destination is reached over HTTPS, so the source deploy key only affects the source remote
+	ctx := git.WithEnv(context.Background(), h.mirrorConfig.SourceGitEnv(repo)...)
+	if !p.flagged {
+		overwrites, err := h.preserver.Overwrites(ctx, repo.Name, ref, before, after, remotes...)
+		if err != nil {
+			// Preserving too much is cheaper than losing history
+			log.Printf("Warning: Failed to check whether %s of %s was force-pushed: %v", ref, repo.Name, secret.RedactError(err))
+		} else if !overwrites {
+			return
+		}
+	}
+
+	preserved, err := h.preserver.Preserve(ctx, repo.Name, ref, before, remotes...)
+	if err != nil {
+		log.Printf("Warning: Failed to preserve %s of %s: %v", ref, repo.Name, secret.RedactError(err))
+		return
+	}
+	if preserved != "" {
+		log.Printf("Preserved %s of %s as %s", ref, repo.Name, preserved)
+	}
+}
+
+// syncRef pushes a single ref update to the git destinations
+func (h *Handler) syncRef(repo mirror.Repository, ref, before, after string) error {
+	if h.syncer == nil {
+		return nil
+	}
+
+	return h.syncer.Sync(context.Background(), gitsync.Event{
+		Repo:   repo,
+		Ref:    ref,
+		Before: before,
+		After:  after,
+	})
+}
+
+// errorID returns a random ID that ties an error response to its log entry
+func errorID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
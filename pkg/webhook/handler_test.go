@@ -0,0 +1,91 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/janyksteenbeek/gitcloner/pkg/mirror"
+	"github.com/janyksteenbeek/gitcloner/pkg/secret"
+)
+
+// fakeMirrorService is a destination where every repository exists as a mirror that needs an update
+type fakeMirrorService struct {
+	mirror.MirrorService
+	updated []mirror.Repository
+}
+
+func (f *fakeMirrorService) CheckRepository(repo mirror.Repository) (bool, bool, bool, error) {
+	return true, true, true, nil
+}
+
+func (f *fakeMirrorService) UpdateRepository(repo mirror.Repository) error {
+	f.updated = append(f.updated, repo)
+	return nil
+}
+
+func (f *fakeMirrorService) NeedsManualSync() bool { return false }
+
+// newSourceServer serves the GitHub Enterprise Server REST API with a single private repository acme/app
+func newSourceServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/api/v3/repos/acme/app" {
+			http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"name":           "app",
+			"description":    "The app",
+			"private":        true,
+			"clone_url":      "http://" + r.Host + "/acme/app.git",
+			"default_branch": "trunk",
+			"owner":          map[string]any{"login": "acme"},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPushEventUpdatesFromSource(t *testing.T) {
+	server := newSourceServer(t)
+	h := NewHandler(mirror.Config{
+		SourceGitHubURL:   server.URL,
+		SourceTokenSecret: secret.NewValue("source-token"),
+	}, WithSourceConfirmation())
+
+	tests := []struct {
+		name    string
+		repo    mirror.Repository
+		wantErr bool
+	}{
+		{"description from the source", mirror.Repository{Description: "Spoofed", Private: true}, false},
+		{"visibility mismatch", mirror.Repository{Description: "Spoofed", Private: false}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := tt.repo
+			repo.Name = "acme-app"
+			repo.Owner = "acme"
+			repo.CloneURL = server.URL + "/acme/app.git"
+			repo.DefaultBranch = "trunk"
+
+			service := &fakeMirrorService{}
+			err := h.updateMirror(service, repo, true)
+			if tt.wantErr {
+				if err == nil || len(service.updated) != 0 {
+					t.Fatalf("updateMirror() = %v, updated %v", err, service.updated)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(service.updated) != 1 || service.updated[0].Description != "The app" {
+				t.Errorf("updated = %+v, want the description of the source", service.updated)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package webhook
+
+import "sync"
+
+// pushQueue runs the jobs of each mirror one at a time in the order they were
+// added, in the background so a webhook doesn't wait for them
+type pushQueue struct {
+	mu      sync.Mutex
+	pending map[string][]func()
+	running sync.WaitGroup
+}
+
+func newPushQueue() *pushQueue {
+	return &pushQueue{pending: make(map[string][]func())}
+}
+
+// add queues job behind the earlier jobs of the mirror called name
+func (q *pushQueue) add(name string, job func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pending[name] = append(q.pending[name], job)
+	if len(q.pending[name]) == 1 {
+		q.running.Add(1)
+		go q.drain(name)
+	}
+}
+
+// drain runs the jobs of name until none are left. A job stays queued while it
+// runs, so add doesn't start a second worker for the same mirror.
+func (q *pushQueue) drain(name string) {
+	defer q.running.Done()
+	for {
+		q.mu.Lock()
+		jobs := q.pending[name]
+		if len(jobs) == 0 {
+			delete(q.pending, name)
+			q.mu.Unlock()
+			return
+		}
+		q.mu.Unlock()
+
+		jobs[0]()
+
+		q.mu.Lock()
+		q.pending[name] = q.pending[name][1:]
+		q.mu.Unlock()
+	}
+}
+
+// wait blocks until every queued job has run
+func (q *pushQueue) wait() {
+	q.running.Wait()
+}
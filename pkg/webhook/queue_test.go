@@ -0,0 +1,51 @@
+package webhook
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPushQueueOrder(t *testing.T) {
+	q := newPushQueue()
+
+	var mu sync.Mutex
+	var ran []string
+	record := func(job string) func() {
+		return func() {
+			// The first job is the slowest, a second worker would overtake it
+			if job == "app 1" {
+				time.Sleep(20 * time.Millisecond)
+			}
+			mu.Lock()
+			ran = append(ran, job)
+			mu.Unlock()
+		}
+	}
+
+	for _, job := range []string{"app 1", "app 2", "app 3"} {
+		q.add("acme-app", record(job))
+	}
+	q.add("acme-api", record("api 1"))
+	q.wait()
+
+	var app []string
+	for _, job := range ran {
+		if job != "api 1" {
+			app = append(app, job)
+		}
+	}
+	if want := []string{"app 1", "app 2", "app 3"}; !slices.Equal(app, want) {
+		t.Errorf("acme-app jobs ran as %v, want %v", app, want)
+	}
+	if len(ran) != 4 {
+		t.Errorf("ran %v, want every job", ran)
+	}
+	if ran[0] != "api 1" {
+		t.Errorf("ran %v, want acme-api not to wait for acme-app", ran)
+	}
+	if len(q.pending) != 0 {
+		t.Errorf("pending = %v, want no mirrors left", q.pending)
+	}
+}
@@ -7,6 +7,7 @@ type GiteaWebhookPayload struct {
 	Action     string           `json:"action"`
 	Repository gitea.Repository `json:"repository"`
 	Ref        string           `json:"ref"`
+	Before     string           `json:"before"`
 	After      string           `json:"after"`
 }
 
@@ -64,14 +65,40 @@ type GitHubWebhookPayload struct {
 		AvatarURL string `json:"avatar_url"`
 		Type      string `json:"type"`
 	} `json:"sender"`
+	Installation struct {
+		ID      int64 `json:"id"`
+		Account struct {
+			Login string `json:"login"`
+			Type  string `json:"type"`
+		} `json:"account"`
+	} `json:"installation,omitempty"`
+	Repositories        []GitHubInstallationRepository `json:"repositories,omitempty"`
+	RepositoriesAdded   []GitHubInstallationRepository `json:"repositories_added,omitempty"`
+	RepositoriesRemoved []GitHubInstallationRepository `json:"repositories_removed,omitempty"`
+}
+
+// GitHubInstallationRepository is the short repository form sent with GitHub App installation events
+type GitHubInstallationRepository struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	Private  bool   `json:"private"`
 }
 
 // GitLabWebhookPayload represents a GitLab webhook payload
 type GitLabWebhookPayload struct {
 	EventType  string `json:"event_type"`
 	ObjectKind string `json:"object_kind"`
-	Ref        string `json:"ref,omitempty"`
-	Project    struct {
+
+	// Set on project_create events, which carry the project at the top level instead of in Project
+	Name              string `json:"name,omitempty"`
+	PathWithNamespace string `json:"path_with_namespace,omitempty"`
+	ProjectVisibility string `json:"project_visibility,omitempty"`
+
+	Ref     string `json:"ref,omitempty"`
+	Before  string `json:"before,omitempty"`
+	After   string `json:"after,omitempty"`
+	Project struct {
 		ID                int64  `json:"id"`
 		Name              string `json:"name"`
 		Description       string `json:"description"`